@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zabrahams/gochip8/chip8/asm"
+	"github.com/zabrahams/gochip8/chip8/octo"
+	"github.com/zabrahams/gochip8/symbols"
+)
+
+// asmOptions holds the options that only the asm and octo commands take.
+type asmOptions struct {
+	outFile     string
+	symbolsFile string
+}
+
+func (a *asmOptions) register(flags *flag.FlagSet) {
+	flags.StringVar(&a.outFile, "o", "", "file to write the rom to")
+	flags.StringVar(&a.symbolsFile, "symbols", "", "file to write the symbols to")
+}
+
+// runAsm assembles sourceFile into a rom. A mistake in the source is printed
+// as file:line:col: message, and exits with an error rather than panicking,
+// since it's the user's to fix.
+func runAsm(sourceFile string, opts options) {
+	prog, err := asm.AssembleFile(sourceFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	writeProgram(sourceFile, opts, prog.Bytes, prog.Symbols)
+}
+
+// runOcto compiles the Octo sourceFile into a rom, like runAsm.
+func runOcto(sourceFile string, opts options) {
+	prog, err := octo.CompileFile(sourceFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	writeProgram(sourceFile, opts, prog.Bytes, prog.Symbols)
+}
+
+// writeProgram writes the rom built from sourceFile, and its symbols if they
+// were asked for.
+func writeProgram(sourceFile string, opts options, program []byte, table *symbols.Table) {
+	outFile := opts.asm.outFile
+	if outFile == "" {
+		outFile = strings.TrimSuffix(sourceFile, filepath.Ext(sourceFile)) + ".ch8"
+		if outFile == sourceFile {
+			panic("the rom would overwrite the source, so it needs a name from -o")
+		}
+	}
+	if err := ioutil.WriteFile(outFile, program, 0644); err != nil {
+		panic(err)
+	}
+	fmt.Printf("Built %d bytes into %s\n", len(program), outFile)
+	if opts.asm.symbolsFile != "" {
+		writeFile(opts.asm.symbolsFile, table.Write)
+	}
+}
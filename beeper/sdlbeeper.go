@@ -3,18 +3,31 @@ package beeper
 import (
 	"fmt"
 	"io/ioutil"
+	"sync"
 
 	"github.com/veandco/go-sdl2/mix"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
+const (
+	SAMPLE_RATE = 44100
+	CHANNELS    = 4
+
+	// PATTERN_LENGTH is how many seconds an XO-CHIP audio pattern is played
+	// for on each beep.
+	PATTERN_LENGTH = 0.25
+)
+
 type SDLBeeper struct {
 	data []byte
+
+	mutex   *sync.Mutex
+	pattern []byte
 }
 
 // For now assume that sld is initialized, I'll update that later.
 func NewSDLBeeper() *SDLBeeper {
-	if err := mix.OpenAudio(44100, mix.DEFAULT_FORMAT, 4, 4096); err != nil {
+	if err := mix.OpenAudio(SAMPLE_RATE, mix.DEFAULT_FORMAT, CHANNELS, 4096); err != nil {
 		panic(err)
 	}
 
@@ -23,16 +36,28 @@ func NewSDLBeeper() *SDLBeeper {
 		panic(err)
 	}
 
-	return &SDLBeeper{data: data}
+	return &SDLBeeper{data: data, mutex: &sync.Mutex{}}
 }
 
 func (b *SDLBeeper) Beep() {
-	chunk, err := mix.QuickLoadWAV(b.data)
-	defer chunk.Free()
+	b.mutex.Lock()
+	pattern := b.pattern
+	b.mutex.Unlock()
+
+	var (
+		chunk *mix.Chunk
+		err   error
+	)
+	if pattern != nil {
+		chunk, err = mix.QuickLoadRAW(&pattern[0], uint32(len(pattern)))
+	} else {
+		chunk, err = mix.QuickLoadWAV(b.data)
+	}
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		return
 	}
+	defer chunk.Free()
 
 	_, err = chunk.Play(-1, 0)
 	if err != nil {
@@ -44,6 +69,27 @@ func (b *SDLBeeper) Beep() {
 	}
 }
 
+// SetPattern renders an XO-CHIP audio pattern into raw 16 bit samples, which
+// are played instead of the beep sound from then on.
+func (b *SDLBeeper) SetPattern(pattern [16]byte, rate float64) {
+	frames := int(SAMPLE_RATE * PATTERN_LENGTH)
+	raw := make([]byte, 0, frames*CHANNELS*2)
+	for f := 0; f < frames; f++ {
+		bit := int(float64(f)*rate/SAMPLE_RATE) % 128
+		var sample int16 = -8192
+		if pattern[bit/8]&(0x80>>uint(bit%8)) > 0 {
+			sample = 8192
+		}
+		for c := 0; c < CHANNELS; c++ {
+			raw = append(raw, byte(sample), byte(sample>>8))
+		}
+	}
+
+	b.mutex.Lock()
+	b.pattern = raw
+	b.mutex.Unlock()
+}
+
 func (b *SDLBeeper) Close() {
 	mix.CloseAudio()
 }
@@ -0,0 +1,348 @@
+// Package asm assembles Chip8 programs written with the mnemonics that
+// chip8.Mnemonic and the disassembler use, e.g.
+//
+//	start:
+//	    LD V1, 0x05       ; comments start with a semicolon
+//	    LD I, sprite
+//	    DRW V0, V1, 0x5
+//	    JP start
+//	sprite:
+//	    db 0b11110000, 0x90, 0x90, 0x90, 0xF0
+//
+// As well as the instructions it understands:
+//
+//	name:             a label, the address of whatever comes after it
+//	name = expr       a constant
+//	db expr, ...      bytes of data
+//	dw expr, ...      16 bit words of data, big endian
+//	include "file"    the lines of another file, relative to this one
+//
+// An expression is numbers, labels and constants added and subtracted, where
+// a number is decimal, hex with 0x or binary with 0b.
+package asm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/symbols"
+)
+
+// Error is a mistake in the source, found at a line and column of a file.
+type Error struct {
+	File string
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Col, e.Msg)
+}
+
+// Program is an assembled program.
+//
+// Bytes: the program, to be loaded at chip8.PROGRAM_OFFSET
+//
+// Symbols: the labels, and the source line each instruction was assembled
+// from
+type Program struct {
+	Bytes   []byte
+	Symbols *symbols.Table
+}
+
+// AssembleFile assembles the source file filename.
+func AssembleFile(filename string) (*Program, error) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Assemble(filename, src)
+}
+
+// Assemble assembles src, which was read from filename. Files it includes are
+// found relative to filename. The first mistake found is returned as an
+// *Error.
+func Assemble(filename string, src []byte) (*Program, error) {
+	a := &assembler{
+		labels:  map[string]uint16{},
+		consts:  map[string]*constant{},
+		symbols: symbols.New(),
+	}
+	lines, err := readLines(filename, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.layout(lines); err != nil {
+		return nil, err
+	}
+	return a.encode()
+}
+
+// line is a line of source.
+type line struct {
+	file string
+	num  int
+	text string
+}
+
+// errorf returns an *Error at col of the line.
+func (l line) errorf(col int, format string, args ...interface{}) *Error {
+	return &Error{File: l.file, Line: l.num, Col: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// readLines splits src, read from filename, into lines, with the lines of the
+// files it includes in place of the includes. including holds the files that
+// are being included, to catch a file that includes itself.
+func readLines(filename string, src []byte, including []string) ([]line, error) {
+	var lines []line
+	for i, text := range strings.Split(string(src), "\n") {
+		l := line{file: filename, num: i + 1, text: strings.TrimRight(text, "\r")}
+		p, err := parseLine(l)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(p.op.text, "include") || p.constant {
+			lines = append(lines, l)
+			continue
+		}
+
+		if len(p.labels) > 0 {
+			return nil, l.errorf(p.labels[0].col, "an include can't have a label")
+		}
+		if len(p.args) != 1 {
+			return nil, l.errorf(p.op.col, "include needs a file name in quotes")
+		}
+		name, ok := unquote(p.args[0].text)
+		if !ok {
+			return nil, l.errorf(p.args[0].col, "include needs a file name in quotes")
+		}
+		path := filepath.Join(filepath.Dir(filename), name)
+		for _, f := range append(including, filename) {
+			if f == path {
+				return nil, l.errorf(p.args[0].col, "%s includes itself", path)
+			}
+		}
+		included, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, l.errorf(p.args[0].col, "can't include %s: %v", path, err)
+		}
+		more, err := readLines(path, included, append(including, filename))
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, more...)
+	}
+	return lines, nil
+}
+
+// statement is an instruction or data directive, at addr.
+type statement struct {
+	line line
+	addr uint16
+	op   token
+	args []token
+}
+
+// constant is a name given a value with =. Its value is worked out when it's
+// first used, since it can refer to labels that come later.
+type constant struct {
+	line      line
+	expr      token
+	value     int
+	done      bool
+	resolving bool
+}
+
+type assembler struct {
+	statements []statement
+	labels     map[string]uint16
+	consts     map[string]*constant
+	size       int
+	symbols    *symbols.Table
+}
+
+// layout is the first pass. It finds the statements, how big they are, and
+// so the address of every label.
+func (a *assembler) layout(lines []line) error {
+	addr := chip8.PROGRAM_OFFSET
+	for _, l := range lines {
+		p, err := parseLine(l)
+		if err != nil {
+			return err
+		}
+		for _, name := range p.labels {
+			if err := a.define(l, name); err != nil {
+				return err
+			}
+			a.labels[name.text] = uint16(addr)
+			a.symbols.SetLabel(uint16(addr), name.text)
+		}
+		if p.op.text == "" {
+			continue
+		}
+		if p.constant {
+			if err := a.define(l, p.op); err != nil {
+				return err
+			}
+			a.consts[p.op.text] = &constant{line: l, expr: p.args[0]}
+			continue
+		}
+
+		s := statement{line: l, addr: uint16(addr), op: p.op, args: p.args}
+		size, err := s.size()
+		if err != nil {
+			return err
+		}
+		addr += size
+		if addr > 0x10000 {
+			return l.errorf(p.op.col, "the program doesn't fit in memory")
+		}
+		a.statements = append(a.statements, s)
+	}
+	a.size = addr - chip8.PROGRAM_OFFSET
+	return nil
+}
+
+// define checks that name can be given to a label or constant.
+func (a *assembler) define(l line, name token) error {
+	if !isIdent(name.text) {
+		return l.errorf(name.col, "%q isn't a valid name", name.text)
+	}
+	if _, ok := register(name.text); ok || registerNames[strings.ToUpper(name.text)] {
+		return l.errorf(name.col, "%s is a reserved word", name.text)
+	}
+	if _, ok := a.labels[name.text]; ok {
+		return l.errorf(name.col, "%s is already defined", name.text)
+	}
+	if _, ok := a.consts[name.text]; ok {
+		return l.errorf(name.col, "%s is already defined", name.text)
+	}
+	return nil
+}
+
+// size returns the number of bytes the statement assembles into.
+func (s statement) size() (int, error) {
+	switch strings.ToUpper(s.op.text) {
+	case "DB":
+		return len(s.args), nil
+	case "DW":
+		return 2 * len(s.args), nil
+	case "LD":
+		if len(s.args) == 2 && hasLong(s.args[1]) {
+			return 4, nil
+		}
+	}
+	if _, ok := instructions[strings.ToUpper(s.op.text)]; !ok {
+		return 0, s.line.errorf(s.op.col, "unknown instruction %s", s.op.text)
+	}
+	return 2, nil
+}
+
+// encode is the second pass, which assembles the statements now that every
+// label has an address.
+func (a *assembler) encode() (*Program, error) {
+	prog := &Program{Bytes: make([]byte, 0, a.size), Symbols: a.symbols}
+	for _, s := range a.statements {
+		switch strings.ToUpper(s.op.text) {
+		case "DB":
+			for _, arg := range s.args {
+				v, err := a.eval(s.line, arg)
+				if err != nil {
+					return nil, err
+				}
+				if v < -128 || v > 0xFF {
+					return nil, s.line.errorf(arg.col, "%d doesn't fit in a byte", v)
+				}
+				prog.Bytes = append(prog.Bytes, byte(v))
+			}
+		case "DW":
+			for _, arg := range s.args {
+				v, err := a.eval(s.line, arg)
+				if err != nil {
+					return nil, err
+				}
+				if v < -0x8000 || v > 0xFFFF {
+					return nil, s.line.errorf(arg.col, "%d doesn't fit in a word", v)
+				}
+				prog.Bytes = append(prog.Bytes, byte(v>>8), byte(v))
+			}
+		default:
+			code, err := a.instruction(s)
+			if err != nil {
+				return nil, err
+			}
+			prog.Symbols.SetLine(s.addr, symbols.Source{File: s.line.file, Line: s.line.num})
+			prog.Bytes = append(prog.Bytes, code...)
+		}
+	}
+	return prog, nil
+}
+
+// eval works out the value of the expression in t.
+func (a *assembler) eval(l line, t token) (int, error) {
+	text := t.text
+	total, sign, wantTerm := 0, 1, true
+	for i := 0; i < len(text); {
+		switch c := text[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '+' || c == '-':
+			if c == '-' {
+				sign = -sign
+			}
+			wantTerm = true
+			i++
+		case !wantTerm:
+			return 0, l.errorf(t.col+i, "expected + or - but found %q", text[i:])
+		default:
+			j := i
+			for j < len(text) && !strings.ContainsRune("+- \t", rune(text[j])) {
+				j++
+			}
+			v, err := a.term(l, token{text: text[i:j], col: t.col + i})
+			if err != nil {
+				return 0, err
+			}
+			total += sign * v
+			sign, wantTerm = 1, false
+			i = j
+		}
+	}
+	if wantTerm {
+		return 0, l.errorf(t.col+len(text), "expected a value")
+	}
+	return total, nil
+}
+
+// term works out the value of a number, label or constant.
+func (a *assembler) term(l line, t token) (int, error) {
+	if v, ok := parseNumber(t.text); ok {
+		return v, nil
+	}
+	if addr, ok := a.labels[t.text]; ok {
+		return int(addr), nil
+	}
+	c, ok := a.consts[t.text]
+	if !ok {
+		if isIdent(t.text) {
+			return 0, l.errorf(t.col, "%s isn't defined", t.text)
+		}
+		return 0, l.errorf(t.col, "%q isn't a number or a name", t.text)
+	}
+	if !c.done {
+		if c.resolving {
+			return 0, l.errorf(t.col, "%s is defined in terms of itself", t.text)
+		}
+		c.resolving = true
+		v, err := a.eval(c.line, c.expr)
+		if err != nil {
+			return 0, err
+		}
+		c.value, c.done, c.resolving = v, true, false
+	}
+	return c.value, nil
+}
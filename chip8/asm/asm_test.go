@@ -0,0 +1,52 @@
+package asm
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/chip8/disasm"
+	"github.com/zabrahams/gochip8/internal/testroms"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, rom := range testroms.ROMs() {
+		var src bytes.Buffer
+		if err := disasm.Analyze(rom.Bytes, chip8.PROGRAM_OFFSET).Write(&src, disasm.FORMAT_ASM); err != nil {
+			t.Fatalf("%s: %v", rom.Name, err)
+		}
+		prog, err := Assemble("rom.s", src.Bytes())
+		if err != nil {
+			t.Errorf("%s % X: %v\n%s", rom.Name, rom.Bytes, err, src.String())
+			continue
+		}
+		if !bytes.Equal(prog.Bytes, rom.Bytes) {
+			t.Errorf("%s assembled into\n% X\nnot\n% X\nfrom\n%s", rom.Name, prog.Bytes, rom.Bytes, src.String())
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"undefined label", "start:\n  JP nowhere\n", "test.s:2:6: nowhere isn't defined"},
+		{"out of range immediate", "start:\n  LD V0, 0x100\n", "test.s:2:10: 256 doesn't fit in 8 bits"},
+		{"duplicate label", "start:\n  CLS\nstart:\n  RET\n", "test.s:3:1: start is already defined"},
+		{"self referential constant", "X = X + 1\n  LD V0, X\n", "test.s:1:5: X is defined in terms of itself"},
+		{"constant cycle", "X = Y\nY = X\n  LD V0, X\n", "test.s:2:5: X is defined in terms of itself"},
+	} {
+		_, err := Assemble("test.s", []byte(test.src))
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("%s: got error %v, want an *Error", test.name, err)
+			continue
+		}
+		if got := e.Error(); got != test.want {
+			t.Errorf("%s: got error %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package asm
+
+import "strings"
+
+// encoder assembles a statement into the bytes of an instruction.
+type encoder func(a *assembler, s statement) ([]byte, error)
+
+// instructions holds the encoder for each mnemonic.
+var instructions = map[string]encoder{
+	"CLS":   fixed(0x00E0),
+	"RET":   fixed(0x00EE),
+	"SCD":   nibble(0x00C0),
+	"SCU":   nibble(0x00D0),
+	"SCR":   fixed(0x00FB),
+	"SCL":   fixed(0x00FC),
+	"EXIT":  fixed(0x00FD),
+	"LOW":   fixed(0x00FE),
+	"HIGH":  fixed(0x00FF),
+	"JP":    jp,
+	"CALL":  call,
+	"SE":    skip(0x3000, 0x5000),
+	"SNE":   skip(0x4000, 0x9000),
+	"SAVE":  regReg(0x5002),
+	"LOAD":  regReg(0x5003),
+	"LD":    ld,
+	"ADD":   add,
+	"OR":    regReg(0x8001),
+	"AND":   regReg(0x8002),
+	"XOR":   regReg(0x8003),
+	"SUB":   regReg(0x8005),
+	"SHR":   shift(0x8006),
+	"SUBN":  regReg(0x8007),
+	"SHL":   shift(0x800E),
+	"RND":   regByte(0xC000),
+	"DRW":   drw,
+	"SKP":   reg(0xE09E),
+	"SKNP":  reg(0xE0A1),
+	"PLANE": plane,
+	"AUDIO": fixed(0xF002),
+}
+
+// instruction assembles the instruction in s.
+func (a *assembler) instruction(s statement) ([]byte, error) {
+	return instructions[strings.ToUpper(s.op.text)](a, s)
+}
+
+// want checks that s has one of the numbers of operands in n.
+func (s statement) want(n ...int) error {
+	for _, count := range n {
+		if len(s.args) == count {
+			return nil
+		}
+	}
+	name := strings.ToUpper(s.op.text)
+	switch {
+	case len(n) == 2:
+		return s.line.errorf(s.op.col, "%s takes %d or %d operands", name, n[0], n[1])
+	case n[0] == 0:
+		return s.line.errorf(s.op.col, "%s doesn't take any operands", name)
+	case n[0] == 1:
+		return s.line.errorf(s.op.col, "%s takes 1 operand", name)
+	}
+	return s.line.errorf(s.op.col, "%s takes %d operands", name, n[0])
+}
+
+// badOperands returns the error for operands that no form of s's instruction
+// takes.
+func (s statement) badOperands() error {
+	var text []string
+	for _, arg := range s.args {
+		text = append(text, arg.text)
+	}
+	return s.line.errorf(s.args[0].col, "%s can't take %s", strings.ToUpper(s.op.text), strings.Join(text, ", "))
+}
+
+// kind classifies an operand as "V" for V0 to VF, one of the registerNames, or
+// "" for a value.
+func kind(t token) string {
+	if _, ok := register(t.text); ok {
+		return "V"
+	}
+	if hasLong(t) {
+		return "LONG"
+	}
+	if name := strings.ToUpper(t.text); registerNames[name] {
+		return name
+	}
+	return ""
+}
+
+// kinds returns the kind of each of s's operands, separated by commas.
+func (s statement) kinds() string {
+	var k []string
+	for _, arg := range s.args {
+		k = append(k, kind(arg))
+	}
+	return strings.Join(k, ",")
+}
+
+// reg returns the number of the register Vx in t.
+func (s statement) reg(t token) (uint16, error) {
+	x, ok := register(t.text)
+	if !ok {
+		return 0, s.line.errorf(t.col, "expected a register V0 to VF but found %q", t.text)
+	}
+	return uint16(x), nil
+}
+
+// value works out the expression in t, which has to fit in bits bits. A
+// negative value that fits is stored as two's complement.
+func (a *assembler) value(s statement, t token, bits uint) (uint16, error) {
+	if kind(t) != "" {
+		return 0, s.line.errorf(t.col, "expected a value but found %q", t.text)
+	}
+	v, err := a.eval(s.line, t)
+	if err != nil {
+		return 0, err
+	}
+	max := 1<<bits - 1
+	if v < -(max+1)/2 || v > max {
+		return 0, s.line.errorf(t.col, "%d doesn't fit in %d bits", v, bits)
+	}
+	return uint16(v & max), nil
+}
+
+func word(op uint16) []byte {
+	return []byte{byte(op >> 8), byte(op)}
+}
+
+// fixed encodes an instruction that has no operands.
+func fixed(op uint16) encoder {
+	return func(a *assembler, s statement) ([]byte, error) {
+		if err := s.want(0); err != nil {
+			return nil, err
+		}
+		return word(op), nil
+	}
+}
+
+// nibble encodes an instruction that takes a 4 bit value.
+func nibble(op uint16) encoder {
+	return func(a *assembler, s statement) ([]byte, error) {
+		if err := s.want(1); err != nil {
+			return nil, err
+		}
+		n, err := a.value(s, s.args[0], 4)
+		if err != nil {
+			return nil, err
+		}
+		return word(op | n), nil
+	}
+}
+
+// reg encodes an instruction that takes a register, Vx.
+func reg(op uint16) encoder {
+	return func(a *assembler, s statement) ([]byte, error) {
+		if err := s.want(1); err != nil {
+			return nil, err
+		}
+		x, err := s.reg(s.args[0])
+		if err != nil {
+			return nil, err
+		}
+		return word(op | x<<8), nil
+	}
+}
+
+// regReg encodes an instruction that takes two registers, Vx and Vy.
+func regReg(op uint16) encoder {
+	return func(a *assembler, s statement) ([]byte, error) {
+		if err := s.want(2); err != nil {
+			return nil, err
+		}
+		x, err := s.reg(s.args[0])
+		if err != nil {
+			return nil, err
+		}
+		y, err := s.reg(s.args[1])
+		if err != nil {
+			return nil, err
+		}
+		return word(op | x<<8 | y<<4), nil
+	}
+}
+
+// regByte encodes an instruction that takes a register and a byte.
+func regByte(op uint16) encoder {
+	return func(a *assembler, s statement) ([]byte, error) {
+		if err := s.want(2); err != nil {
+			return nil, err
+		}
+		x, err := s.reg(s.args[0])
+		if err != nil {
+			return nil, err
+		}
+		kk, err := a.value(s, s.args[1], 8)
+		if err != nil {
+			return nil, err
+		}
+		return word(op | x<<8 | kk), nil
+	}
+}
+
+// skip encodes a conditional skip, which compares a register with a byte or
+// with another register.
+func skip(withByte, withReg uint16) encoder {
+	return func(a *assembler, s statement) ([]byte, error) {
+		if err := s.want(2); err != nil {
+			return nil, err
+		}
+		if kind(s.args[1]) == "V" {
+			return regReg(withReg)(a, s)
+		}
+		return regByte(withByte)(a, s)
+	}
+}
+
+// shift encodes SHR and SHL, which the disassembler writes as SHR Vx, {, Vy}
+// since Vy is only used by some interpreters. Vy can be left out, in which
+// case it's Vx.
+func shift(op uint16) encoder {
+	return func(a *assembler, s statement) ([]byte, error) {
+		var args []token
+		for _, arg := range s.args {
+			arg.text = strings.TrimSpace(strings.Trim(arg.text, "{}"))
+			if arg.text != "" {
+				args = append(args, arg)
+			}
+		}
+		s.args = args
+		if err := s.want(1, 2); err != nil {
+			return nil, err
+		}
+		if len(s.args) == 1 {
+			s.args = append(s.args, s.args[0])
+		}
+		return regReg(op)(a, s)
+	}
+}
+
+func jp(a *assembler, s statement) ([]byte, error) {
+	if err := s.want(1, 2); err != nil {
+		return nil, err
+	}
+	if len(s.args) == 2 {
+		if !strings.EqualFold(s.args[0].text, "V0") {
+			return nil, s.line.errorf(s.args[0].col, "JP can only add V0 to an address")
+		}
+		nnn, err := a.value(s, s.args[1], 12)
+		if err != nil {
+			return nil, err
+		}
+		return word(0xB000 | nnn), nil
+	}
+	nnn, err := a.value(s, s.args[0], 12)
+	if err != nil {
+		return nil, err
+	}
+	return word(0x1000 | nnn), nil
+}
+
+func call(a *assembler, s statement) ([]byte, error) {
+	if err := s.want(1); err != nil {
+		return nil, err
+	}
+	nnn, err := a.value(s, s.args[0], 12)
+	if err != nil {
+		return nil, err
+	}
+	return word(0x2000 | nnn), nil
+}
+
+// ldForms holds the opcode of each form of LD that takes registers only, by
+// the kinds of its operands. The x register goes in the second nibble either
+// way round.
+var ldForms = map[string]uint16{
+	"V,V":     0x8000,
+	"V,DT":    0xF007,
+	"V,K":     0xF00A,
+	"DT,V":    0xF015,
+	"ST,V":    0xF018,
+	"F,V":     0xF029,
+	"HF,V":    0xF030,
+	"B,V":     0xF033,
+	"PITCH,V": 0xF03A,
+	"[I],V":   0xF055,
+	"V,[I]":   0xF065,
+	"R,V":     0xF075,
+	"V,R":     0xF085,
+}
+
+func ld(a *assembler, s statement) ([]byte, error) {
+	if err := s.want(2); err != nil {
+		return nil, err
+	}
+	kinds := s.kinds()
+	switch kinds {
+	case "V,":
+		return regByte(0x6000)(a, s)
+	case "I,":
+		nnn, err := a.value(s, s.args[1], 12)
+		if err != nil {
+			return nil, err
+		}
+		return word(0xA000 | nnn), nil
+	case "I,LONG":
+		arg := s.args[1]
+		long := strings.TrimLeft(arg.text[len("LONG"):], " \t")
+		arg.col += len(arg.text) - len(long)
+		arg.text = long
+		nnnn, err := a.value(s, arg, 16)
+		if err != nil {
+			return nil, err
+		}
+		return append(word(0xF000), word(nnnn)...), nil
+	}
+	op, ok := ldForms[kinds]
+	if !ok {
+		return nil, s.badOperands()
+	}
+	var x, y uint16
+	for i, arg := range s.args {
+		if kind(arg) != "V" {
+			continue
+		}
+		r, _ := s.reg(arg)
+		if i == 0 || kinds != "V,V" {
+			x = r
+		} else {
+			y = r
+		}
+	}
+	return word(op | x<<8 | y<<4), nil
+}
+
+func add(a *assembler, s statement) ([]byte, error) {
+	if err := s.want(2); err != nil {
+		return nil, err
+	}
+	switch s.kinds() {
+	case "V,":
+		return regByte(0x7000)(a, s)
+	case "V,V":
+		return regReg(0x8004)(a, s)
+	case "I,V":
+		x, _ := s.reg(s.args[1])
+		return word(0xF01E | x<<8), nil
+	}
+	return nil, s.badOperands()
+}
+
+func drw(a *assembler, s statement) ([]byte, error) {
+	if err := s.want(3); err != nil {
+		return nil, err
+	}
+	xy, err := regReg(0xD000)(a, statement{line: s.line, op: s.op, args: s.args[:2]})
+	if err != nil {
+		return nil, err
+	}
+	n, err := a.value(s, s.args[2], 4)
+	if err != nil {
+		return nil, err
+	}
+	return []byte{xy[0], xy[1] | byte(n)}, nil
+}
+
+func plane(a *assembler, s statement) ([]byte, error) {
+	if err := s.want(1); err != nil {
+		return nil, err
+	}
+	n, err := a.value(s, s.args[0], 4)
+	if err != nil {
+		return nil, err
+	}
+	return word(0xF001 | n<<8), nil
+}
@@ -0,0 +1,170 @@
+package asm
+
+import (
+	"strconv"
+	"strings"
+)
+
+// token is a piece of a line, with the column it starts at.
+type token struct {
+	text string
+	col  int
+}
+
+// parsedLine is a line split into its parts.
+//
+// args: the operands, split at commas, or for a constant its expression
+//
+// constant: whether the line gives a constant, named op, a value
+//
+// labels: the labels defined on the line
+//
+// op: the instruction or directive, if there is one
+type parsedLine struct {
+	args     []token
+	constant bool
+	labels   []token
+	op       token
+}
+
+// parseLine splits l into its labels, instruction and operands.
+func parseLine(l line) (parsedLine, error) {
+	var p parsedLine
+	code := stripComment(l.text)
+	pos := 0
+	skipSpace := func() {
+		for pos < len(code) && (code[pos] == ' ' || code[pos] == '\t') {
+			pos++
+		}
+	}
+	for {
+		skipSpace()
+		if pos == len(code) {
+			return p, nil
+		}
+		start := pos
+		for pos < len(code) && !strings.ContainsRune(" \t:=,", rune(code[pos])) {
+			pos++
+		}
+		if start == pos {
+			return p, l.errorf(start+1, "unexpected %q", code[pos])
+		}
+		word := token{text: code[start:pos], col: start + 1}
+		if pos < len(code) && code[pos] == ':' {
+			p.labels = append(p.labels, word)
+			pos++
+			continue
+		}
+		p.op = word
+		break
+	}
+
+	skipSpace()
+	if pos < len(code) && code[pos] == '=' {
+		p.constant = true
+		pos++
+		expr := trimToken(code[pos:], pos+1)
+		if expr.text == "" {
+			return p, l.errorf(pos+1, "expected a value after =")
+		}
+		p.args = []token{expr}
+		return p, nil
+	}
+	if strings.TrimSpace(code[pos:]) == "" {
+		return p, nil
+	}
+	for _, arg := range strings.Split(code[pos:], ",") {
+		t := trimToken(arg, pos+1)
+		if t.text == "" {
+			return p, l.errorf(t.col, "expected an operand")
+		}
+		p.args = append(p.args, t)
+		pos += len(arg) + 1
+	}
+	return p, nil
+}
+
+// trimToken returns text, which starts at col, without the space around it.
+func trimToken(text string, col int) token {
+	trimmed := strings.TrimLeft(text, " \t")
+	col += len(text) - len(trimmed)
+	return token{text: strings.TrimRight(trimmed, " \t"), col: col}
+}
+
+// stripComment returns text without the comment at the end of it, if it has
+// one.
+func stripComment(text string) string {
+	quoted := false
+	for i, c := range text {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ';' && !quoted:
+			return text[:i]
+		}
+	}
+	return text
+}
+
+// unquote returns the text between the double quotes around text.
+func unquote(text string) (string, bool) {
+	if len(text) < 2 || text[0] != '"' || text[len(text)-1] != '"' {
+		return "", false
+	}
+	return text[1 : len(text)-1], true
+}
+
+// isIdent reports whether text can be the name of a label or constant.
+func isIdent(text string) bool {
+	for i, c := range text {
+		switch {
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return text != ""
+}
+
+// parseNumber parses a decimal number, or a hex number starting with 0x or a
+// binary number starting with 0b.
+func parseNumber(text string) (int, bool) {
+	base, digits := 10, text
+	switch lower := strings.ToLower(text); {
+	case strings.HasPrefix(lower, "0x"):
+		base, digits = 16, text[2:]
+	case strings.HasPrefix(lower, "0b"):
+		base, digits = 2, text[2:]
+	}
+	v, err := strconv.ParseUint(digits, base, 32)
+	if err != nil {
+		return 0, false
+	}
+	return int(v), true
+}
+
+// register returns the number of the register Vx named by text.
+func register(text string) (byte, bool) {
+	if len(text) != 2 || text[0] != 'V' && text[0] != 'v' {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(text[1:], 16, 8)
+	if err != nil {
+		return 0, false
+	}
+	return byte(v), true
+}
+
+// registerNames are the operands, besides V0 to VF, that name registers or
+// something else that isn't a value.
+var registerNames = map[string]bool{
+	"I": true, "[I]": true, "DT": true, "ST": true, "K": true, "F": true,
+	"HF": true, "B": true, "R": true, "PITCH": true, "LONG": true,
+}
+
+// hasLong reports whether t is a LONG address, as in LD I, LONG addr.
+func hasLong(t token) bool {
+	fields := strings.Fields(t.text)
+	return len(fields) > 0 && strings.EqualFold(fields[0], "LONG")
+}
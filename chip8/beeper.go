@@ -1,6 +1,28 @@
 package chip8
 
-type Beeper interface {
-	Beep()
-	Close()
+import "math"
+
+// Beeper is the original name for AudioSink.
+type Beeper = AudioSink
+
+// PatternBeeper is a Beeper that can play the XO-CHIP audio pattern buffer
+// instead of a fixed beep. The pattern is 128 1-bit samples, most significant
+// bit first, played in a loop at rate samples per second.
+type PatternBeeper interface {
+	Beeper
+	SetPattern(pattern [16]byte, rate float64)
+}
+
+// patternRate converts an XO-CHIP pitch into the rate that the audio pattern
+// is played at.
+func patternRate(pitch byte) float64 {
+	return 4000 * math.Pow(2, (float64(pitch)-64)/48)
+}
+
+// updateAudio hands the current audio pattern and pitch to the beeper, if it
+// knows what to do with them.
+func (c8 *Chip8) updateAudio() {
+	if pb, ok := c8.beeper.(PatternBeeper); ok {
+		pb.SetPattern(c8.audioPattern, patternRate(c8.pitch))
+	}
 }
@@ -8,71 +8,170 @@ import (
 	"os"
 	"os/exec"
 	"time"
+
+	"github.com/zabrahams/gochip8/symbols"
 )
 
 const (
 	PROGRAM_OFFSET = 512
 	CLOCK_TICK     = 2
 	TIMER_TICK     = 17
+	STACK_SIZE     = 16
+
+	// INSTRS_PER_FRAME is the default number of instructions executed in each
+	// frame, i.e. for each tick of the timers.
+	INSTRS_PER_FRAME = TIMER_TICK / CLOCK_TICK
+
+	MEMORY_SIZE          = 0x1000
+	EXTENDED_MEMORY_SIZE = 0x10000
+
+	// DEFAULT_PITCH is the XO-CHIP pitch that plays the audio pattern at 4000
+	// samples a second.
+	DEFAULT_PITCH = 64
+
+	// BIG_FONT_OFFSET is where the SUPER-CHIP 8x10 font is stored, just after
+	// the 16 5 byte sprites of the regular font.
+	BIG_FONT_OFFSET = 80
 )
 
 // Chip8 is the struct that represents a full Chip8 VM
 // The attribues are:
 //
+// accesses: the memory read and written by the last instruction executed
+//
+// audioPattern: the XO-CHIP audio pattern buffer - 128 1-bit samples
+//
+// beeper: the beeper that the beepTimer beeps with
+//
 // beepTimer: A timer that counts down at 60hz and beeps when it reaches 0
 //
 // callStack: A stack of addresses to return to from subroutines
 //
+// Break: if set, RunFrame calls it before every instruction and stops the
+// frame if it returns an error. The error should wrap ErrBreak.
+//
+// Clock: paces the frames executed by Run. If it's nil Run uses a TickerClock
+// that starts a frame every TIMER_TICK milliseconds.
+//
+// Coverage: if set, ExecInstr records every instruction it executes into it.
+// It has to be set before the program is loaded for the program to be
+// recorded.
+//
+// cycles: the number of instructions executed so far
+//
+// CyclesPerFrame: the number of instructions Run executes in each frame
+//
 // deplayTimer: A timer that counts down at 60 hz
 //
+// frames: the number of frames RunFrame has finished so far
+//
 // FrameBuffer: A representation of the current state of the screen
 //
+// History: if set, RunFrame records a snapshot into it every frame so that
+// the machine can be rewound
+//
+// Input: if set, RunFrame records the keyboard into it at the start of every
+// frame, or replays the keyboard from it
+//
+// Journal: if set, ExecInstr records how to undo every instruction into it, so
+// that execution can be stepped backwards
+//
 // Keyboard: A representation of the current state of the keyboard
 //
-// memory: a 4kb byte slice reprsenting the memory available to the system
+// memory: a 4kb byte slice reprsenting the memory available to the system.
+// With the ExtendedMemory quirk it's the 64kb XO-CHIP address space instead.
+//
+// pitch: the XO-CHIP pitch register, which sets the audio pattern playback rate
 //
 // programPtr: the register that points to the next instruction to run
 //
+// Provenance: if set, it records where every byte of memory came from. It has
+// to be set before the program is loaded for the program to be recorded.
+//
+// quirks: the behaviour to use for ambiguous instructions
+//
+// random: the source of the random bytes used by Cxkk
+//
 // regI: the 16 bit I register - used for storing the location of sprites
 //
+// rplFlags: the SUPER-CHIP RPL user flags, saved and loaded by Fx75 and Fx85
+//
 // registers: An array of the 16 8-bit registesr used by the CPU.
 // They are named V0-VF.
 //
 // stop: a channel for doing hacky debugging - should be refactored away.
+//
+// Symbols: if set, the labels and source lines of the program, which String
+// and the debuggers show in their disassembly
+//
+// Trace: if set, ExecInstr calls it after every instruction it executes, with
+// what the instruction did
 type Chip8 struct {
-	beepTimer   *Timer
-	callStack   []uint16
-	delayTimer  *Timer
-	FrameBuffer *FrameBuffer
-	Keyboard    *Keyboard
-	memory      []byte
-	programPtr  uint16
-	regI        uint16
-	registers   map[byte]byte
-	Stop        chan struct{}
+	accesses       []MemoryAccess
+	audioPattern   [16]byte
+	beeper         Beeper
+	beepTimer      *Timer
+	Break          func(c8 *Chip8) error
+	callStack      []uint16
+	Clock          Clock
+	Coverage       *Coverage
+	cycles         uint64
+	CyclesPerFrame int
+	delayTimer     *Timer
+	FrameBuffer    *FrameBuffer
+	frames         uint64
+	History        *Rewind
+	Input          *InputRecording
+	Journal        *Journal
+	Keyboard       *Keyboard
+	memory         []byte
+	pitch          byte
+	programPtr     uint16
+	Provenance     *Provenance
+	quirks         Quirks
+	random         RandomSource
+	regI           uint16
+	rplFlags       [16]byte
+	registers      map[byte]byte
+	Stop           chan struct{}
+	Symbols        *symbols.Table
+	Trace          func(e *TraceEntry)
 }
 
-// NewChip8 accepts a keyboard and a beeper and returns a pointer to a full
-// Chip8.
-func NewChip8(b Beeper) *Chip8 {
-	m := make([]byte, 4096, 4096)
+// NewChip8 accepts a beeper, a set of quirks and a source of random numbers and
+// returns a pointer to a full Chip8. If r is nil a SeededSource with a random
+// seed is used.
+func NewChip8(b Beeper, q Quirks, r RandomSource) *Chip8 {
+	size := MEMORY_SIZE
+	if q.ExtendedMemory {
+		size = EXTENDED_MEMORY_SIZE
+	}
+	m := make([]byte, size, size)
 	loadBuiltInSprites(m)
-	r := map[byte]byte{}
+	regs := map[byte]byte{}
 	for i := 0; i < 16; i++ {
-		r[byte(i)] = byte(0)
+		regs[byte(i)] = byte(0)
+	}
+	if r == nil {
+		r = NewSeededSource(NewRandomSeed())
 	}
 
 	return &Chip8{
-		beepTimer:   NewTimer(func() { b.Beep() }),
-		callStack:   []uint16{},
-		delayTimer:  NewTimer(func() {}),
-		FrameBuffer: NewFrameBuffer(),
-		Keyboard:    NewKeyboard(),
-		memory:      m,
-		programPtr:  PROGRAM_OFFSET,
-		regI:        0,
-		registers:   r,
-		Stop:        make(chan struct{}),
+		beeper:         b,
+		beepTimer:      NewTimer(func() { go b.Beep() }),
+		callStack:      []uint16{},
+		CyclesPerFrame: INSTRS_PER_FRAME,
+		delayTimer:     NewTimer(func() {}),
+		FrameBuffer:    NewFrameBuffer(),
+		Keyboard:       NewKeyboard(),
+		memory:         m,
+		pitch:          DEFAULT_PITCH,
+		programPtr:     PROGRAM_OFFSET,
+		quirks:         q,
+		random:         r,
+		regI:           0,
+		registers:      regs,
+		Stop:           make(chan struct{}),
 	}
 }
 
@@ -81,25 +180,30 @@ func (c8 *Chip8) String() {
 	var msg bytes.Buffer
 	// Uncomment the following to get a hex dump of the entire memory stack
 	// msg.WriteString(hex.Dump(c8.memory))
-	var iStart, iEnd uint16
+	var iStart, iEnd int
 	if c8.programPtr < 10 {
 		iStart = 0
 	} else {
-		iStart = c8.programPtr - 10
+		iStart = int(c8.programPtr) - 10
 	}
-	if c8.programPtr > 4084 {
-		iEnd = 4096
+	if int(c8.programPtr)+12 > len(c8.memory) {
+		iEnd = len(c8.memory)
 	} else {
-		iEnd = c8.programPtr + 12
+		iEnd = int(c8.programPtr) + 12
 	}
 
-	iBuilder := Disassemble(c8.memory[iStart:iEnd], iStart)
+	iBuilder := DisassembleSymbols(c8.memory[iStart:iEnd], uint16(iStart), c8.Symbols, nil)
 	msg.WriteString(iBuilder.String() + "\n")
 
 	msg.WriteString(fmt.Sprintf("Program Counter: %X (%d)\n", c8.programPtr, c8.programPtr))
+	if src, ok := c8.SourceLine(); ok {
+		msg.WriteString(fmt.Sprintf("Source: %s\n", src))
+	}
 
-	instr := c8.memory[c8.programPtr : c8.programPtr+2]
-	msg.WriteString(fmt.Sprintf("Instr: %X %v\n", instr, instr))
+	if c8.inMemory(c8.programPtr, 2) {
+		instr := c8.memory[c8.programPtr : c8.programPtr+2]
+		msg.WriteString(fmt.Sprintf("Instr: %X %v\n", instr, instr))
+	}
 	msg.WriteString("Registers:\n")
 	for i := 0; i < 16; i += 2 {
 		reg1 := fmt.Sprintf("V%X: %02X (%d)", i, c8.registers[byte(i)], c8.registers[byte(i)])
@@ -112,6 +216,15 @@ func (c8 *Chip8) String() {
 	fmt.Println(msg.String())
 }
 
+// SourceLine returns the source line that the instruction at the program
+// counter was built from, if Symbols knows it.
+func (c8 *Chip8) SourceLine() (symbols.Source, bool) {
+	if c8.Symbols == nil {
+		return symbols.Source{}, false
+	}
+	return c8.Symbols.Line(c8.programPtr)
+}
+
 // Load is used to load a Chip8 program into memory from a system file.
 func (c8 *Chip8) Load(filename string) {
 	fmt.Printf("Loading Program From File: %s\n", filename)
@@ -122,21 +235,76 @@ func (c8 *Chip8) Load(filename string) {
 	defer file.Close()
 	binData, err := ioutil.ReadAll(file)
 	if err != nil {
-		log.Fatalf("error reading data from file: %v")
+		log.Fatalf("error reading data from file: %v", err)
 	}
-
-	for i := 0; i < len(binData); i++ {
-		c8.memory[PROGRAM_OFFSET+i] = binData[i]
+	if err := c8.LoadBytes(binData); err != nil {
+		log.Fatal(err)
 	}
 	fmt.Printf("Finshed loading program. Loaded %d bytes\n", len(binData))
 }
 
-// Run creates a ticker using the CLOCK_TICK variable and executes an instruction on every tick.
-func (c8 *Chip8) Run() {
-	ticker := time.NewTicker(CLOCK_TICK * time.Millisecond)
+// LoadBytes loads a Chip8 program into memory at PROGRAM_OFFSET.
+func (c8 *Chip8) LoadBytes(program []byte) error {
+	if PROGRAM_OFFSET+len(program) > len(c8.memory) {
+		return fmt.Errorf("program is %d bytes, which doesn't fit in memory", len(program))
+	}
+	copy(c8.memory[PROGRAM_OFFSET:], program)
+	if c8.Provenance != nil {
+		c8.Provenance.set(PROGRAM_OFFSET, len(program), Origin{Kind: OriginROM})
+	}
+	if c8.Coverage != nil {
+		c8.Coverage.load(program)
+	}
+	return nil
+}
+
+// RunFrame executes cycles instructions and then ticks the delay and sound
+// timers once. If Input is set the keyboard is recorded or replayed first, and
+// if History is set a snapshot is recorded into it at the end. If an
+// instruction faults the frame stops there and the error is returned. If Break
+// is set and returns an error the frame stops before the next instruction, and
+// that error is returned wrapped in an ExecError.
+func (c8 *Chip8) RunFrame(cycles int) error {
+	if c8.Input != nil {
+		c8.Input.frame(c8.Keyboard)
+	}
+	for i := 0; i < cycles; i++ {
+		if c8.Break != nil {
+			if err := c8.Break(c8); err != nil {
+				return c8.fault(err, c8.currentOpcode())
+			}
+		}
+		if err := c8.ExecInstr(); err != nil {
+			return err
+		}
+	}
+	c8.delayTimer.tick()
+	c8.beepTimer.tick()
+	c8.frames++
+	if c8.History != nil {
+		return c8.History.Record(c8)
+	}
+	return nil
+}
+
+// Run executes CyclesPerFrame instructions every frame, as paced by Clock.
+// If an instruction faults the Chip8 stops running and the error is sent on the returned
+// channel. The channel is closed once the Chip8 has stopped, whether it faulted or not.
+func (c8 *Chip8) Run() <-chan error {
+	errs := make(chan error, 1)
+	clock := c8.Clock
+	if clock == nil {
+		clock = NewTickerClock(TIMER_TICK * time.Millisecond)
+	}
 	go func() {
-		for _ = range ticker.C {
-			c8.ExecInstr()
+		defer close(errs)
+		defer clock.Stop()
+		for {
+			clock.Wait()
+			if err := c8.RunFrame(c8.CyclesPerFrame); err != nil {
+				errs <- err
+				return
+			}
 			select {
 			case <-c8.Stop:
 				return
@@ -144,6 +312,7 @@ func (c8 *Chip8) Run() {
 			}
 		}
 	}()
+	return errs
 }
 
 func loadBuiltInSprites(m []byte) {
@@ -172,6 +341,31 @@ func loadBuiltInSprites(m []byte) {
 			m[(i*5)+j] = line
 		}
 	}
+
+	bigSprites := [][]byte{
+		[]byte{0xFF, 0xFF, 0xC3, 0xC3, 0xC3, 0xC3, 0xC3, 0xC3, 0xFF, 0xFF}, // 0
+		[]byte{0x18, 0x78, 0x78, 0x18, 0x18, 0x18, 0x18, 0x18, 0xFF, 0xFF}, // 1
+		[]byte{0xFF, 0xFF, 0x03, 0x03, 0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF}, // 2
+		[]byte{0xFF, 0xFF, 0x03, 0x03, 0xFF, 0xFF, 0x03, 0x03, 0xFF, 0xFF}, // 3
+		[]byte{0xC3, 0xC3, 0xC3, 0xC3, 0xFF, 0xFF, 0x03, 0x03, 0x03, 0x03}, // 4
+		[]byte{0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, 0x03, 0x03, 0xFF, 0xFF}, // 5
+		[]byte{0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, 0xC3, 0xC3, 0xFF, 0xFF}, // 6
+		[]byte{0xFF, 0xFF, 0x03, 0x03, 0x06, 0x0C, 0x18, 0x18, 0x18, 0x18}, // 7
+		[]byte{0xFF, 0xFF, 0xC3, 0xC3, 0xFF, 0xFF, 0xC3, 0xC3, 0xFF, 0xFF}, // 8
+		[]byte{0xFF, 0xFF, 0xC3, 0xC3, 0xFF, 0xFF, 0x03, 0x03, 0xFF, 0xFF}, // 9
+		[]byte{0x7E, 0xFF, 0xC3, 0xC3, 0xC3, 0xFF, 0xFF, 0xC3, 0xC3, 0xC3}, // A
+		[]byte{0xFC, 0xFC, 0xC3, 0xC3, 0xFC, 0xFC, 0xC3, 0xC3, 0xFC, 0xFC}, // B
+		[]byte{0x3C, 0xFF, 0xC3, 0xC0, 0xC0, 0xC0, 0xC0, 0xC3, 0xFF, 0x3C}, // C
+		[]byte{0xFC, 0xFE, 0xC3, 0xC3, 0xC3, 0xC3, 0xC3, 0xC3, 0xFE, 0xFC}, // D
+		[]byte{0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF}, // E
+		[]byte{0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, 0xC0, 0xC0, 0xC0, 0xC0}, // F
+	}
+
+	for i, sprite := range bigSprites {
+		for j, line := range sprite {
+			m[BIG_FONT_OFFSET+(i*10)+j] = line
+		}
+	}
 }
 
 func clearScreen() {
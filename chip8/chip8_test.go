@@ -0,0 +1,208 @@
+package chip8
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// nullBeeper is a Beeper that makes no sound.
+type nullBeeper struct{}
+
+func (nullBeeper) Beep()  {}
+func (nullBeeper) Close() {}
+
+// testProgram sets the timers and then draws random digits at random places
+// forever.
+var testProgram = []byte{
+	0x60, 0x3C, // 0x200 LD V0, 60
+	0xF0, 0x15, // 0x202 LD DT, V0
+	0x61, 0x28, // 0x204 LD V1, 40
+	0xF1, 0x18, // 0x206 LD ST, V1
+	0xC3, 0x0F, // 0x208 RND V3, 0x0F
+	0xC4, 0x3F, // 0x20A RND V4, 0x3F
+	0xC5, 0x1F, // 0x20C RND V5, 0x1F
+	0xF3, 0x29, // 0x20E LD F, V3
+	0xD4, 0x55, // 0x210 DRW V4, V5, 5
+	0x12, 0x08, // 0x212 JP 0x208
+}
+
+// runFrames runs testProgram with seed on a FreeClock until frames frames
+// have finished.
+func runFrames(t *testing.T, seed uint64, frames uint64) *Chip8 {
+	t.Helper()
+	c8 := NewChip8(nullBeeper{}, QuirksModern, NewSeededSource(seed))
+	c8.Clock = FreeClock{}
+	if err := c8.LoadBytes(testProgram); err != nil {
+		t.Fatal(err)
+	}
+	c8.Break = func(c8 *Chip8) error {
+		if c8.Frames() == frames {
+			return ErrBreak
+		}
+		return nil
+	}
+	for err := range c8.Run() {
+		if !errors.Is(err, ErrBreak) {
+			t.Fatal(err)
+		}
+	}
+	if c8.Frames() != frames {
+		t.Fatalf("ran %d frames, want %d", c8.Frames(), frames)
+	}
+	return c8
+}
+
+func TestDeterministic(t *testing.T) {
+	a := runFrames(t, 42, 30)
+	b := runFrames(t, 42, 30)
+	if a.FrameBuffer.String() != b.FrameBuffer.String() {
+		t.Errorf("the screens differ:\n%s\n%s", a.FrameBuffer, b.FrameBuffer)
+	}
+	if ra, rb := a.Registers(), b.Registers(); !reflect.DeepEqual(ra, rb) {
+		t.Errorf("the registers differ: %+v and %+v", ra, rb)
+	}
+	if a.Cycles() != b.Cycles() {
+		t.Errorf("the cycles differ: %d and %d", a.Cycles(), b.Cycles())
+	}
+	if r := a.Registers(); r.DelayTimer != 60-30 || r.SoundTimer != 40-30 {
+		t.Errorf("got DT=%d ST=%d, want 30 and 10", r.DelayTimer, r.SoundTimer)
+	}
+
+	c := runFrames(t, 43, 30)
+	if a.FrameBuffer.String() == c.FrameBuffer.String() {
+		t.Error("different seeds drew the same screen")
+	}
+}
+
+func TestTimersTickOncePerFrame(t *testing.T) {
+	c8 := NewChip8(nullBeeper{}, QuirksModern, NewSeededSource(1))
+	if err := c8.LoadBytes(testProgram); err != nil {
+		t.Fatal(err)
+	}
+	// The first frame sets the timers and then ticks them once.
+	if err := c8.RunFrame(4); err != nil {
+		t.Fatal(err)
+	}
+	dt, st := byte(59), byte(39)
+	for frame := 1; frame <= 70; frame++ {
+		r := c8.Registers()
+		if r.DelayTimer != dt || r.SoundTimer != st {
+			t.Fatalf("frame %d: got DT=%d ST=%d, want %d and %d", frame, r.DelayTimer, r.SoundTimer, dt, st)
+		}
+		if err := c8.RunFrame(c8.CyclesPerFrame); err != nil {
+			t.Fatal(err)
+		}
+		if dt > 0 {
+			dt--
+		}
+		if st > 0 {
+			st--
+		}
+	}
+	if c8.Frames() != 71 {
+		t.Errorf("got %d frames, want 71", c8.Frames())
+	}
+}
+
+// brokenSource is a RandomSource that always fails.
+type brokenSource struct{}
+
+var errBroken = errors.New("broken")
+
+func (brokenSource) Byte() (byte, error) {
+	return 0, errBroken
+}
+
+func TestRandomSourceFault(t *testing.T) {
+	c8 := NewChip8(nullBeeper{}, QuirksModern, brokenSource{})
+	if err := c8.LoadBytes([]byte{0xC0, 0xFF}); err != nil {
+		t.Fatal(err)
+	}
+	err := c8.ExecInstr()
+	var fault *ExecError
+	if !errors.As(err, &fault) || !errors.Is(err, errBroken) {
+		t.Fatalf("got %#v, want an *ExecError wrapping the source's error", err)
+	}
+	if fault.PC != PROGRAM_OFFSET || fault.Opcode != 0xC0FF {
+		t.Errorf("the fault is at PC 0x%03X with opcode %04X, want 0x200 and C0FF", fault.PC, fault.Opcode)
+	}
+	if c8.Registers().PC != PROGRAM_OFFSET {
+		t.Errorf("the PC moved on to 0x%03X", c8.Registers().PC)
+	}
+}
+
+func TestXOChipInstructions(t *testing.T) {
+	for _, instr := range [][]byte{
+		{0xF0, 0x00, 0x03, 0x00}, // LD I, LONG 0x0300
+		{0x50, 0x12},             // SAVE V0, V1
+		{0x50, 0x13},             // LOAD V0, V1
+		{0xF2, 0x01},             // PLANE 2
+		{0xF0, 0x02},             // AUDIO
+		{0x00, 0xD1},             // SCU 1
+		{0xF0, 0x3A},             // LD PITCH, V0
+	} {
+		classic := NewChip8(nullBeeper{}, QuirksModern, nil)
+		if err := classic.LoadBytes(instr); err != nil {
+			t.Fatal(err)
+		}
+		if err := classic.ExecInstr(); !errors.Is(err, ErrUnknownOpcode) {
+			t.Errorf("%X: got %v without the XO-CHIP instructions, want ErrUnknownOpcode", instr, err)
+		}
+
+		xo := NewChip8(nullBeeper{}, QuirksXOCHIP, nil)
+		if err := xo.LoadBytes(instr); err != nil {
+			t.Fatal(err)
+		}
+		if err := xo.ExecInstr(); err != nil {
+			t.Errorf("%X: got %v with the XO-CHIP instructions", instr, err)
+		}
+		if pc := xo.Registers().PC; pc != PROGRAM_OFFSET+uint16(len(instr)) {
+			t.Errorf("%X: the PC is 0x%03X after it", instr, pc)
+		}
+	}
+}
+
+func TestSkipLong(t *testing.T) {
+	// SE V0, 0x00 skips the whole of a following LD I, LONG only when it's
+	// an instruction.
+	program := []byte{0x30, 0x00, 0xF0, 0x00, 0x03, 0x00}
+	for _, test := range []struct {
+		quirks Quirks
+		pc     uint16
+	}{
+		{QuirksModern, 0x204},
+		{QuirksXOCHIP, 0x206},
+	} {
+		c8 := NewChip8(nullBeeper{}, test.quirks, nil)
+		if err := c8.LoadBytes(program); err != nil {
+			t.Fatal(err)
+		}
+		if err := c8.ExecInstr(); err != nil {
+			t.Fatal(err)
+		}
+		if pc := c8.Registers().PC; pc != test.pc {
+			t.Errorf("%+v: skipped to 0x%03X, want 0x%03X", test.quirks, pc, test.pc)
+		}
+	}
+}
+
+func TestSeededSource(t *testing.T) {
+	a, b, c := NewSeededSource(7), NewSeededSource(7), NewSeededSource(8)
+	same := true
+	for i := 0; i < 64; i++ {
+		x, _ := a.Byte()
+		y, _ := b.Byte()
+		z, _ := c.Byte()
+		if x != y {
+			t.Fatalf("byte %d: got %d and %d from the same seed", i, x, y)
+		}
+		same = same && x == z
+	}
+	if same {
+		t.Error("different seeds gave the same bytes")
+	}
+	if a.Seed() != 7 {
+		t.Errorf("got seed %d, want 7", a.Seed())
+	}
+}
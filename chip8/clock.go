@@ -0,0 +1,37 @@
+package chip8
+
+import "time"
+
+// Clock paces the frames that Run executes. Nothing in the machine itself
+// depends on the time, so the same program given the same input always
+// produces the same frames, however fast or slow the clock is.
+type Clock interface {
+	// Wait blocks until it's time to start the next frame.
+	Wait()
+	// Stop releases anything the clock holds on to.
+	Stop()
+}
+
+// TickerClock is a Clock that starts a frame every period of real time.
+type TickerClock struct {
+	ticker *time.Ticker
+}
+
+func NewTickerClock(period time.Duration) *TickerClock {
+	return &TickerClock{ticker: time.NewTicker(period)}
+}
+
+func (c *TickerClock) Wait() {
+	<-c.ticker.C
+}
+
+func (c *TickerClock) Stop() {
+	c.ticker.Stop()
+}
+
+// FreeClock is a Clock that never waits, so frames run as fast as possible.
+type FreeClock struct{}
+
+func (FreeClock) Wait() {}
+
+func (FreeClock) Stop() {}
@@ -0,0 +1,103 @@
+package chip8
+
+import "fmt"
+
+// Branch counts the outcomes of a conditional skip instruction.
+//
+// Taken: how many times it skipped the next instruction
+//
+// NotTaken: how many times it fell through to the next instruction
+type Branch struct {
+	Taken    uint64
+	NotTaken uint64
+}
+
+// Coverage records which addresses the Chip8 has executed instructions from,
+// how many times, and which way each conditional skip went.
+type Coverage struct {
+	counts   []uint64
+	branches map[uint16]*Branch
+	program  []byte
+}
+
+// NewCoverage returns an empty Coverage for a memory of size bytes.
+func NewCoverage(size int) *Coverage {
+	return &Coverage{counts: make([]uint64, size), branches: map[uint16]*Branch{}}
+}
+
+// Program returns the program that was loaded while the Coverage was set.
+func (c *Coverage) Program() []byte {
+	return c.program
+}
+
+// Count returns how many times the instruction at addr has been executed.
+func (c *Coverage) Count(addr uint16) uint64 {
+	if int(addr) >= len(c.counts) {
+		return 0
+	}
+	return c.counts[addr]
+}
+
+// Branch returns the outcomes of the conditional skip at addr. It returns
+// false if no skip there has been executed.
+func (c *Coverage) Branch(addr uint16) (Branch, bool) {
+	b, ok := c.branches[addr]
+	if !ok {
+		return Branch{}, false
+	}
+	return *b, true
+}
+
+// Executed returns the address of every instruction that has been executed,
+// in order.
+func (c *Coverage) Executed() []uint16 {
+	var addrs []uint16
+	for addr, count := range c.counts {
+		if count > 0 {
+			addrs = append(addrs, uint16(addr))
+		}
+	}
+	return addrs
+}
+
+// Annotate marks a line of disassembly for the instruction at addr with how
+// many times it was executed, or "-" if it never was, and the outcomes of the
+// conditional skip there if it is one. It's for passing to
+// DisassembleSymbols.
+func (c *Coverage) Annotate(addr uint16, line string) string {
+	count := "-"
+	if n := c.Count(addr); n > 0 {
+		count = fmt.Sprint(n)
+	}
+	line = fmt.Sprintf("%10s  %s", count, line)
+	if b, ok := c.Branch(addr); ok {
+		line = fmt.Sprintf("%-46s ; taken %d, not taken %d", line, b.Taken, b.NotTaken)
+	}
+	return line
+}
+
+// load notes the program being loaded.
+func (c *Coverage) load(program []byte) {
+	c.program = append([]byte{}, program...)
+}
+
+// record notes that the instruction opcode at pc was executed, leaving the
+// program counter at next.
+func (c *Coverage) record(pc, opcode, next uint16) {
+	if int(pc) < len(c.counts) {
+		c.counts[pc]++
+	}
+	if !IsSkip(opcode) {
+		return
+	}
+	b, ok := c.branches[pc]
+	if !ok {
+		b = &Branch{}
+		c.branches[pc] = b
+	}
+	if next == pc+2 {
+		b.NotTaken++
+	} else {
+		b.Taken++
+	}
+}
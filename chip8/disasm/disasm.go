@@ -0,0 +1,414 @@
+// Package disasm disassembles Chip8 programs by following their control flow
+// from the entry point, rather than reading them two bytes at a time, so that
+// the code and the data mixed in with it can be told apart.
+package disasm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/symbols"
+)
+
+// The formats a listing can be written in: the mnemonics chip8.Mnemonic uses,
+// or Octo.
+const (
+	FORMAT_ASM  = "asm"
+	FORMAT_OCTO = "octo"
+)
+
+// ErrBadFormat is returned for a format that isn't FORMAT_ASM or FORMAT_OCTO.
+var ErrBadFormat = errors.New("unknown disassembly format")
+
+// DATA_PER_LINE is the number of bytes of data written on each db line, when
+// they aren't a sprite.
+const DATA_PER_LINE = 8
+
+// Listing is a program split into code and data.
+//
+// code: the length of the instruction at each address found to be code
+//
+// labels: the name of each address that's jumped to, called or pointed at
+// with LD I
+//
+// Offset: the address the program is loaded at
+//
+// Program: the program
+//
+// sprites: the addresses pointed at with LD I
+//
+// symbols: if set, the source lines that Write notes beside the instructions
+//
+// xrefs: the addresses of the instructions that refer to each address
+type Listing struct {
+	code    map[uint16]int
+	labels  map[uint16]string
+	Offset  uint16
+	Program []byte
+	sprites map[uint16]bool
+	symbols *symbols.Table
+	xrefs   map[uint16][]uint16
+}
+
+// Analyze follows the control flow of program, loaded at offset, from its
+// first instruction. Every instruction that can be reached through jumps,
+// calls, skips and returns is code, and everything else is data.
+func Analyze(program []byte, offset uint16) *Listing {
+	l := &Listing{
+		code:    map[uint16]int{},
+		labels:  map[uint16]string{},
+		Offset:  offset,
+		Program: program,
+		sprites: map[uint16]bool{},
+		xrefs:   map[uint16][]uint16{},
+	}
+	kinds := map[uint16]string{}
+	refer := func(from, to uint16, kind string) {
+		l.xrefs[to] = append(l.xrefs[to], from)
+		// A subroutine stays one even if it's also jumped to.
+		if kinds[to] != "sub" {
+			kinds[to] = kind
+		}
+	}
+
+	work := []uint16{offset}
+	for len(work) > 0 {
+		pc := work[len(work)-1]
+		work = work[:len(work)-1]
+		for {
+			if _, seen := l.code[pc]; seen {
+				break
+			}
+			instr := l.instrAt(pc)
+			if !assemblable(instr) || l.overlaps(pc, len(instr)) {
+				break
+			}
+			l.code[pc] = len(instr)
+			next := pc + uint16(len(instr))
+			opcode := uint16(instr[0])<<8 | uint16(instr[1])
+			nnn := opcode & 0xFFF
+
+			stop := false
+			switch {
+			case opcode == 0x00EE || opcode == 0x00FD:
+				stop = true
+			case opcode>>12 == 0x1:
+				kind := "label"
+				if nnn <= pc {
+					kind = "loop"
+				}
+				refer(pc, nnn, kind)
+				work = append(work, nnn)
+				stop = true
+			case opcode>>12 == 0x2:
+				refer(pc, nnn, "sub")
+				work = append(work, nnn)
+			case opcode>>12 == 0xB:
+				// Where a computed jump goes can't be known, but it's
+				// usually into a table of jumps that starts at nnn.
+				refer(pc, nnn, "table")
+				work = append(work, nnn)
+				stop = true
+			case opcode>>12 == 0xA:
+				refer(pc, nnn, "sprite")
+				l.sprites[nnn] = true
+			case opcode == 0xF000:
+				long := uint16(instr[2])<<8 | uint16(instr[3])
+				refer(pc, long, "sprite")
+				l.sprites[long] = true
+			case chip8.IsSkip(opcode):
+				work = append(work, l.skip(next))
+			}
+			if stop {
+				break
+			}
+			pc = next
+		}
+	}
+
+	// Only addresses that start an instruction or are data can be labelled.
+	for addr, kind := range kinds {
+		if !l.inProgram(addr) || l.insideCode(addr) {
+			continue
+		}
+		l.labels[addr] = fmt.Sprintf("%s_%03X", kind, addr)
+	}
+	if _, ok := l.code[offset]; ok {
+		l.labels[offset] = "main"
+	}
+	return l
+}
+
+// UseSymbols gives the addresses that table has labels for those names rather
+// than made up ones, and has Write note the source line of each instruction.
+// Labels in the middle of an instruction are left out, since they can't be
+// written.
+func (l *Listing) UseSymbols(table *symbols.Table) {
+	l.symbols = table
+	for _, addr := range table.Labels() {
+		if l.inProgram(addr) && !l.insideCode(addr) {
+			l.labels[addr], _ = table.Label(addr)
+		}
+	}
+}
+
+// IsCode reports whether an instruction starts at addr.
+func (l *Listing) IsCode(addr uint16) bool {
+	_, ok := l.code[addr]
+	return ok
+}
+
+// Label returns the name of addr, if it has one.
+func (l *Listing) Label(addr uint16) (string, bool) {
+	name, ok := l.labels[addr]
+	return name, ok
+}
+
+// Labels returns the labelled addresses, in order.
+func (l *Listing) Labels() []uint16 {
+	addrs := make([]uint16, 0, len(l.labels))
+	for addr := range l.labels {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// XRefs returns the addresses of the instructions that refer to addr, in
+// order.
+func (l *Listing) XRefs(addr uint16) []uint16 {
+	refs := append([]uint16{}, l.xrefs[addr]...)
+	sort.Slice(refs, func(i, j int) bool { return refs[i] < refs[j] })
+	return refs
+}
+
+// Mnemonic disassembles the instruction at addr like chip8.Mnemonic does, with
+// the address it refers to replaced by its label if it has one.
+func (l *Listing) Mnemonic(addr uint16) string {
+	instr := l.instrAt(addr)
+	if instr == nil {
+		return "BAD INSTR"
+	}
+	m := chip8.Mnemonic(instr)
+	opcode := uint16(instr[0])<<8 | uint16(instr[1])
+	switch {
+	case opcode == 0xF000:
+		long := uint16(instr[2])<<8 | uint16(instr[3])
+		if name, ok := l.labels[long]; ok {
+			m = strings.TrimSuffix(m, fmt.Sprintf("0x%04X", long)) + name
+		}
+	case opcode>>12 == 0x1 || opcode>>12 == 0x2 || opcode>>12 == 0xA || opcode>>12 == 0xB:
+		if name, ok := l.labels[opcode&0xFFF]; ok {
+			m = strings.TrimSuffix(m, fmt.Sprintf("0x%03X", opcode&0xFFF)) + name
+		}
+	}
+	return m
+}
+
+// Write writes the listing to w in format, which is FORMAT_ASM or FORMAT_OCTO.
+// Each line has the address and bytes it came from in a comment, and each
+// label the addresses that refer to it. Data is written one byte a line drawn
+// as pixels where it's pointed at with LD I, since it's probably a sprite.
+func (l *Listing) Write(w io.Writer, format string) error {
+	var syn syntax
+	switch format {
+	case FORMAT_ASM:
+		syn = asmSyntax
+	case FORMAT_OCTO:
+		syn = octoSyntax
+	default:
+		return fmt.Errorf("%w %q", ErrBadFormat, format)
+	}
+
+	b := bufio.NewWriter(w)
+	line := func(text string, addr uint16, data []byte, note string) {
+		s := fmt.Sprintf("    %-24s %s 0x%03X  %-8X  %s", text, syn.comment, addr, data, note)
+		fmt.Fprintln(b, strings.TrimRight(s, " "))
+	}
+	end := int(l.Offset) + len(l.Program)
+	sprite := false
+	for addr := int(l.Offset); addr < end; {
+		a := uint16(addr)
+		if name, ok := l.label(a, syn.entry); ok {
+			label := syn.label(name)
+			if refs := l.XRefs(a); len(refs) > 0 {
+				var text []string
+				for _, ref := range refs {
+					text = append(text, fmt.Sprintf("0x%03X", ref))
+				}
+				fmt.Fprintf(b, "%-28s %s xref %s\n", label, syn.comment, strings.Join(text, ", "))
+			} else {
+				fmt.Fprintln(b, label)
+			}
+		}
+		if size, ok := l.code[a]; ok {
+			var src string
+			if l.symbols != nil {
+				if s, ok := l.symbols.Line(a); ok {
+					src = s.String()
+				}
+			}
+			line(syn.instr(l, a), a, l.instrAt(a), src)
+			addr += size
+			sprite = false
+			continue
+		}
+
+		if l.sprites[a] {
+			sprite = true
+		}
+		if sprite {
+			data := l.Program[addr-int(l.Offset)]
+			line(syn.data([]byte{data}, true), a, []byte{data}, pixels(data))
+			addr++
+			continue
+		}
+		// Plain data runs to the next label or instruction.
+		n := 1
+		for n < DATA_PER_LINE && addr+n < end && !l.startsSomething(uint16(addr+n)) {
+			n++
+		}
+		data := l.Program[addr-int(l.Offset) : addr-int(l.Offset)+n]
+		line(syn.data(data, false), a, data, "")
+		addr += n
+	}
+	return b.Flush()
+}
+
+// syntax is how Write writes a listing in one of the formats.
+//
+// comment: what starts a comment
+//
+// data: writes a line of data, which is a byte of a sprite if sprite is set
+//
+// entry: if set, the entry point is always labelled with it
+//
+// instr: writes the instruction at an address
+//
+// label: writes a label
+type syntax struct {
+	comment string
+	data    func(data []byte, sprite bool) string
+	entry   string
+	instr   func(l *Listing, addr uint16) string
+	label   func(name string) string
+}
+
+var asmSyntax = syntax{
+	comment: ";",
+	data: func(data []byte, sprite bool) string {
+		if sprite {
+			return fmt.Sprintf("db 0b%08b", data[0])
+		}
+		var text []string
+		for _, d := range data {
+			text = append(text, fmt.Sprintf("0x%02X", d))
+		}
+		return "db " + strings.Join(text, ", ")
+	},
+	instr: (*Listing).Mnemonic,
+	label: func(name string) string { return name + ":" },
+}
+
+// label returns the name of addr, if it has one, in a syntax whose entry point
+// is always called entry. Any other address with that name is given a new one,
+// so that the name isn't defined twice.
+func (l *Listing) label(addr uint16, entry string) (string, bool) {
+	if entry != "" && addr == l.Offset {
+		return entry, true
+	}
+	name, ok := l.Label(addr)
+	if ok && name == entry {
+		name = fmt.Sprintf("%s_%03X", name, addr)
+	}
+	return name, ok
+}
+
+// assemblable reports whether instr is an instruction that assembles back
+// into the same bytes. 9xyn is run as 9xy0 whatever n is, so it's only one
+// when n is 0.
+func assemblable(instr []byte) bool {
+	if instr == nil || chip8.Mnemonic(instr) == "BAD INSTR" {
+		return false
+	}
+	return instr[0]>>4 != 0x9 || instr[1]&0xF == 0
+}
+
+// startsSomething reports whether a label, an instruction or a sprite starts
+// at addr.
+func (l *Listing) startsSomething(addr uint16) bool {
+	_, label := l.labels[addr]
+	_, code := l.code[addr]
+	return label || code || l.sprites[addr]
+}
+
+// instrAt returns the bytes of the instruction at addr, or nil if it isn't
+// all inside the program.
+func (l *Listing) instrAt(addr uint16) []byte {
+	if !l.inProgram(addr) || !l.inProgram(addr+1) {
+		return nil
+	}
+	i := int(addr - l.Offset)
+	if l.Program[i] == 0xF0 && l.Program[i+1] == 0x00 {
+		if !l.inProgram(addr + 3) {
+			return nil
+		}
+		return l.Program[i : i+4]
+	}
+	return l.Program[i : i+2]
+}
+
+// skip returns the address of the instruction after the one at addr, like
+// the conditional skips do.
+func (l *Listing) skip(addr uint16) uint16 {
+	if instr := l.instrAt(addr); instr != nil {
+		return addr + uint16(len(instr))
+	}
+	return addr + 2
+}
+
+func (l *Listing) inProgram(addr uint16) bool {
+	return addr >= l.Offset && int(addr-l.Offset) < len(l.Program)
+}
+
+// insideCode reports whether addr is in the middle of an instruction.
+func (l *Listing) insideCode(addr uint16) bool {
+	for i := uint16(1); i < 4 && i <= addr; i++ {
+		if size, ok := l.code[addr-i]; ok && int(i) < size {
+			return true
+		}
+	}
+	return false
+}
+
+// overlaps reports whether an instruction of size bytes at addr would overlap
+// one that's already been found.
+func (l *Listing) overlaps(addr uint16, size int) bool {
+	if l.insideCode(addr) {
+		return true
+	}
+	for i := 1; i < size; i++ {
+		if _, ok := l.code[addr+uint16(i)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pixels draws a byte of a sprite, with # for the pixels that are set.
+func pixels(data byte) string {
+	var b strings.Builder
+	for bit := 7; bit >= 0; bit-- {
+		if data&(1<<bit) != 0 {
+			b.WriteByte('#')
+		} else {
+			b.WriteByte('.')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,87 @@
+package disasm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/chip8/octo"
+	"github.com/zabrahams/gochip8/internal/testroms"
+	"github.com/zabrahams/gochip8/symbols"
+)
+
+func TestOctoRoundTrip(t *testing.T) {
+	for _, rom := range testroms.ROMs() {
+		var src bytes.Buffer
+		if err := Analyze(rom.Bytes, chip8.PROGRAM_OFFSET).Write(&src, FORMAT_OCTO); err != nil {
+			t.Fatalf("%s: %v", rom.Name, err)
+		}
+		prog, err := octo.Compile("rom.8o", src.Bytes())
+		if err != nil {
+			t.Errorf("%s % X: %v\n%s", rom.Name, rom.Bytes, err, src.String())
+			continue
+		}
+		if !bytes.Equal(prog.Bytes, rom.Bytes) {
+			t.Errorf("%s compiled into\n% X\nnot\n% X\nfrom\n%s", rom.Name, prog.Bytes, rom.Bytes, src.String())
+		}
+	}
+}
+
+func TestOctoRoundTripWithSymbols(t *testing.T) {
+	table := symbols.New()
+	table.SetLabel(0x200, "start")
+	table.SetLabel(0x205, "inside")
+	table.SetLabel(0x20A, "ball")
+	table.SetLabel(0x20F, "main")
+	table.SetLine(0x200, symbols.Source{File: "game.8o", Line: 3})
+	l := Analyze(testroms.Sprite, chip8.PROGRAM_OFFSET)
+	l.UseSymbols(table)
+
+	var src bytes.Buffer
+	if err := l.Write(&src, FORMAT_OCTO); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := octo.Compile("rom.8o", src.Bytes())
+	if err != nil {
+		t.Fatalf("%v\n%s", err, src.String())
+	}
+	if !bytes.Equal(prog.Bytes, testroms.Sprite) {
+		t.Errorf("compiled into\n% X\nnot\n% X\nfrom\n%s", prog.Bytes, testroms.Sprite, src.String())
+	}
+	// The entry point is main whatever it's called in the symbols, and the
+	// main the symbols have elsewhere is renamed.
+	for _, want := range []string{"jump main ", "i := ball ", "main_20F ", ": main_20F", "game.8o:3"} {
+		if !strings.Contains(src.String(), want) {
+			t.Errorf("%q isn't in\n%s", want, src.String())
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	rom := testroms.Sprite
+	l := Analyze(rom, chip8.PROGRAM_OFFSET)
+	for addr, want := range map[uint16]bool{0x200: true, 0x208: true, 0x20A: false, 0x20F: true, 0x210: false} {
+		if got := l.IsCode(addr); got != want {
+			t.Errorf("IsCode(0x%03X) = %v, want %v", addr, got, want)
+		}
+	}
+	for addr, want := range map[uint16]string{0x200: "main", 0x20A: "sprite_20A", 0x20F: "sub_20F"} {
+		if got, _ := l.Label(addr); got != want {
+			t.Errorf("Label(0x%03X) = %q, want %q", addr, got, want)
+		}
+	}
+	if got := l.Mnemonic(0x206); got != "CALL sub_20F" {
+		t.Errorf("Mnemonic(0x206) = %q, want CALL sub_20F", got)
+	}
+	if refs := l.XRefs(0x200); len(refs) != 1 || refs[0] != 0x208 {
+		t.Errorf("XRefs(0x200) = %X, want [208]", refs)
+	}
+}
+
+func TestBadFormat(t *testing.T) {
+	err := Analyze(testroms.Sprite, chip8.PROGRAM_OFFSET).Write(&strings.Builder{}, "nasm")
+	if err == nil {
+		t.Fatal("writing in an unknown format didn't fail")
+	}
+}
@@ -0,0 +1,154 @@
+package disasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// octoEntry is the label Octo starts running programs at. It jumps there first
+// if it isn't at the start.
+const octoEntry = "main"
+
+var octoSyntax = syntax{
+	comment: "#",
+	data: func(data []byte, sprite bool) string {
+		if sprite {
+			return fmt.Sprintf("0b%08b", data[0])
+		}
+		var text []string
+		for _, d := range data {
+			text = append(text, fmt.Sprintf("0x%02X", d))
+		}
+		return strings.Join(text, " ")
+	},
+	entry: octoEntry,
+	instr: (*Listing).Octo,
+	label: func(name string) string { return ": " + name },
+}
+
+// Octo disassembles the instruction at addr into Octo, with the address it
+// refers to replaced by its label if it has one. The conditional skips are
+// written as if ... then, with the condition reversed since the next
+// instruction only runs when the skip doesn't happen. An instruction that Octo
+// has no way to write, like a call to an address without a label, is written
+// as its bytes.
+func (l *Listing) Octo(addr uint16) string {
+	instr := l.instrAt(addr)
+	if instr == nil {
+		return ""
+	}
+	high, low := instr[0], instr[1]
+	opcode := uint16(high)<<8 | uint16(low)
+	x, y, n := high&0xF, low>>4, low&0xF
+	nnn := opcode & 0xFFF
+	target := func(addr uint16) string {
+		if name, ok := l.label(addr, octoEntry); ok {
+			return name
+		}
+		return fmt.Sprintf("0x%03X", addr)
+	}
+
+	switch {
+	case opcode == 0x00E0:
+		return "clear"
+	case opcode == 0x00EE:
+		return "return"
+	case high == 0x00 && low>>4 == 0xC:
+		return fmt.Sprintf("scroll-down %d", n)
+	case high == 0x00 && low>>4 == 0xD:
+		return fmt.Sprintf("scroll-up %d", n)
+	case opcode == 0x00FB:
+		return "scroll-right"
+	case opcode == 0x00FC:
+		return "scroll-left"
+	case opcode == 0x00FD:
+		return "exit"
+	case opcode == 0x00FE:
+		return "lores"
+	case opcode == 0x00FF:
+		return "hires"
+	case high>>4 == 0x1:
+		return "jump " + target(nnn)
+	case high>>4 == 0x2:
+		if name, ok := l.label(nnn, octoEntry); ok {
+			return name
+		}
+	case high>>4 == 0x3:
+		return fmt.Sprintf("if v%x != 0x%02X then", x, low)
+	case high>>4 == 0x4:
+		return fmt.Sprintf("if v%x == 0x%02X then", x, low)
+	case high>>4 == 0x5 && n == 0x0:
+		return fmt.Sprintf("if v%x != v%x then", x, y)
+	case high>>4 == 0x5 && n == 0x2:
+		return fmt.Sprintf("save v%x - v%x", x, y)
+	case high>>4 == 0x5 && n == 0x3:
+		return fmt.Sprintf("load v%x - v%x", x, y)
+	case high>>4 == 0x6:
+		return fmt.Sprintf("v%x := 0x%02X", x, low)
+	case high>>4 == 0x7:
+		return fmt.Sprintf("v%x += 0x%02X", x, low)
+	case high>>4 == 0x8:
+		if op, ok := octoALU[n]; ok {
+			return fmt.Sprintf("v%x %s v%x", x, op, y)
+		}
+	case high>>4 == 0x9 && n == 0x0:
+		return fmt.Sprintf("if v%x == v%x then", x, y)
+	case high>>4 == 0xA:
+		return "i := " + target(nnn)
+	case high>>4 == 0xB:
+		return "jump0 " + target(nnn)
+	case high>>4 == 0xC:
+		return fmt.Sprintf("v%x := random 0x%02X", x, low)
+	case high>>4 == 0xD:
+		return fmt.Sprintf("sprite v%x v%x %d", x, y, n)
+	case high>>4 == 0xE && low == 0x9E:
+		return fmt.Sprintf("if v%x -key then", x)
+	case high>>4 == 0xE && low == 0xA1:
+		return fmt.Sprintf("if v%x key then", x)
+	case opcode == 0xF000:
+		long := uint16(instr[2])<<8 | uint16(instr[3])
+		if name, ok := l.label(long, octoEntry); ok {
+			return "i := long " + name
+		}
+		return fmt.Sprintf("i := long 0x%04X", long)
+	case high>>4 == 0xF && low == 0x01 && x <= 3:
+		return fmt.Sprintf("plane %d", x)
+	case opcode == 0xF002:
+		return "audio"
+	case high>>4 == 0xF:
+		if format, ok := octoMisc[low]; ok {
+			return fmt.Sprintf(format, x)
+		}
+	}
+	return fmt.Sprintf("0x%02X 0x%02X", high, low)
+}
+
+// octoALU holds the Octo operators for the 8xyn instructions, by n.
+var octoALU = map[byte]string{
+	0x0: ":=",
+	0x1: "|=",
+	0x2: "&=",
+	0x3: "^=",
+	0x4: "+=",
+	0x5: "-=",
+	0x6: ">>=",
+	0x7: "=-",
+	0xE: "<<=",
+}
+
+// octoMisc holds the Octo statements for the Fxkk instructions, by kk.
+var octoMisc = map[byte]string{
+	0x07: "v%x := delay",
+	0x0A: "v%x := key",
+	0x15: "delay := v%x",
+	0x18: "buzzer := v%x",
+	0x1E: "i += v%x",
+	0x29: "i := hex v%x",
+	0x30: "i := bighex v%x",
+	0x33: "bcd v%x",
+	0x3A: "pitch := v%x",
+	0x55: "save v%x",
+	0x65: "load v%x",
+	0x75: "saveflags v%x",
+	0x85: "loadflags v%x",
+}
@@ -3,6 +3,8 @@ package chip8
 import (
 	"fmt"
 	"strings"
+
+	"github.com/zabrahams/gochip8/symbols"
 )
 
 func translateOpCode(instr []byte) string {
@@ -20,6 +22,27 @@ func translateOpCode(instr []byte) string {
 	// OOEE
 	case high == 0x00 && low == 0xEE:
 		out = "RET"
+	// 00Cn
+	case high == 0x00 && lNib(low) == 0xC:
+		out = fmt.Sprintf("SCD 0x%X", fourth)
+	// 00Dn
+	case high == 0x00 && lNib(low) == 0xD:
+		out = fmt.Sprintf("SCU 0x%X", fourth)
+	// 00FB
+	case high == 0x00 && low == 0xFB:
+		out = "SCR"
+	// 00FC
+	case high == 0x00 && low == 0xFC:
+		out = "SCL"
+	// 00FD
+	case high == 0x00 && low == 0xFD:
+		out = "EXIT"
+	// 00FE
+	case high == 0x00 && low == 0xFE:
+		out = "LOW"
+	// 00FF
+	case high == 0x00 && low == 0xFF:
+		out = "HIGH"
 	// 1nnn
 	case first == 0x1:
 		addr := getAddr([]byte{high, low})
@@ -35,8 +58,14 @@ func translateOpCode(instr []byte) string {
 	case first == 0x4:
 		out = fmtVxByte("SNE", instr)
 	// 5xy0
-	case first == 0x5:
+	case first == 0x5 && fourth == 0x0:
 		out = fmtVxVy("SE", instr)
+	// 5xy2
+	case first == 0x5 && fourth == 0x2:
+		out = fmtVxVy("SAVE", instr)
+	// 5xy3
+	case first == 0x5 && fourth == 0x3:
+		out = fmtVxVy("LOAD", instr)
 	// 6xkk
 	case first == 0x6:
 		out = fmtVxByte("LD", instr)
@@ -98,6 +127,19 @@ func translateOpCode(instr []byte) string {
 	case first == 0xE && low == 0xA1:
 		x := rNib(high)
 		out = fmt.Sprintf("SKNP V%X", x)
+	// F000 nnnn
+	case high == 0xF0 && low == 0x00:
+		if len(instr) < 4 {
+			out = "LD I, LONG"
+		} else {
+			out = fmt.Sprintf("LD I, LONG 0x%04X", uint16(instr[2])<<8|uint16(instr[3]))
+		}
+	// Fn01
+	case first == 0xF && low == 0x01:
+		out = fmt.Sprintf("PLANE 0x%X", rNib(high))
+	// F002
+	case high == 0xF0 && low == 0x02:
+		out = "AUDIO"
 	// Fx07
 	case first == 0xF && low == 0x07:
 		x := rNib(high)
@@ -126,6 +168,14 @@ func translateOpCode(instr []byte) string {
 	case first == 0xF && low == 0x33:
 		x := rNib(high)
 		out = fmt.Sprintf("LD B, V%X", x)
+	// Fx30
+	case first == 0xF && low == 0x30:
+		x := rNib(high)
+		out = fmt.Sprintf("LD HF, V%X", x)
+	// Fx3A
+	case first == 0xF && low == 0x3A:
+		x := rNib(high)
+		out = fmt.Sprintf("LD PITCH, V%X", x)
 	//Fx55
 	case first == 0xF && low == 0x55:
 		x := rNib(high)
@@ -134,6 +184,14 @@ func translateOpCode(instr []byte) string {
 	case first == 0xF && low == 0x65:
 		x := rNib(high)
 		out = fmt.Sprintf("LD V%X, [I]", x)
+	// Fx75
+	case first == 0xF && low == 0x75:
+		x := rNib(high)
+		out = fmt.Sprintf("LD R, V%X", x)
+	// Fx85
+	case first == 0xF && low == 0x85:
+		x := rNib(high)
+		out = fmt.Sprintf("LD V%X, R", x)
 	default:
 		out = "BAD INSTR"
 	}
@@ -150,15 +208,88 @@ func fmtVxByte(op string, instr []byte) string {
 	return fmt.Sprintf("%s V%X, 0x%02X", op, x, instr[1])
 }
 
+// Mnemonic disassembles the single instruction in instr, e.g. "LD V3, 0x01".
+func Mnemonic(instr []byte) string {
+	if len(instr) < 2 {
+		return "BAD INSTR"
+	}
+	return translateOpCode(instr)
+}
+
 func Disassemble(opCodes []byte, offset uint16) strings.Builder {
+	return DisassembleSymbols(opCodes, offset, nil, nil)
+}
+
+// SymbolicMnemonic is Mnemonic with the address instr refers to replaced by its
+// label in table, e.g. "CALL draw_player". table can be nil.
+func SymbolicMnemonic(instr []byte, table *symbols.Table) string {
+	m := Mnemonic(instr)
+	if table == nil || m == "BAD INSTR" {
+		return m
+	}
+	switch first := lNib(instr[0]); {
+	case instr[0] == 0xF0 && instr[1] == 0x00 && len(instr) >= 4:
+		long := uint16(instr[2])<<8 | uint16(instr[3])
+		if name, ok := table.Label(long); ok {
+			m = strings.TrimSuffix(m, fmt.Sprintf("0x%04X", long)) + name
+		}
+	case first == 0x1 || first == 0x2 || first == 0xA || first == 0xB:
+		addr := getAddr(instr)
+		if name, ok := table.Label(addr); ok {
+			m = strings.TrimSuffix(m, fmt.Sprintf("0x%03X", addr)) + name
+		}
+	}
+	return m
+}
+
+// DisassembleSymbols is Disassemble with the labels and source lines in table,
+// if it's set, and each line passed through annotate, if it's set, along with
+// the address of its instruction, e.g. Coverage.Annotate to mark the
+// instructions that were executed. Addresses that instructions refer to are
+// replaced by their labels, and each line ends with the label of its address,
+// e.g. <draw_player>, and the source line its instruction was built from.
+func DisassembleSymbols(opCodes []byte, offset uint16, table *symbols.Table, annotate func(addr uint16, line string) string) strings.Builder {
 	var out strings.Builder
+	write := func(addr uint16, instr []byte) {
+		line := fmt.Sprintf("0x%03X   %X   %s", addr, instr, SymbolicMnemonic(instr, table))
+		if note := symbolNote(addr, table); note != "" {
+			line = fmt.Sprintf("%-40s %s", line, note)
+		}
+		if annotate != nil {
+			line = annotate(addr, line)
+		}
+		out.WriteString(line + "\n")
+	}
 
 	// IF there's an odd number of bytes passed in we ignore the
 	// last byte.  To do so we compare i to one less then the
 	// lenght of the opCodes slice.
 	for i := 0; i < len(opCodes)-1; i += 2 {
 		high, low := opCodes[i], opCodes[i+1]
-		out.WriteString(fmt.Sprintf("0x%03X   %02X%02X", offset+uint16(i), high, low) + "   " + translateOpCode([]byte{high, low}) + "\n")
+		// F000 nnnn is the only four byte instruction.
+		if high == 0xF0 && low == 0x00 && i+3 < len(opCodes) {
+			write(offset+uint16(i), opCodes[i:i+4])
+			i += 2
+			continue
+		}
+		write(offset+uint16(i), opCodes[i:i+2])
 	}
 	return out
 }
+
+// symbolNote returns what table knows about addr: its label, e.g.
+// <draw_player>, and the source line the instruction there was built from,
+// separated by a space. It's empty if table is nil or has neither.
+func symbolNote(addr uint16, table *symbols.Table) string {
+	if table == nil {
+		return ""
+	}
+	var note []string
+	if name, ok := table.Label(addr); ok {
+		note = append(note, "<"+name+">")
+	}
+	if src, ok := table.Line(addr); ok {
+		note = append(note, src.String())
+	}
+	return strings.Join(note, " ")
+}
@@ -0,0 +1,65 @@
+package chip8
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The kinds of faults that can stop the Chip8 while executing an instruction.
+// Use errors.Is against an error returned from ExecInstr to find out which
+// one occurred. ErrExit and ErrBreak aren't really faults: they're returned when
+// the program runs the SUPER-CHIP EXIT instruction, and when RunFrame is
+// stopped by Break.
+var (
+	ErrUnknownOpcode  = errors.New("unknown opcode")
+	ErrStackUnderflow = errors.New("call stack underflow")
+	ErrStackOverflow  = errors.New("call stack overflow")
+	ErrMemoryFault    = errors.New("memory access out of range")
+	ErrExit           = errors.New("program exited")
+	ErrBreak          = errors.New("stopped at break")
+)
+
+// ExecError describes a fault raised while executing an instruction. It
+// carries a snapshot of the machine at the time of the fault so that the
+// caller can report it without touching the (possibly still running) Chip8.
+type ExecError struct {
+	Err       error
+	PC        uint16
+	Opcode    uint16
+	I         uint16
+	Registers [16]byte
+	CallStack []uint16
+}
+
+func (e *ExecError) Error() string {
+	return fmt.Sprintf("%v at PC 0x%03X (opcode %04X, I 0x%03X, V %X, stack %v)",
+		e.Err, e.PC, e.Opcode, e.I, e.Registers[:], e.CallStack)
+}
+
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
+// currentOpcode returns the opcode at the program counter, or 0 if the program
+// counter is out of memory.
+func (c8 *Chip8) currentOpcode() uint16 {
+	if !c8.inMemory(c8.programPtr, 2) {
+		return 0
+	}
+	return uint16(c8.memory[c8.programPtr])<<8 | uint16(c8.memory[c8.programPtr+1])
+}
+
+// fault builds an ExecError for err using the current state of the Chip8.
+func (c8 *Chip8) fault(err error, opcode uint16) *ExecError {
+	e := &ExecError{
+		Err:       err,
+		PC:        c8.programPtr,
+		Opcode:    opcode,
+		I:         c8.regI,
+		CallStack: append([]uint16{}, c8.callStack...),
+	}
+	for i := range e.Registers {
+		e.Registers[i] = c8.registers[byte(i)]
+	}
+	return e
+}
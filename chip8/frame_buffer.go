@@ -1,27 +1,215 @@
 package chip8
 
-import "fmt"
+import (
+	"strconv"
+	"strings"
+)
 
+const (
+	LORES_WIDTH  = 64
+	LORES_HEIGHT = 32
+	HIRES_WIDTH  = 128
+	HIRES_HEIGHT = 64
+
+	// PLANES is the number of XO-CHIP bitplanes. Together they let each pixel
+	// be one of 1<<PLANES colours.
+	PLANES = 2
+
+	// rowWords is the number of uint64s used to store a single row of the
+	// screen. It's always big enough for a hi-res row so that switching
+	// resolution never has to reallocate the buffer.
+	rowWords = HIRES_WIDTH / 64
+)
+
+// FrameBuffer represents the screen. It's made of PLANES bitplanes, each of
+// which stores a row as rowWords uint64s, with the leftmost pixel in the
+// highest bit of the first word. Only the Width x Height pixels in the top
+// left corner are on screen.
+//
+// The selected planes are the ones that drawing, clearing and scrolling
+// affect. Classic CHIP-8 and SUPER-CHIP programs only ever use the first.
 type FrameBuffer struct {
-	Buffer []uint64
+	Planes   [PLANES][]uint64
+	Width    int
+	Height   int
+	selected byte
 }
 
 func NewFrameBuffer() *FrameBuffer {
-	b := make([]uint64, 32, 32)
-	return &FrameBuffer{Buffer: b}
+	fb := &FrameBuffer{Width: LORES_WIDTH, Height: LORES_HEIGHT, selected: 0x1}
+	for p := range fb.Planes {
+		fb.Planes[p] = make([]uint64, HIRES_HEIGHT*rowWords, HIRES_HEIGHT*rowWords)
+	}
+	return fb
+}
+
+// Hires reports whether the frame buffer is in the SUPER-CHIP 128x64 mode.
+func (fb *FrameBuffer) Hires() bool {
+	return fb.Width == HIRES_WIDTH
+}
+
+// Pixel returns the colour of the pixel at x, y. Bit n of the colour is set if
+// the pixel is lit in plane n, so 0 is always the background.
+func (fb *FrameBuffer) Pixel(x, y int) byte {
+	var colour byte
+	for p, plane := range fb.Planes {
+		if getBit(plane, x, y) {
+			colour |= 1 << uint(p)
+		}
+	}
+	return colour
 }
 
+// selectPlanes sets which planes later drawing instructions affect. mask has
+// bit n set to select plane n.
+func (fb *FrameBuffer) selectPlanes(mask byte) {
+	fb.selected = mask & (1<<PLANES - 1)
+}
+
+// forSelected calls f with every selected plane.
+func (fb *FrameBuffer) forSelected(f func(plane []uint64)) {
+	for p, plane := range fb.Planes {
+		if fb.selected&(1<<uint(p)) > 0 {
+			f(plane)
+		}
+	}
+}
+
+func getBit(plane []uint64, x, y int) bool {
+	return plane[y*rowWords+x/64]&(1<<uint(63-x%64)) > 0
+}
+
+func setBit(plane []uint64, x, y int, on bool) {
+	i := y*rowWords + x/64
+	bit := uint64(1) << uint(63-x%64)
+	if on {
+		plane[i] |= bit
+	} else {
+		plane[i] &^= bit
+	}
+}
+
+// flipBit toggles the pixel at x, y and reports whether doing so turned it off.
+func flipBit(plane []uint64, x, y int) bool {
+	i := y*rowWords + x/64
+	bit := uint64(1) << uint(63-x%64)
+	plane[i] ^= bit
+	return plane[i]&bit == 0
+}
+
+// clear blanks the selected planes.
 func (fb *FrameBuffer) clear() {
-	for i := range fb.Buffer {
-		fb.Buffer[i] = 0
+	fb.forSelected(func(plane []uint64) {
+		for i := range plane {
+			plane[i] = 0
+		}
+	})
+}
+
+// setHires switches between the 64x32 and 128x64 modes, clearing every plane.
+func (fb *FrameBuffer) setHires(hires bool) {
+	if hires {
+		fb.Width, fb.Height = HIRES_WIDTH, HIRES_HEIGHT
+	} else {
+		fb.Width, fb.Height = LORES_WIDTH, LORES_HEIGHT
+	}
+	for _, plane := range fb.Planes {
+		for i := range plane {
+			plane[i] = 0
+		}
+	}
+}
+
+// drawSprite xors a sprite that is width pixels wide onto the selected planes
+// with its top left corner at x, y, and reports whether any pixel was turned
+// off. The sprite is stored as width/8 bytes per row, and holds one image per
+// selected plane, one after the other. Pixels that fall off the edge of the
+// screen are either clipped or wrapped around to the other side.
+func (fb *FrameBuffer) drawSprite(x, y int, sprite []byte, width int, clip bool) bool {
+	rowBytes := width / 8
+	x, y = x%fb.Width, y%fb.Height
+	collision := false
+	if fb.selected == 0 {
+		return false
 	}
+	rows := len(sprite) / rowBytes / fb.selectedCount()
+	fb.forSelected(func(plane []uint64) {
+		for row := 0; row < rows; row++ {
+			py := y + row
+			if py >= fb.Height {
+				if clip {
+					break
+				}
+				py %= fb.Height
+			}
+			for col := 0; col < width; col++ {
+				if sprite[row*rowBytes+col/8]&(0x80>>uint(col%8)) == 0 {
+					continue
+				}
+				px := x + col
+				if px >= fb.Width {
+					if clip {
+						break
+					}
+					px %= fb.Width
+				}
+				if flipBit(plane, px, py) {
+					collision = true
+				}
+			}
+		}
+		sprite = sprite[rows*rowBytes:]
+	})
+	return collision
+}
+
+// selectedCount returns the number of selected planes.
+func (fb *FrameBuffer) selectedCount() int {
+	n := 0
+	fb.forSelected(func([]uint64) { n++ })
+	return n
+}
+
+// scrollDown moves the selected planes down n rows, or up if n is negative,
+// leaving blank rows behind.
+func (fb *FrameBuffer) scrollDown(n int) {
+	fb.forSelected(func(plane []uint64) {
+		rows := make([]uint64, fb.Height*rowWords)
+		for y := 0; y < fb.Height; y++ {
+			src := y - n
+			if src >= 0 && src < fb.Height {
+				copy(rows[y*rowWords:(y+1)*rowWords], plane[src*rowWords:(src+1)*rowWords])
+			}
+		}
+		copy(plane, rows)
+	})
+}
+
+// scrollHorizontal moves the selected planes right n pixels, or left if n is
+// negative, leaving blank columns behind.
+func (fb *FrameBuffer) scrollHorizontal(n int) {
+	row := make([]bool, fb.Width)
+	fb.forSelected(func(plane []uint64) {
+		for y := 0; y < fb.Height; y++ {
+			for x := range row {
+				src := x - n
+				row[x] = src >= 0 && src < fb.Width && getBit(plane, src, y)
+			}
+			for x, on := range row {
+				setBit(plane, x, y, on)
+			}
+		}
+	})
 }
 
 func (fb *FrameBuffer) String() string {
-	display := ""
-	for _, line := range fb.Buffer {
-		display = fmt.Sprintf("%s%064b (%X, %d)\n", display, line, line, line)
+	var display strings.Builder
+	for y := 0; y < fb.Height; y++ {
+		for x := 0; x < fb.Width; x++ {
+			display.WriteString(strconv.Itoa(int(fb.Pixel(x, y))))
+		}
+		display.WriteString("\n")
 	}
 
-	return display
+	return display.String()
 }
@@ -0,0 +1,50 @@
+package chip8
+
+// Display, InputSource and AudioSink are what a frontend provides to connect
+// the Chip8 to the outside world. Nothing in this package depends on any
+// particular implementation of them.
+
+// Display shows the contents of the frame buffer.
+type Display interface {
+	Update(fb *FrameBuffer)
+	Close()
+}
+
+// AudioSink plays the Chip8's sound. An AudioSink that also implements
+// PatternBeeper can play XO-CHIP audio patterns.
+type AudioSink interface {
+	Beep()
+	Close()
+}
+
+// Hotkey is a request from the user to the emulator itself, rather than to
+// the program running on it.
+type Hotkey int
+
+const (
+	HotkeySaveState Hotkey = iota
+	HotkeyLoadState
+	HotkeyPause
+)
+
+// InputState is everything an InputSource has to report.
+//
+// Keys: the state of the keypad, with bit n set if key n is held
+//
+// Quit: the user asked to close the emulator
+//
+// Rewind: the user is holding the rewind key
+//
+// Hotkeys: the hotkeys pressed since the last poll
+type InputState struct {
+	Keys    uint16
+	Quit    bool
+	Rewind  bool
+	Hotkeys []Hotkey
+}
+
+// InputSource reads input from the user.
+type InputSource interface {
+	// Poll handles any pending input and returns the current state.
+	Poll() InputState
+}
@@ -1,13 +1,59 @@
 package chip8
 
-import (
-	"crypto/rand"
-	"fmt"
-)
+// ExecInstr executes the instruction at the program counter and advances it.
+// If the instruction can't be executed an *ExecError is returned and the
+// program counter is left pointing at the faulting instruction. If Trace is
+// set it's called with a TraceEntry for each instruction that executes, and
+// if Journal is set each one is recorded into it so that it can be undone. If
+// Coverage is set each one is counted in it.
+func (c8 *Chip8) ExecInstr() error {
+	if c8.Trace == nil && c8.Journal == nil && c8.Coverage == nil {
+		return c8.execInstr()
+	}
+	pc := c8.programPtr
+	var opcode uint16
+	if c8.inMemory(pc, 2) {
+		opcode = uint16(c8.memory[pc])<<8 | uint16(c8.memory[pc+1])
+	}
+	var entry *TraceEntry
+	if c8.Trace != nil {
+		entry = c8.startTrace()
+	}
+	if c8.Journal != nil {
+		c8.Journal.begin(c8)
+	}
+	err := c8.execInstr()
+	if c8.Journal != nil {
+		c8.Journal.end(c8, err == nil)
+	}
+	if err != nil {
+		return err
+	}
+	if c8.Coverage != nil {
+		c8.Coverage.record(pc, opcode, c8.programPtr)
+	}
+	if entry != nil {
+		c8.finishTrace(entry)
+	}
+	return nil
+}
 
-func (c8 *Chip8) ExecInstr() {
+// execInstr is ExecInstr without the tracing, journalling and coverage.
+func (c8 *Chip8) execInstr() error {
+	c8.accesses = c8.accesses[:0]
+	if !c8.inMemory(c8.programPtr, 2) {
+		return c8.fault(ErrMemoryFault, 0)
+	}
 	nextInstr := c8.programPtr + 2
 	instr := c8.memory[c8.programPtr:nextInstr]
+	opcode := uint16(instr[0])<<8 | uint16(instr[1])
+	if c8.Provenance != nil {
+		size := 2
+		if opcode == 0xF000 && c8.quirks.XOChipInstructions {
+			size = 4
+		}
+		c8.Provenance.fetch(c8.programPtr, size, c8.cycles)
+	}
 
 	highI := instr[0]
 	lowI := instr[1]
@@ -23,30 +69,80 @@ func (c8 *Chip8) ExecInstr() {
 	// 00EE RET returns from a subroutine
 	case highI == 0x00 && lowI == 0xEE:
 		cs := c8.callStack
+		if len(cs) == 0 {
+			return c8.fault(ErrStackUnderflow, opcode)
+		}
 		nextInstr, c8.callStack = cs[len(cs)-1], cs[:len(cs)-1]
+	// 00Cn - SCD nibble - scroll the screen down n rows
+	case highI == 0x00 && lLowI == 0xC:
+		c8.FrameBuffer.scrollDown(int(rLowI))
+	// 00Dn - SCU nibble - scroll the selected planes up n rows
+	case highI == 0x00 && lLowI == 0xD && c8.quirks.XOChipInstructions:
+		c8.FrameBuffer.scrollDown(-int(rLowI))
+	// 00FB - SCR - scroll the screen right 4 pixels
+	case highI == 0x00 && lowI == 0xFB:
+		c8.FrameBuffer.scrollHorizontal(4)
+	// 00FC - SCL - scroll the screen left 4 pixels
+	case highI == 0x00 && lowI == 0xFC:
+		c8.FrameBuffer.scrollHorizontal(-4)
+	// 00FD - EXIT - stop the interpreter. The program counter is left on the
+	// EXIT so that executing again exits again.
+	case highI == 0x00 && lowI == 0xFD:
+		return c8.fault(ErrExit, opcode)
+	// 00FE - LOW - switch to the 64x32 screen
+	case highI == 0x00 && lowI == 0xFE:
+		c8.FrameBuffer.setHires(false)
+	// 00FF - HIGH - switch to the 128x64 screen
+	case highI == 0x00 && lowI == 0xFF:
+		c8.FrameBuffer.setHires(true)
 	// 1nnn - JP addr
 	case lHighI == 0x1:
 		addr := getAddr(instr)
 		nextInstr = addr
 	// 2nnn - CALL  addr - pushes program counter +2 to the call stack and makes program counter = nnn
 	case lHighI == 0x2:
+		if len(c8.callStack) >= STACK_SIZE {
+			return c8.fault(ErrStackOverflow, opcode)
+		}
 		c8.callStack = append(c8.callStack, c8.programPtr+2)
 		addr := getAddr(instr)
 		nextInstr = addr
 	// 3xkk - SE Vx, byte - Skip next instruction if Vx = kk
 	case lHighI == 0x3:
 		if c8.registers[rHighI] == lowI {
-			nextInstr += 2
+			nextInstr = c8.skip(nextInstr)
 		}
 	// 4xkk SNE Vx, byte - Skip next instruction if Vx != kk
 	case lHighI == 0x4:
 		if c8.registers[rHighI] != lowI {
-			nextInstr += 2
+			nextInstr = c8.skip(nextInstr)
 		}
 	// 5xy0 = SE Vx, Vy = Skip next instruction if Vx =  Vy.
-	case lHighI == 0x5:
+	case lHighI == 0x5 && rLowI == 0x0:
 		if c8.registers[rHighI] == c8.registers[lLowI] {
-			nextInstr += 2
+			nextInstr = c8.skip(nextInstr)
+		}
+	// 5xy2 - SAVE Vx, Vy - Store Vx to Vy, in that order, into memory starting at I.
+	// I is left unchanged.
+	case lHighI == 0x5 && rLowI == 0x2 && c8.quirks.XOChipInstructions:
+		regs := registerRange(rHighI, lLowI)
+		if !c8.inMemory(c8.regI, len(regs)) {
+			return c8.fault(ErrMemoryFault, opcode)
+		}
+		c8.access(c8.regI, len(regs), true)
+		for i, r := range regs {
+			c8.memory[c8.regI+uint16(i)] = c8.registers[r]
+		}
+	// 5xy3 - LOAD Vx, Vy - Load Vx to Vy, in that order, from memory starting at I.
+	// I is left unchanged.
+	case lHighI == 0x5 && rLowI == 0x3 && c8.quirks.XOChipInstructions:
+		regs := registerRange(rHighI, lLowI)
+		if !c8.inMemory(c8.regI, len(regs)) {
+			return c8.fault(ErrMemoryFault, opcode)
+		}
+		c8.access(c8.regI, len(regs), false)
+		for i, r := range regs {
+			c8.registers[r] = c8.memory[c8.regI+uint16(i)]
 		}
 	// 6xkk - LD Vx, byte - Load the byte value into the register specified by x
 	case lHighI == 0x6:
@@ -62,154 +158,156 @@ func (c8 *Chip8) ExecInstr() {
 		x := c8.registers[rHighI]
 		y := c8.registers[lLowI]
 		c8.registers[rHighI] = x | y
+		if c8.quirks.LogicResetsVF {
+			c8.registers[0xF] = 0
+		}
 	// 8xy2 - AND Vx, Vy Sets Vx to Vx & Vy
 	case lHighI == 0x8 && rLowI == 0x2:
 		x := c8.registers[rHighI]
 		y := c8.registers[lLowI]
 		c8.registers[rHighI] = x & y
+		if c8.quirks.LogicResetsVF {
+			c8.registers[0xF] = 0
+		}
 	// 8xy3 - XOR Vx, Vy
 	case lHighI == 0x8 && rLowI == 0x3:
 		x := c8.registers[rHighI]
 		y := c8.registers[lLowI]
 		c8.registers[rHighI] = x ^ y
-	// 8xy4 - ADD Vx, Vy - Sets Vx to Vx +  Vy and sets VF to 1 if there is an overflow, 0 otherwise.
-	case lHighI == 0x8 && rLowI == 0x4:
-		x := uint16(c8.registers[rHighI])
-		y := uint16(c8.registers[lLowI])
-		sum := x + y
-		if sum > 255 {
-			c8.registers[0xF] = 1
-			sum = sum & 255
-		} else {
+		if c8.quirks.LogicResetsVF {
 			c8.registers[0xF] = 0
 		}
-
+	// 8xy4 - ADD Vx, Vy - Sets Vx to Vx +  Vy and sets VF to 1 if there is an overflow, 0 otherwise.
+	// VF is set after Vx, so that the flag wins when Vx is VF.
+	case lHighI == 0x8 && rLowI == 0x4:
+		sum := uint16(c8.registers[rHighI]) + uint16(c8.registers[lLowI])
 		c8.registers[rHighI] = byte(sum)
-	// 8xy5 - SUB Vx, Vy - set Vx to Vx - Vy and VF = 1 iff Vx > Vy
+		c8.registers[0xF] = flag(sum > 255)
+	// 8xy5 - SUB Vx, Vy - set Vx to Vx - Vy and VF = 1 iff Vx >= Vy, i.e. there's no borrow
 	case lHighI == 0x8 && rLowI == 0x5:
 		x := c8.registers[rHighI]
 		y := c8.registers[lLowI]
-		if x > y {
-			c8.registers[0xF] = 1
-		} else {
-			c8.registers[0xF] = 0
-		}
-
 		c8.registers[rHighI] = x - y
-	// 8xy6 - SHR Vx, {, Vy}
+		c8.registers[0xF] = flag(x >= y)
+	// 8xy6 - SHR Vx {, Vy} - VF is set to the bit shifted out
 	case lHighI == 0x8 && rLowI == 0x6:
-		if (c8.registers[rHighI] & 0x01) > 0 {
-			c8.registers[0xF] = 1
-		} else {
-			c8.registers[0xF] = 0
+		v := c8.registers[rHighI]
+		if c8.quirks.ShiftUsesVy {
+			v = c8.registers[lLowI]
 		}
-
-		c8.registers[rHighI] = c8.registers[rHighI] >> 1
-	// 8xy7 - SUBN Vx, Vy - Set VF = 1 IFF Vy < Vx, Vx = Vy - Vx
+		c8.registers[rHighI] = v >> 1
+		c8.registers[0xF] = v & 0x01
+	// 8xy7 - SUBN Vx, Vy - Set Vx = Vy - Vx and VF = 1 iff Vy >= Vx, i.e. there's no borrow
 	case lHighI == 0x8 && rLowI == 0x7:
 		x := c8.registers[rHighI]
 		y := c8.registers[lLowI]
-		if y > x {
-			c8.registers[0xF] = 1
-		} else {
-			c8.registers[0xF] = 0
-		}
-
 		c8.registers[rHighI] = y - x
-	// 8xyE - SHL Vx {, Vy}
+		c8.registers[0xF] = flag(y >= x)
+	// 8xyE - SHL Vx {, Vy} - VF is set to the bit shifted out
 	case lHighI == 0x8 && rLowI == 0xE:
-		if (c8.registers[rHighI] & 0x80) > 0 {
-			c8.registers[0xF] = 1
-		} else {
-			c8.registers[0xF] = 0
+		v := c8.registers[rHighI]
+		if c8.quirks.ShiftUsesVy {
+			v = c8.registers[lLowI]
 		}
-
-		c8.registers[rHighI] = c8.registers[rHighI] << 1
+		c8.registers[rHighI] = v << 1
+		c8.registers[0xF] = v >> 7
 	// 9xy0 - SNE Vx, Vy - Skip next if Vx != Vy
 	case lHighI == 0x9:
 		if c8.registers[rHighI] != c8.registers[lLowI] {
-			nextInstr += 2
+			nextInstr = c8.skip(nextInstr)
 		}
 	// Annn - LD I, addr - Load the int16 addr specified by nnn into the I register
 	case lHighI == 0xA:
 		addr := getAddr(instr)
 		c8.regI = addr
 	// Bnnn - JP V0,  addr - Jump to location nnn + V0
+	// With the JumpUsesVx quirk this is Bxnn - Jump to location xnn + Vx
 	case lHighI == 0xB:
-		nextInstr = getAddr(instr) + uint16(c8.registers[0x0])
+		if c8.quirks.JumpUsesVx {
+			nextInstr = getAddr(instr) + uint16(c8.registers[rHighI])
+		} else {
+			nextInstr = getAddr(instr) + uint16(c8.registers[0x0])
+		}
 	// Cxkk - RND Vx, byte - generates a random byte, bitwise ANDs it with byte and
 	// stores the result in Vx
 	case lHighI == 0xC:
-		if lowI == 0xFB {
-			panic("asdf")
-		}
-		randBytes := make([]byte, 1, 1)
-		_, err := rand.Read(randBytes)
+		randByte, err := c8.random.Byte()
 		if err != nil {
-			panic(err)
+			return c8.fault(err, opcode)
 		}
 
-		c8.registers[rHighI] = (lowI & randBytes[0])
+		c8.registers[rHighI] = (lowI & randByte)
 	// Dxyn - DRW Vx, Vy, nibble - grab an nibble length byte from I and draw it at the
 	// values of Vx and Vy. If at least one pixel is erased set VF to 1 otherwise to 0
-	// if a part of the sprite is located off screen - wrap it.
+	// if a part of the sprite is located off screen - wrap it, or clip it with the
+	// ClipSprites quirk.
+	// Dxy0 - DRW Vx, Vy, 0 - draw a 16x16 sprite, 2 bytes per row, instead.
+	// When more than one XO-CHIP plane is selected the sprite data for each
+	// plane follows on from the last.
 	case lHighI == 0xD:
-		xOffset := 56 - int(c8.registers[rHighI])
-		yOffset := c8.registers[lLowI]
-		length := rLowI
+		width, length := 8, int(rLowI)
+		if length == 0 {
+			width, length = 16, 32
+		}
+		length *= c8.FrameBuffer.selectedCount()
 
+		if !c8.inMemory(c8.regI, length) {
+			return c8.fault(ErrMemoryFault, opcode)
+		}
+		c8.access(c8.regI, length, false)
 		sprite := c8.memory[c8.regI : c8.regI+uint16(length)]
-		//for _, line := range sprite {
-		//	fmt.Printf("%08b\n", line)
-		//}
-
-		c8.registers[0xF] = 0
-		for i := 0; i < int(length); i++ {
-			var spriteRow uint64
-			// if we need to wrap
-			if xOffset < 0 {
-				unWrappedOffset := xOffset * -1
-				unWrapped := uint64(sprite[i]) >> (uint(unWrappedOffset))
-				wrapped := uint64(sprite[i]) << uint(64+xOffset)
-				spriteRow = unWrapped ^ wrapped
-
-			} else {
-				spriteRow = uint64(sprite[i]) << uint(xOffset)
-			}
-			y := (yOffset + byte(i)) % 32
-			currentRow := c8.FrameBuffer.Buffer[y]
 
-			collisionFree := currentRow | spriteRow
-			c8.FrameBuffer.Buffer[y] = currentRow ^ spriteRow
-			if collisionFree^c8.FrameBuffer.Buffer[y] > 0 && c8.registers[0xF] == 0 {
-				c8.registers[0xF] = 1
-			}
+		x := int(c8.registers[rHighI])
+		y := int(c8.registers[lLowI])
+		if c8.FrameBuffer.drawSprite(x, y, sprite, width, c8.quirks.ClipSprites) {
+			c8.registers[0xF] = 1
+		} else {
+			c8.registers[0xF] = 0
 		}
 	// Ex9E -  SKP Vx - Skip next instruction if key with the value of Vx is pressed
 	case lHighI == 0xE && lowI == 0x9E:
 		key := c8.registers[rHighI]
 		pressed := c8.Keyboard.isPressed(key)
 		if pressed {
-			nextInstr += 2
+			nextInstr = c8.skip(nextInstr)
 		}
 	// ExA1 - SKNP Vx - Skips the next instruction if the key with Vxs value is not pressed
 	case lHighI == 0xE && lowI == 0xA1:
 		key := c8.registers[rHighI]
 		pressed := c8.Keyboard.isPressed(key)
 		if !pressed {
-			nextInstr += 2
+			nextInstr = c8.skip(nextInstr)
 		}
+	// F000 nnnn - LD I, LONG addr - Load the 16 bit address in the following two
+	// bytes into I
+	case highI == 0xF0 && lowI == 0x00 && c8.quirks.XOChipInstructions:
+		if !c8.inMemory(c8.programPtr, 4) {
+			return c8.fault(ErrMemoryFault, opcode)
+		}
+		c8.regI = uint16(c8.memory[c8.programPtr+2])<<8 | uint16(c8.memory[c8.programPtr+3])
+		nextInstr += 2
+	// Fn01 - PLANE n - Select the planes that drawing instructions affect
+	case lHighI == 0xF && lowI == 0x01 && c8.quirks.XOChipInstructions:
+		c8.FrameBuffer.selectPlanes(rHighI)
+	// F002 - AUDIO - Load the 16 byte audio pattern buffer from memory starting at I
+	case highI == 0xF0 && lowI == 0x02 && c8.quirks.XOChipInstructions:
+		if !c8.inMemory(c8.regI, len(c8.audioPattern)) {
+			return c8.fault(ErrMemoryFault, opcode)
+		}
+		c8.access(c8.regI, len(c8.audioPattern), false)
+		copy(c8.audioPattern[:], c8.memory[c8.regI:])
+		c8.updateAudio()
 	// Fx07 - LD Vx, DT - Set Vx to be the value of the delay timer
 	case lHighI == 0xF && lowI == 0x07:
 		c8.registers[rHighI] = c8.delayTimer.Read()
 	// Fx0A - LD Vx K - pause until a key is pressed and store the key in Vx
+	// If no key is pressed the program counter is left where it is, so the
+	// instruction runs again next cycle rather than holding up the frame.
 	case lHighI == 0xF && lowI == 0x0A:
-		key := byte(0xFF)
-		for {
-			key = c8.Keyboard.nextPress()
-			if key != 0xFF {
-				break
-			}
+		key := c8.Keyboard.nextPress()
+		if key == 0xFF {
+			nextInstr = c8.programPtr
+			break
 		}
 		c8.registers[rHighI] = key
 	// Fx15 - LD DT, Vx - Set the delay timer the the value of Vx
@@ -228,6 +326,10 @@ func (c8 *Chip8) ExecInstr() {
 		tens := (c8.registers[rHighI] % 100) / 10
 		hundreds := c8.registers[rHighI] / 100
 
+		if !c8.inMemory(c8.regI, 3) {
+			return c8.fault(ErrMemoryFault, opcode)
+		}
+		c8.access(c8.regI, 3, true)
 		c8.memory[c8.regI] = hundreds
 		c8.memory[c8.regI+1] = tens
 		c8.memory[c8.regI+2] = ones
@@ -235,26 +337,113 @@ func (c8 *Chip8) ExecInstr() {
 	case lHighI == 0xF && lowI == 0x29:
 		// the built in sprites are stored at memory location 0, in order, with 5 bytes to a sprite.
 		c8.regI = uint16(c8.registers[rHighI] * 5)
+	// Fx30 - LD HF, Vx - Set I to the location of the big built in sprite for Vx's value
+	case lHighI == 0xF && lowI == 0x30:
+		// the big sprites are stored after the small ones, with 10 bytes to a sprite.
+		c8.regI = BIG_FONT_OFFSET + uint16(c8.registers[rHighI]&0xF)*10
+	// Fx3A - LD PITCH, Vx - Set the playback rate of the audio pattern buffer
+	case lHighI == 0xF && lowI == 0x3A && c8.quirks.XOChipInstructions:
+		c8.pitch = c8.registers[rHighI]
+		c8.updateAudio()
 	// Fx55 - LD [I], Vx Load values from Vx into memory starting at I
 	case lHighI == 0xF && lowI == 0x55:
 		cursor := c8.regI
+		if !c8.inMemory(cursor, int(rHighI)+1) {
+			return c8.fault(ErrMemoryFault, opcode)
+		}
+		c8.access(cursor, int(rHighI)+1, true)
 		var i byte
 		for i = 0; i <= rHighI; i++ {
 			c8.memory[cursor+uint16(i)] = c8.registers[i]
 		}
+		if c8.quirks.LoadStoreIncrementsI {
+			c8.regI += uint16(rHighI) + 1
+		}
 	// Fx65 - LD Vx, [I] Load values from I into registers V0 to Vx
 	case lHighI == 0xF && lowI == 0x65:
 		cursor := c8.regI
+		if !c8.inMemory(cursor, int(rHighI)+1) {
+			return c8.fault(ErrMemoryFault, opcode)
+		}
+		c8.access(cursor, int(rHighI)+1, false)
 		var i byte
 		for i = 0; i <= rHighI; i++ {
 			c8.registers[i] = c8.memory[cursor+uint16(i)]
 		}
+		if c8.quirks.LoadStoreIncrementsI {
+			c8.regI += uint16(rHighI) + 1
+		}
+	// Fx75 - LD R, Vx - Store V0 to Vx in the RPL user flags
+	case lHighI == 0xF && lowI == 0x75:
+		var i byte
+		for i = 0; i <= rHighI; i++ {
+			c8.rplFlags[i] = c8.registers[i]
+		}
+	// Fx85 - LD Vx, R - Read V0 to Vx from the RPL user flags
+	case lHighI == 0xF && lowI == 0x85:
+		var i byte
+		for i = 0; i <= rHighI; i++ {
+			c8.registers[i] = c8.rplFlags[i]
+		}
 	default:
-		msg := fmt.Sprintf("Unknown Instruction: %X\n", instr)
-		panic(msg)
+		return c8.fault(ErrUnknownOpcode, opcode)
 	}
 
 	c8.programPtr = nextInstr
+	c8.cycles++
+	return nil
+}
+
+// skip returns the address of the instruction after the one at addr. It's
+// used by the conditional skips, which have to step over both halves of the
+// four byte F000 nnnn instruction when it's an instruction.
+func (c8 *Chip8) skip(addr uint16) uint16 {
+	if c8.quirks.XOChipInstructions && c8.inMemory(addr, 2) && c8.memory[addr] == 0xF0 && c8.memory[addr+1] == 0x00 {
+		return addr + 4
+	}
+	return addr + 2
+}
+
+// IsSkip reports whether opcode is one of the conditional skips: 3xkk, 4xkk,
+// 5xy0, 9xy0, Ex9E and ExA1.
+func IsSkip(opcode uint16) bool {
+	switch opcode >> 12 {
+	case 0x3, 0x4:
+		return true
+	case 0x5, 0x9:
+		return opcode&0xF == 0
+	case 0xE:
+		return opcode&0xFF == 0x9E || opcode&0xFF == 0xA1
+	}
+	return false
+}
+
+// registerRange returns the registers from x to y inclusive, counting down if
+// y is less than x.
+func registerRange(x, y byte) []byte {
+	regs := []byte{x}
+	for r := x; r != y; {
+		if y > x {
+			r++
+		} else {
+			r--
+		}
+		regs = append(regs, r)
+	}
+	return regs
+}
+
+// flag returns 1 if b is true and 0 otherwise, for setting VF.
+func flag(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// inMemory reports whether the n bytes starting at addr are all addressable.
+func (c8 *Chip8) inMemory(addr uint16, n int) bool {
+	return int(addr)+n <= len(c8.memory)
 }
 
 func lNib(b byte) byte {
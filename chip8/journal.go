@@ -0,0 +1,226 @@
+package chip8
+
+// JOURNAL_SIZE is the default number of instructions kept in a Journal, which
+// is about nine minutes at the default speed.
+const JOURNAL_SIZE = 1 << 18
+
+// Journal records how to undo each of the most recent instructions executed,
+// so that execution can be stepped backwards an instruction at a time.
+//
+// Rather than a snapshot of the whole machine, each instruction is kept as the
+// few things it changed: the old values of the registers it changed and of the
+// memory it wrote, and the words of the screen it changed. The small fixed
+// state, such as the program counter and the timers, is kept as it was.
+// Once the journal is full the oldest instructions are forgotten.
+type Journal struct {
+	capacity int
+	entries  []undo
+	start    int
+	count    int
+
+	// The state of the instruction being executed, from begin until end.
+	current  undo
+	regs     [16]byte
+	stack    []uint16
+	rplFlags [16]byte
+	audio    [16]byte
+	screen   [PLANES][]uint64
+	// drawing is whether the instruction can change the screen, and so
+	// whether screen has been saved.
+	drawing bool
+}
+
+// undo is how to undo one instruction.
+type undo struct {
+	pc, i          uint16
+	cycles, frames uint64
+	delay, beep    byte
+	random         uint64
+	pitch          byte
+	width, height  int
+	selected       byte
+	regs           []RegisterChange
+	stack          []uint16
+	stackChanged   bool
+	rplFlags       *[16]byte
+	audio          *[16]byte
+	memory         []MemoryWrite
+	// origins are the old origins of the bytes in memory, if the Chip8 has a
+	// Provenance.
+	origins  [][]Origin
+	screen   []screenWord
+	accesses []MemoryAccess
+}
+
+// screenWord is the old value of a word of one of the screen's planes.
+type screenWord struct {
+	plane int
+	index int
+	value uint64
+}
+
+func NewJournal(capacity int) *Journal {
+	j := &Journal{capacity: capacity}
+	for p := range j.screen {
+		j.screen[p] = make([]uint64, HIRES_HEIGHT*rowWords)
+	}
+	return j
+}
+
+// Len returns the number of instructions that can be undone.
+func (j *Journal) Len() int {
+	return j.count
+}
+
+// Clear forgets every instruction, e.g. because the machine has been loaded
+// from a save state that the journal doesn't lead back to.
+func (j *Journal) Clear() {
+	j.entries, j.start, j.count = nil, 0, 0
+}
+
+// StepBack undoes the most recent instruction in the journal, and forgets it.
+// Afterwards c8.Accesses returns the memory the undone instruction accessed.
+// Changes made to the machine other than by executing instructions, e.g. by a
+// debugger, aren't undone.
+func (j *Journal) StepBack(c8 *Chip8) error {
+	if j.count == 0 {
+		return ErrNoHistory
+	}
+	j.count--
+	u := &j.entries[(j.start+j.count)%j.capacity]
+	for i := len(u.memory) - 1; i >= 0; i-- {
+		copy(c8.memory[u.memory[i].Addr:], u.memory[i].Data)
+		if c8.Provenance != nil && i < len(u.origins) {
+			copy(c8.Provenance.origins[u.memory[i].Addr:], u.origins[i])
+		}
+	}
+	for _, w := range u.screen {
+		c8.FrameBuffer.Planes[w.plane][w.index] = w.value
+	}
+	c8.FrameBuffer.Width, c8.FrameBuffer.Height = u.width, u.height
+	c8.FrameBuffer.selected = u.selected
+	for _, r := range u.regs {
+		c8.registers[r.V] = r.Value
+	}
+	c8.regI, c8.programPtr = u.i, u.pc
+	if u.stackChanged {
+		c8.callStack = append([]uint16{}, u.stack...)
+	}
+	c8.cycles, c8.frames = u.cycles, u.frames
+	c8.delayTimer.Set(u.delay)
+	c8.beepTimer.Set(u.beep)
+	if seeded, ok := c8.random.(*SeededSource); ok {
+		seeded.counter = u.random
+	}
+	if u.rplFlags != nil {
+		c8.rplFlags = *u.rplFlags
+	}
+	if u.audio != nil || u.pitch != c8.pitch {
+		if u.audio != nil {
+			c8.audioPattern = *u.audio
+		}
+		c8.pitch = u.pitch
+		c8.updateAudio()
+	}
+	c8.accesses = append(c8.accesses[:0], u.accesses...)
+	*u = undo{}
+	return nil
+}
+
+// begin notes the state of c8 before it executes an instruction.
+func (j *Journal) begin(c8 *Chip8) {
+	fb := c8.FrameBuffer
+	j.current = undo{
+		pc:       c8.programPtr,
+		i:        c8.regI,
+		cycles:   c8.cycles,
+		frames:   c8.frames,
+		delay:    c8.delayTimer.Read(),
+		beep:     c8.beepTimer.Read(),
+		pitch:    c8.pitch,
+		width:    fb.Width,
+		height:   fb.Height,
+		selected: fb.selected,
+	}
+	if seeded, ok := c8.random.(*SeededSource); ok {
+		j.current.random = seeded.counter
+	}
+	for i := range j.regs {
+		j.regs[i] = c8.registers[byte(i)]
+	}
+	j.stack = append(j.stack[:0], c8.callStack...)
+	j.rplFlags, j.audio = c8.rplFlags, c8.audioPattern
+	j.drawing = c8.inMemory(c8.programPtr, 2) && drawsOnScreen(c8.memory[c8.programPtr], c8.memory[c8.programPtr+1])
+	if j.drawing {
+		for p := range j.screen {
+			copy(j.screen[p], fb.Planes[p])
+		}
+	}
+}
+
+// drawsOnScreen reports whether the instruction starting with the bytes high
+// and low can change the screen: the 00xx display instructions, and Dxyn.
+func drawsOnScreen(high, low byte) bool {
+	return high == 0x00 || lNib(high) == 0xD
+}
+
+// saveMemory keeps the old value of the n bytes at addr, which the
+// instruction being executed is about to write.
+func (j *Journal) saveMemory(c8 *Chip8, addr uint16, n int) {
+	old := append([]byte{}, c8.memory[addr:int(addr)+n]...)
+	j.current.memory = append(j.current.memory, MemoryWrite{Addr: addr, Data: old})
+	if c8.Provenance != nil {
+		origins := append([]Origin{}, c8.Provenance.origins[addr:int(addr)+n]...)
+		j.current.origins = append(j.current.origins, origins)
+	}
+}
+
+// end works out what the instruction changed and adds it to the journal. If
+// the instruction faulted it's dropped, since it didn't change anything.
+func (j *Journal) end(c8 *Chip8, ok bool) {
+	if !ok {
+		return
+	}
+	u := j.current
+	for i, v := range j.regs {
+		if c8.registers[byte(i)] != v {
+			u.regs = append(u.regs, RegisterChange{V: byte(i), Value: v})
+		}
+	}
+	// Only CALL and RET change the stack, and they always change its depth.
+	if len(c8.callStack) != len(j.stack) {
+		u.stack, u.stackChanged = append([]uint16{}, j.stack...), true
+	}
+	if c8.rplFlags != j.rplFlags {
+		old := j.rplFlags
+		u.rplFlags = &old
+	}
+	if c8.audioPattern != j.audio {
+		old := j.audio
+		u.audio = &old
+	}
+	for p, plane := range c8.FrameBuffer.Planes {
+		for i := 0; j.drawing && i < len(plane); i++ {
+			if plane[i] != j.screen[p][i] {
+				u.screen = append(u.screen, screenWord{plane: p, index: i, value: j.screen[p][i]})
+			}
+		}
+	}
+	u.accesses = append([]MemoryAccess{}, c8.accesses...)
+	j.push(u)
+}
+
+// push adds u to the journal, forgetting the oldest instruction if it's full.
+func (j *Journal) push(u undo) {
+	if j.count == j.capacity {
+		j.start = (j.start + 1) % j.capacity
+		j.count--
+	}
+	at := (j.start + j.count) % j.capacity
+	if at == len(j.entries) {
+		j.entries = append(j.entries, u)
+	} else {
+		j.entries[at] = u
+	}
+	j.count++
+}
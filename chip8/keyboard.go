@@ -24,6 +24,13 @@ func (k *Keyboard) Update(newState uint16) {
 	k.mutex.Unlock()
 }
 
+// current returns the state of every key, with bit n set if key n is pressed.
+func (k *Keyboard) current() uint16 {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.state
+}
+
 func (k *Keyboard) isPressed(key byte) bool {
 	k.mutex.Lock()
 	defer k.mutex.Unlock()
@@ -0,0 +1,63 @@
+package chip8
+
+// MemoryAccess is a read or write of a run of memory by an instruction.
+// Instruction fetches aren't counted.
+type MemoryAccess struct {
+	Addr  uint16
+	Len   int
+	Write bool
+}
+
+// Accesses returns the memory read and written by the last instruction
+// executed, or undone by Journal.StepBack. The slice is reused by the next
+// instruction.
+func (c8 *Chip8) Accesses() []MemoryAccess {
+	return c8.accesses
+}
+
+// Cycles returns the number of instructions executed so far.
+func (c8 *Chip8) Cycles() uint64 {
+	return c8.cycles
+}
+
+// ReadMemory returns a copy of the n bytes of memory starting at addr.
+func (c8 *Chip8) ReadMemory(addr uint16, n int) ([]byte, error) {
+	if n < 0 || !c8.inMemory(addr, n) {
+		return nil, ErrMemoryFault
+	}
+	return append([]byte{}, c8.memory[addr:int(addr)+n]...), nil
+}
+
+// WriteMemory copies data into memory starting at addr.
+func (c8 *Chip8) WriteMemory(addr uint16, data []byte) error {
+	if !c8.inMemory(addr, len(data)) {
+		return ErrMemoryFault
+	}
+	copy(c8.memory[addr:], data)
+	if c8.Provenance != nil {
+		c8.Provenance.set(addr, len(data), Origin{Kind: OriginExternal})
+	}
+	return nil
+}
+
+// MemorySize returns the number of bytes of addressable memory.
+func (c8 *Chip8) MemorySize() int {
+	return len(c8.memory)
+}
+
+// access records that the current instruction read or wrote n bytes at addr.
+// It must be called before the memory is written.
+func (c8 *Chip8) access(addr uint16, n int, write bool) {
+	if write && c8.Journal != nil {
+		c8.Journal.saveMemory(c8, addr, n)
+	}
+	if write && c8.Provenance != nil {
+		c8.Provenance.set(addr, n, Origin{Kind: OriginInstruction, PC: c8.programPtr, Cycle: c8.cycles})
+	}
+	c8.accesses = append(c8.accesses, MemoryAccess{Addr: addr, Len: n, Write: write})
+}
+
+// Frames returns the number of frames RunFrame has finished so far.
+func (c8 *Chip8) Frames() uint64 {
+	return c8.frames
+}
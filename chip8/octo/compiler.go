@@ -0,0 +1,695 @@
+package octo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/symbols"
+)
+
+// The ways a reference to a label that isn't defined yet is filled in once
+// it is.
+const (
+	fixAddr       = iota // the low 12 bits of an instruction
+	fixLong              // the 16 bits after F000
+	fixByte              // a byte of data
+	fixWord              // 16 bits of data
+	fixUnpack            // the bytes loaded by the two instructions of :unpack
+	fixUnpackLong        // the same for :unpack long
+)
+
+// fixup is a reference to a label that wasn't defined when it was compiled.
+//
+// addr: where the reference is
+//
+// kind: how to fill it in
+//
+// name: the label
+//
+// nibble: for :unpack, the nibble that goes above the address
+type fixup struct {
+	addr   int
+	kind   int
+	name   token
+	nibble int
+}
+
+// block is an if ... begin, else or loop that hasn't been closed yet.
+//
+// at: the token that opened it
+//
+// jump: for begin and else, the address of the jump to fill in with where
+// the block ends
+//
+// start, breaks: for loop, where it starts and the jumps out of it from while
+type block struct {
+	at     token
+	jump   int
+	start  int
+	breaks []int
+}
+
+// macro is a macro defined with :macro.
+type macro struct {
+	args []string
+	body []token
+}
+
+type compiler struct {
+	file string
+	// tokens are the tokens being compiled, from pos on. The ones before pos
+	// have been read, and are overwritten as macros are expanded.
+	tokens   []token
+	pos      int
+	rom      []byte
+	here     int
+	size     int
+	labels   map[string]int
+	consts   map[string]float64
+	aliases  map[string]byte
+	macros   map[string]*macro
+	fixups   []fixup
+	blocks   []*block
+	symbols  *symbols.Table
+	mainJump bool
+	// expanded counts macro expansions, to stop one that expands forever.
+	expanded int
+}
+
+// MAX_EXPANSIONS is the most macros a program can expand, which stops a
+// macro that invokes itself.
+const MAX_EXPANSIONS = 100000
+
+func newCompiler(file string, toks []token) *compiler {
+	c := &compiler{
+		file:    file,
+		tokens:  toks,
+		here:    chip8.PROGRAM_OFFSET,
+		labels:  map[string]int{},
+		consts:  map[string]float64{},
+		aliases: map[string]byte{},
+		macros:  map[string]*macro{},
+		symbols: symbols.New(),
+	}
+	// Jump to main, which is taken out again if main turns out to be here.
+	c.fixups = append(c.fixups, fixup{addr: c.here, kind: fixAddr, name: token{text: "main", line: 1, col: 1}})
+	c.emit(0x10, 0x00)
+	c.mainJump = true
+	return c
+}
+
+// errorf stops the compile with an error at t.
+func (c *compiler) errorf(t token, format string, args ...interface{}) {
+	panic(&Error{File: c.file, Line: t.line, Col: t.col, Msg: fmt.Sprintf(format, args...)})
+}
+
+// bytes returns the compiled program.
+func (c *compiler) bytes() []byte {
+	return c.rom[:c.size]
+}
+
+func (c *compiler) done() bool {
+	return c.pos >= len(c.tokens)
+}
+
+// next returns the next token, which has to be there since after is expecting
+// it.
+func (c *compiler) next(after token) token {
+	if c.done() {
+		c.errorf(after, "expected something after %s", after.text)
+	}
+	t := c.tokens[c.pos]
+	c.pos++
+	return t
+}
+
+func (c *compiler) peek() string {
+	if c.done() {
+		return ""
+	}
+	return c.tokens[c.pos].text
+}
+
+// expect reads the next token, which has to be text.
+func (c *compiler) expect(after token, text string) token {
+	t := c.next(after)
+	if t.text != text {
+		c.errorf(t, "expected %s but found %s", text, t.text)
+	}
+	return t
+}
+
+// emit writes bytes at here.
+func (c *compiler) emit(data ...byte) {
+	for _, b := range data {
+		if c.here >= 0x10000 {
+			panic(&Error{File: c.file, Line: c.tokens[c.pos-1].line, Col: c.tokens[c.pos-1].col, Msg: "the program doesn't fit in memory"})
+		}
+		i := c.here - chip8.PROGRAM_OFFSET
+		for i >= len(c.rom) {
+			c.rom = append(c.rom, 0)
+		}
+		c.rom[i] = b
+		c.here++
+		if i+1 > c.size {
+			c.size = i + 1
+		}
+	}
+}
+
+// instr writes an instruction compiled from t.
+func (c *compiler) instr(t token, op uint16) {
+	c.symbols.SetLine(uint16(c.here), symbols.Source{File: c.file, Line: t.line})
+	c.emit(byte(op>>8), byte(op))
+}
+
+// patch fills in the low 12 bits of the instruction at addr with target.
+func (c *compiler) patch(addr, target int) {
+	i := addr - chip8.PROGRAM_OFFSET
+	c.rom[i] = c.rom[i]&0xF0 | byte(target>>8&0xF)
+	c.rom[i+1] = byte(target)
+}
+
+func (c *compiler) compile() {
+	for !c.done() {
+		c.statement(c.next(token{}))
+	}
+	if len(c.blocks) > 0 {
+		b := c.blocks[len(c.blocks)-1]
+		c.errorf(b.at, "%s isn't closed", b.at.text)
+	}
+	for _, f := range c.fixups {
+		addr, ok := c.labels[f.name.text]
+		if !ok {
+			if f.name.text == "main" {
+				c.errorf(f.name, "the program has no main label to start at")
+			}
+			c.errorf(f.name, "%s isn't defined", f.name.text)
+		}
+		c.fill(f, addr)
+	}
+}
+
+// fill fills in a fixup with addr.
+func (c *compiler) fill(f fixup, addr int) {
+	i := f.addr - chip8.PROGRAM_OFFSET
+	switch f.kind {
+	case fixAddr:
+		if addr > 0xFFF {
+			c.errorf(f.name, "%s is at 0x%X, which is too high for the instruction", f.name.text, addr)
+		}
+		c.patch(f.addr, addr)
+	case fixLong, fixWord:
+		c.rom[i], c.rom[i+1] = byte(addr>>8), byte(addr)
+	case fixByte:
+		c.rom[i] = byte(addr)
+	case fixUnpack:
+		c.rom[i+1] = byte(f.nibble<<4 | addr>>8&0xF)
+		c.rom[i+3] = byte(addr)
+	case fixUnpackLong:
+		c.rom[i+1] = byte(addr >> 8)
+		c.rom[i+3] = byte(addr)
+	}
+}
+
+// label defines name as here.
+func (c *compiler) label(name token) {
+	c.define(name)
+	if name.text == "main" && c.mainJump && c.here == chip8.PROGRAM_OFFSET+2 && len(c.labels) == 0 {
+		// main is the first thing, so there's no need to jump to it.
+		c.fixups = c.fixups[1:]
+		c.here, c.size = chip8.PROGRAM_OFFSET, 0
+		c.symbols = symbols.New()
+	}
+	c.mainJump = false
+	c.labels[name.text] = c.here
+	c.symbols.SetLabel(uint16(c.here), name.text)
+}
+
+// define checks that name can be given to something new.
+func (c *compiler) define(name token) {
+	if !isIdent(name.text) {
+		c.errorf(name, "%s isn't a valid name", name.text)
+	}
+	if _, ok := c.register(name.text); ok || keywords[name.text] {
+		c.errorf(name, "%s is a reserved word", name.text)
+	}
+	_, label := c.labels[name.text]
+	_, constant := c.consts[name.text]
+	_, mac := c.macros[name.text]
+	if label || constant || mac {
+		c.errorf(name, "%s is already defined", name.text)
+	}
+}
+
+// keywords can't be used as names.
+var keywords = map[string]bool{}
+
+func init() {
+	for _, k := range strings.Fields(`: := += -= =- |= &= ^= >>= <<= == != < > <= >= ; return clear bcd save
+		load sprite jump jump0 native hires lores scroll-down scroll-up scroll-left scroll-right exit
+		saveflags loadflags plane audio if then begin else end loop again while key -key i delay
+		buzzer pitch random hex bighex long`) {
+		keywords[k] = true
+	}
+}
+
+// statement compiles the statement starting with t.
+func (c *compiler) statement(t token) {
+	if _, ok := c.register(t.text); ok {
+		c.assign(t)
+		return
+	}
+	if op, ok := simple[t.text]; ok {
+		c.instr(t, op)
+		return
+	}
+	if d, ok := directives[t.text]; ok {
+		d(c, t)
+		return
+	}
+	switch t.text {
+	case ":":
+		c.label(c.next(t))
+	case "bcd", "save", "load", "saveflags", "loadflags":
+		c.registerOp(t)
+	case "sprite":
+		x := c.reg(c.next(t))
+		y := c.reg(c.next(t))
+		n := c.number(c.next(t), 0, 15)
+		c.instr(t, 0xD000|uint16(x)<<8|uint16(y)<<4|uint16(n))
+	case "jump", "jump0", "native":
+		op := map[string]uint16{"jump": 0x1000, "jump0": 0xB000, "native": 0x0000}[t.text]
+		c.addrInstr(t, op, c.next(t))
+	case "scroll-down", "scroll-up":
+		op := map[string]uint16{"scroll-down": 0x00C0, "scroll-up": 0x00D0}[t.text]
+		c.instr(t, op|uint16(c.number(c.next(t), 0, 15)))
+	case "plane":
+		c.instr(t, 0xF001|uint16(c.number(c.next(t), 0, 3))<<8)
+	case "i":
+		c.assignI(t)
+	case "delay", "buzzer", "pitch":
+		c.expect(t, ":=")
+		x := c.reg(c.next(t))
+		op := map[string]uint16{"delay": 0xF015, "buzzer": 0xF018, "pitch": 0xF03A}[t.text]
+		c.instr(t, op|uint16(x)<<8)
+	case "if":
+		c.ifStatement(t)
+	case "else":
+		c.elseStatement(t)
+	case "end":
+		b := c.closeBlock(t, "begin", "else")
+		c.patch(b.jump, c.here)
+	case "loop":
+		c.blocks = append(c.blocks, &block{at: t, start: c.here})
+	case "while":
+		c.whileStatement(t)
+	case "again":
+		b := c.closeBlock(t, "loop")
+		c.instr(t, 0x1000|uint16(b.start))
+		for _, at := range b.breaks {
+			c.patch(at, c.here)
+		}
+	default:
+		if m, ok := c.macros[t.text]; ok {
+			c.expand(t, m)
+			return
+		}
+		if v, ok := c.constant(t.text); ok {
+			c.emit(byte(int(v)))
+			return
+		}
+		if isNumber(t.text) {
+			c.emit(byte(c.number(t, -128, 255)))
+			return
+		}
+		if isIdent(t.text) {
+			// A bare name calls the subroutine at the label.
+			c.addrInstr(t, 0x2000, t)
+			return
+		}
+		c.errorf(t, "unexpected %s", t.text)
+	}
+}
+
+// simple holds the instructions that are a single word.
+var simple = map[string]uint16{
+	"clear":        0x00E0,
+	"return":       0x00EE,
+	";":            0x00EE,
+	"scroll-right": 0x00FB,
+	"scroll-left":  0x00FC,
+	"exit":         0x00FD,
+	"lores":        0x00FE,
+	"hires":        0x00FF,
+	"audio":        0xF002,
+}
+
+// registerOp compiles the instructions that take a register, and save and
+// load of a range of registers.
+func (c *compiler) registerOp(t token) {
+	x := c.reg(c.next(t))
+	if (t.text == "save" || t.text == "load") && c.peek() == "-" {
+		dash := c.next(t)
+		y := c.reg(c.next(dash))
+		op := map[string]uint16{"save": 0x5002, "load": 0x5003}[t.text]
+		c.instr(t, op|uint16(x)<<8|uint16(y)<<4)
+		return
+	}
+	op := map[string]uint16{"bcd": 0xF033, "save": 0xF055, "load": 0xF065, "saveflags": 0xF075, "loadflags": 0xF085}[t.text]
+	c.instr(t, op|uint16(x)<<8)
+}
+
+// addrInstr compiles an instruction that takes a 12 bit address from a.
+func (c *compiler) addrInstr(t token, op uint16, a token) {
+	addr := c.here
+	c.instr(t, op|uint16(c.address(a, addr, fixAddr, 0xFFF)))
+}
+
+// address returns the value of a, a number, constant or label, which has to
+// be no more than max. A label that isn't defined yet is filled in later at
+// at, in the way kind says, and 0 is returned for now.
+func (c *compiler) address(a token, at int, kind int, max int) int {
+	if v, ok := c.labels[a.text]; ok {
+		if v > max {
+			c.errorf(a, "%s is at 0x%X, which is too high here", a.text, v)
+		}
+		return v
+	}
+	if _, ok := c.constant(a.text); ok || isNumber(a.text) {
+		return c.number(a, 0, max)
+	}
+	if !isIdent(a.text) {
+		c.errorf(a, "expected an address but found %s", a.text)
+	}
+	c.fixups = append(c.fixups, fixup{addr: at, kind: kind, name: a})
+	return 0
+}
+
+// assign compiles the statements that assign to a register.
+func (c *compiler) assign(t token) {
+	x := uint16(c.reg(t))
+	op := c.next(t)
+	rhs := c.next(op)
+	if y, ok := c.register(rhs.text); ok {
+		ops := map[string]uint16{":=": 0x0, "|=": 0x1, "&=": 0x2, "^=": 0x3, "+=": 0x4, "-=": 0x5, ">>=": 0x6, "=-": 0x7, "<<=": 0xE}
+		n, ok := ops[op.text]
+		if !ok {
+			c.errorf(op, "can't %s a register", op.text)
+		}
+		c.instr(t, 0x8000|x<<8|uint16(y)<<4|n)
+		return
+	}
+	switch op.text {
+	case ":=":
+		switch rhs.text {
+		case "random":
+			c.instr(t, 0xC000|x<<8|uint16(c.number(c.next(rhs), -128, 255)&0xFF))
+		case "delay":
+			c.instr(t, 0xF007|x<<8)
+		case "key":
+			c.instr(t, 0xF00A|x<<8)
+		default:
+			c.instr(t, 0x6000|x<<8|uint16(c.number(rhs, -128, 255)&0xFF))
+		}
+	case "+=":
+		c.instr(t, 0x7000|x<<8|uint16(c.number(rhs, -128, 255)&0xFF))
+	case "-=":
+		c.instr(t, 0x7000|x<<8|uint16(-c.number(rhs, -255, 128)&0xFF))
+	default:
+		c.errorf(op, "can't %s a number", op.text)
+	}
+}
+
+// assignI compiles the statements that assign to i.
+func (c *compiler) assignI(t token) {
+	op := c.next(t)
+	rhs := c.next(op)
+	switch {
+	case op.text == "+=":
+		c.instr(t, 0xF01E|uint16(c.reg(rhs))<<8)
+	case op.text != ":=":
+		c.errorf(op, "can't %s i", op.text)
+	case rhs.text == "hex":
+		c.instr(t, 0xF029|uint16(c.reg(c.next(rhs)))<<8)
+	case rhs.text == "bighex":
+		c.instr(t, 0xF030|uint16(c.reg(c.next(rhs)))<<8)
+	case rhs.text == "long":
+		c.instr(t, 0xF000)
+		addr := c.next(rhs)
+		c.emit(0, 0)
+		v := c.address(addr, c.here-2, fixLong, 0xFFFF)
+		c.rom[c.here-2-chip8.PROGRAM_OFFSET], c.rom[c.here-1-chip8.PROGRAM_OFFSET] = byte(v>>8), byte(v)
+	default:
+		c.addrInstr(t, 0xA000, rhs)
+	}
+}
+
+// condition is a condition of an if or while: a register compared with a
+// register or a number, or key or -key.
+type condition struct {
+	at  token
+	x   byte
+	op  string
+	rhs token
+}
+
+// negations holds the opposite of each comparison.
+var negations = map[string]string{
+	"==": "!=", "!=": "==", "<": ">=", ">=": "<", ">": "<=", "<=": ">", "key": "-key", "-key": "key",
+}
+
+func (c *compiler) condition(t token) condition {
+	x := c.next(t)
+	cond := condition{at: t, x: c.reg(x), op: c.next(x).text}
+	if _, ok := negations[cond.op]; !ok {
+		c.errorf(c.tokens[c.pos-1], "expected a comparison but found %s", cond.op)
+	}
+	if cond.op != "key" && cond.op != "-key" {
+		cond.rhs = c.next(c.tokens[c.pos-1])
+	}
+	return cond
+}
+
+// then compiles cond so that the instruction after it only runs if cond is
+// true. The comparisons other than == and != are worked out in vf.
+func (c *compiler) then(cond condition) {
+	x := uint16(cond.x)
+	t := cond.at
+	switch cond.op {
+	case "key":
+		c.instr(t, 0xE0A1|x<<8)
+		return
+	case "-key":
+		c.instr(t, 0xE09E|x<<8)
+		return
+	}
+	y, isReg := c.register(cond.rhs.text)
+	switch cond.op {
+	case "==", "!=":
+		switch {
+		case isReg && cond.op == "==":
+			c.instr(t, 0x9000|x<<8|uint16(y)<<4)
+		case isReg:
+			c.instr(t, 0x5000|x<<8|uint16(y)<<4)
+		case cond.op == "==":
+			c.instr(t, 0x4000|x<<8|uint16(c.number(cond.rhs, -128, 255)&0xFF))
+		default:
+			c.instr(t, 0x3000|x<<8|uint16(c.number(cond.rhs, -128, 255)&0xFF))
+		}
+		return
+	}
+
+	// vf is set to whether one side is at least the other by the flag from
+	// subtracting them.
+	var n uint16
+	if !isReg {
+		n = uint16(c.number(cond.rhs, 0, 255))
+	}
+	atLeast := func(xFirst bool) {
+		switch {
+		case isReg && xFirst:
+			c.instr(t, 0x8F00|x<<4)         // vf := vx
+			c.instr(t, 0x8F05|uint16(y)<<4) // vf -= vy
+		case isReg:
+			c.instr(t, 0x8F00|uint16(y)<<4) // vf := vy
+			c.instr(t, 0x8F05|x<<4)         // vf -= vx
+		case xFirst:
+			c.instr(t, 0x6F00|n)    // vf := n
+			c.instr(t, 0x8F07|x<<4) // vf =- vx
+		default:
+			c.instr(t, 0x6F00|n)    // vf := n
+			c.instr(t, 0x8F05|x<<4) // vf -= vx
+		}
+	}
+	switch cond.op {
+	case ">=":
+		atLeast(true)
+		c.instr(t, 0x3F00)
+	case "<":
+		atLeast(true)
+		c.instr(t, 0x4F00)
+	case "<=":
+		atLeast(false)
+		c.instr(t, 0x3F00)
+	case ">":
+		atLeast(false)
+		c.instr(t, 0x4F00)
+	}
+}
+
+func (c *compiler) ifStatement(t token) {
+	cond := c.condition(t)
+	switch then := c.next(c.tokens[c.pos-1]); then.text {
+	case "then":
+		c.then(cond)
+	case "begin":
+		cond.op = negations[cond.op]
+		c.then(cond)
+		c.blocks = append(c.blocks, &block{at: then, jump: c.here})
+		c.instr(t, 0x1000)
+	default:
+		c.errorf(then, "expected then or begin but found %s", then.text)
+	}
+}
+
+func (c *compiler) elseStatement(t token) {
+	b := c.closeBlock(t, "begin")
+	c.blocks = append(c.blocks, &block{at: t, jump: c.here})
+	c.instr(t, 0x1000)
+	c.patch(b.jump, c.here)
+}
+
+func (c *compiler) whileStatement(t token) {
+	var loop *block
+	for i := len(c.blocks) - 1; i >= 0; i-- {
+		if c.blocks[i].at.text == "loop" {
+			loop = c.blocks[i]
+			break
+		}
+	}
+	if loop == nil {
+		c.errorf(t, "while has to be inside a loop")
+	}
+	cond := c.condition(t)
+	cond.op = negations[cond.op]
+	c.then(cond)
+	loop.breaks = append(loop.breaks, c.here)
+	c.instr(t, 0x1000)
+}
+
+// closeBlock ends the innermost block, which has to have been opened by one
+// of kinds.
+func (c *compiler) closeBlock(t token, kinds ...string) *block {
+	if len(c.blocks) == 0 {
+		c.errorf(t, "%s without %s", t.text, kinds[0])
+	}
+	b := c.blocks[len(c.blocks)-1]
+	for _, k := range kinds {
+		if b.at.text == k {
+			c.blocks = c.blocks[:len(c.blocks)-1]
+			return b
+		}
+	}
+	c.errorf(t, "%s can't close the %s at line %d", t.text, b.at.text, b.at.line)
+	return nil
+}
+
+// register returns the number of the register named by text, either v0 to vf
+// or an alias.
+func (c *compiler) register(text string) (byte, bool) {
+	if x, ok := c.aliases[text]; ok {
+		return x, true
+	}
+	if len(text) != 2 || text[0] != 'v' && text[0] != 'V' {
+		return 0, false
+	}
+	x, err := strconv.ParseUint(text[1:], 16, 8)
+	return byte(x), err == nil
+}
+
+// reg returns the register named by t.
+func (c *compiler) reg(t token) byte {
+	x, ok := c.register(t.text)
+	if !ok {
+		c.errorf(t, "expected a register but found %s", t.text)
+	}
+	return x
+}
+
+// number returns the value of t, a number or constant, which has to be from
+// min to max.
+func (c *compiler) number(t token, min, max int) int {
+	v, ok := c.constant(t.text)
+	if !ok {
+		n, err := parseNumber(t.text)
+		if err != nil {
+			if _, isLabel := c.labels[t.text]; isLabel {
+				n = float64(c.labels[t.text])
+			} else {
+				c.errorf(t, "expected a number but found %s", t.text)
+			}
+		}
+		v = n
+	}
+	n := int(v)
+	if n < min || n > max {
+		c.errorf(t, "%d is out of range here, it has to be from %d to %d", n, min, max)
+	}
+	return n
+}
+
+// constant returns the value of the constant name.
+func (c *compiler) constant(name string) (float64, bool) {
+	v, ok := c.consts[name]
+	return v, ok
+}
+
+// parseNumber parses a decimal, hex (0x) or binary (0b) number, which can be
+// negative.
+func parseNumber(text string) (float64, error) {
+	neg := strings.HasPrefix(text, "-")
+	digits := strings.TrimPrefix(text, "-")
+	base := 10
+	switch lower := strings.ToLower(digits); {
+	case strings.HasPrefix(lower, "0x"):
+		base, digits = 16, digits[2:]
+	case strings.HasPrefix(lower, "0b"):
+		base, digits = 2, digits[2:]
+	}
+	if base == 10 && strings.ContainsAny(digits, ".eE") {
+		v, err := strconv.ParseFloat(digits, 64)
+		if neg {
+			v = -v
+		}
+		return v, err
+	}
+	n, err := strconv.ParseUint(digits, base, 32)
+	if err != nil {
+		return 0, err
+	}
+	if neg {
+		return -float64(n), nil
+	}
+	return float64(n), nil
+}
+
+func isNumber(text string) bool {
+	_, err := parseNumber(text)
+	return err == nil
+}
+
+// isIdent reports whether text can be a name.
+func isIdent(text string) bool {
+	for i, c := range text {
+		switch {
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return text != ""
+}
@@ -0,0 +1,299 @@
+package octo
+
+import (
+	"math"
+
+	"github.com/zabrahams/gochip8/chip8"
+)
+
+// directives holds the compiler for each directive.
+var directives map[string]func(c *compiler, t token)
+
+func init() {
+	directives = map[string]func(c *compiler, t token){
+		":const":      (*compiler).constDirective,
+		":alias":      (*compiler).aliasDirective,
+		":calc":       (*compiler).calcDirective,
+		":macro":      (*compiler).macroDirective,
+		":org":        (*compiler).orgDirective,
+		":byte":       (*compiler).byteDirective,
+		":pointer":    (*compiler).pointerDirective,
+		":call":       (*compiler).callDirective,
+		":unpack":     (*compiler).unpackDirective,
+		":breakpoint": (*compiler).breakpointDirective,
+	}
+}
+
+// :const name value
+func (c *compiler) constDirective(t token) {
+	name := c.next(t)
+	c.define(name)
+	value := c.next(name)
+	if v, ok := c.constant(value.text); ok {
+		c.consts[name.text] = v
+		return
+	}
+	v, err := parseNumber(value.text)
+	if err != nil {
+		c.errorf(value, "expected a number but found %s", value.text)
+	}
+	c.consts[name.text] = v
+}
+
+// :alias name register
+func (c *compiler) aliasDirective(t token) {
+	name := c.next(t)
+	if _, ok := c.aliases[name.text]; !ok {
+		c.define(name)
+	}
+	c.aliases[name.text] = c.reg(c.next(name))
+}
+
+// :calc name { expression }
+func (c *compiler) calcDirective(t token) {
+	name := c.next(t)
+	if _, ok := c.consts[name.text]; !ok {
+		c.define(name)
+	}
+	c.consts[name.text] = c.calc(name)
+}
+
+// :macro name args... { body }
+func (c *compiler) macroDirective(t token) {
+	name := c.next(t)
+	c.define(name)
+	m := &macro{}
+	for {
+		arg := c.next(name)
+		if arg.text == "{" {
+			break
+		}
+		m.args = append(m.args, arg.text)
+	}
+	depth := 1
+	for {
+		tok := c.next(name)
+		switch tok.text {
+		case "{":
+			depth++
+		case "}":
+			depth--
+		}
+		if depth == 0 {
+			break
+		}
+		m.body = append(m.body, tok)
+	}
+	c.macros[name.text] = m
+}
+
+// expand replaces the invocation of m at t, and its arguments, with its body.
+func (c *compiler) expand(t token, m *macro) {
+	c.expanded++
+	if c.expanded > MAX_EXPANSIONS {
+		c.errorf(t, "too many macros expanded, %s probably invokes itself", t.text)
+	}
+	args := map[string]token{}
+	for _, name := range m.args {
+		args[name] = c.next(t)
+	}
+	body := make([]token, len(m.body))
+	for i, tok := range m.body {
+		if arg, ok := args[tok.text]; ok {
+			tok = arg
+		}
+		body[i] = tok
+	}
+	// The body goes in place of tokens that have already been read, so that
+	// expanding doesn't copy the rest of the program each time. When there
+	// isn't room it's made for this expansion and as many again.
+	last := c.tokens[c.pos-1]
+	if len(body) >= c.pos {
+		rest := c.tokens[c.pos:]
+		room := len(body) + len(rest) + 1
+		c.tokens = append(make([]token, room, room+len(rest)), rest...)
+		c.pos = room
+	}
+	c.pos -= len(body)
+	copy(c.tokens[c.pos:], body)
+	// Errors about what comes next are still reported after the last token
+	// read.
+	c.tokens[c.pos-1] = last
+}
+
+// :org address
+func (c *compiler) orgDirective(t token) {
+	addr := c.next(t)
+	v := c.value(addr, chip8.PROGRAM_OFFSET, 0xFFFF)
+	c.here = v
+	c.mainJump = false
+}
+
+// :byte value or :byte { expression }
+func (c *compiler) byteDirective(t token) {
+	if c.peek() == "{" {
+		c.emit(byte(int(c.calc(t))))
+		return
+	}
+	c.emit(0)
+	c.rom[c.here-1-chip8.PROGRAM_OFFSET] = byte(c.address(c.next(t), c.here-1, fixByte, 0xFF))
+}
+
+// :pointer address
+func (c *compiler) pointerDirective(t token) {
+	c.emit(0, 0)
+	v := c.address(c.next(t), c.here-2, fixWord, 0xFFFF)
+	c.rom[c.here-2-chip8.PROGRAM_OFFSET], c.rom[c.here-1-chip8.PROGRAM_OFFSET] = byte(v>>8), byte(v)
+}
+
+// :call address
+func (c *compiler) callDirective(t token) {
+	c.addrInstr(t, 0x2000, c.next(t))
+}
+
+// :unpack nibble address loads v0 and v1 with the address, with nibble above
+// it in v0. :unpack long address loads the whole 16 bit address.
+func (c *compiler) unpackDirective(t token) {
+	nibble := c.next(t)
+	kind, n := fixUnpackLong, 0
+	if nibble.text != "long" {
+		kind, n = fixUnpack, c.number(nibble, 0, 15)
+	}
+	addr := c.next(nibble)
+	at := c.here
+	c.instr(t, 0x6000)
+	c.instr(t, 0x6100)
+	fixups := len(c.fixups)
+	v := c.address(addr, at, kind, 0xFFFF)
+	if len(c.fixups) > fixups {
+		c.fixups[fixups].nibble = n
+		return
+	}
+	c.fill(fixup{addr: at, kind: kind, name: addr, nibble: n}, v)
+}
+
+// :breakpoint name is for Octo's debugger, so it's skipped.
+func (c *compiler) breakpointDirective(t token) {
+	c.next(t)
+}
+
+// value returns the value of t, a number, constant or defined label, which
+// has to be from min to max.
+func (c *compiler) value(t token, min, max int) int {
+	if v, ok := c.labels[t.text]; ok {
+		if v < min || v > max {
+			c.errorf(t, "%d is out of range here, it has to be from %d to %d", v, min, max)
+		}
+		return v
+	}
+	return c.number(t, min, max)
+}
+
+// calc reads and works out the expression in braces after t. Like Octo, it
+// has no precedence: operators are applied right to left, so 2 * 3 + 1 is 8,
+// and parentheses group.
+func (c *compiler) calc(t token) float64 {
+	open := c.expect(t, "{")
+	v := c.expr(open)
+	c.expect(c.tokens[c.pos-1], "}")
+	return v
+}
+
+// binaryOps are the operators that can be used between two values in :calc.
+var binaryOps = map[string]func(a, b float64) float64{
+	"+":   func(a, b float64) float64 { return a + b },
+	"-":   func(a, b float64) float64 { return a - b },
+	"*":   func(a, b float64) float64 { return a * b },
+	"/":   func(a, b float64) float64 { return a / b },
+	"%":   func(a, b float64) float64 { return float64(int(a) % int(b)) },
+	"&":   func(a, b float64) float64 { return float64(int(a) & int(b)) },
+	"|":   func(a, b float64) float64 { return float64(int(a) | int(b)) },
+	"^":   func(a, b float64) float64 { return float64(int(a) ^ int(b)) },
+	"<<":  func(a, b float64) float64 { return float64(int(a) << uint(b)) },
+	">>":  func(a, b float64) float64 { return float64(int(a) >> uint(b)) },
+	"pow": math.Pow,
+	"min": math.Min,
+	"max": math.Max,
+	"<":   func(a, b float64) float64 { return boolValue(a < b) },
+	">":   func(a, b float64) float64 { return boolValue(a > b) },
+	"<=":  func(a, b float64) float64 { return boolValue(a <= b) },
+	">=":  func(a, b float64) float64 { return boolValue(a >= b) },
+	"==":  func(a, b float64) float64 { return boolValue(a == b) },
+	"!=":  func(a, b float64) float64 { return boolValue(a != b) },
+}
+
+// unaryOps are the operators and functions that can be applied to a value in
+// :calc.
+var unaryOps = map[string]func(a float64) float64{
+	"-":     func(a float64) float64 { return -a },
+	"~":     func(a float64) float64 { return float64(^int(a)) },
+	"!":     func(a float64) float64 { return boolValue(a == 0) },
+	"sin":   math.Sin,
+	"cos":   math.Cos,
+	"tan":   math.Tan,
+	"exp":   math.Exp,
+	"log":   math.Log,
+	"abs":   math.Abs,
+	"sqrt":  math.Sqrt,
+	"sign":  func(a float64) float64 { return boolValue(a > 0) - boolValue(a < 0) },
+	"ceil":  math.Ceil,
+	"floor": math.Floor,
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// expr reads an expression: a term, optionally followed by an operator and
+// another expression.
+func (c *compiler) expr(after token) float64 {
+	a := c.term(after)
+	op, ok := binaryOps[c.peek()]
+	if !ok {
+		return a
+	}
+	opTok := c.next(after)
+	return op(a, c.expr(opTok))
+}
+
+// term reads a value, a parenthesised expression, or a unary operator and the
+// term it applies to.
+func (c *compiler) term(after token) float64 {
+	t := c.next(after)
+	if op, ok := unaryOps[t.text]; ok {
+		return op(c.term(t))
+	}
+	switch t.text {
+	case "(":
+		v := c.expr(t)
+		c.expect(c.tokens[c.pos-1], ")")
+		return v
+	case "@":
+		// The byte that's been compiled at an address.
+		addr := int(c.term(t)) - chip8.PROGRAM_OFFSET
+		if addr < 0 || addr >= c.size {
+			c.errorf(t, "there's nothing compiled at 0x%X", addr+chip8.PROGRAM_OFFSET)
+		}
+		return float64(c.rom[addr])
+	case "HERE":
+		return float64(c.here)
+	case "PI":
+		return math.Pi
+	case "E":
+		return math.E
+	}
+	if v, ok := c.constant(t.text); ok {
+		return v
+	}
+	if v, ok := c.labels[t.text]; ok {
+		return float64(v)
+	}
+	v, err := parseNumber(t.text)
+	if err != nil {
+		c.errorf(t, "expected a value but found %s", t.text)
+	}
+	return v
+}
@@ -0,0 +1,106 @@
+// Package octo compiles Octo, the high level assembly language most modern
+// Chip8 programs are written in, into a rom.
+//
+// It understands the instructions and the structured statements (if ... then,
+// if ... begin ... else ... end, loop ... while ... again), the directives
+// :const, :alias, :calc, :macro, :org, :byte, :pointer, :call and :unpack, the
+// XO-CHIP extensions, and data written as bare numbers, which is how sprites
+// are usually drawn:
+//
+//	: ship
+//		0b00111100
+//		0b01111110
+//
+// As in Octo, execution starts at the label main. If main isn't the first
+// thing in the program, a jump to it is put at the start.
+package octo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/zabrahams/gochip8/symbols"
+)
+
+// Error is a mistake in the source, found at a line and column of a file.
+type Error struct {
+	File string
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Col, e.Msg)
+}
+
+// Program is a compiled program.
+//
+// Bytes: the program, to be loaded at chip8.PROGRAM_OFFSET
+//
+// Symbols: the labels, and the source line each instruction was compiled from
+type Program struct {
+	Bytes   []byte
+	Symbols *symbols.Table
+}
+
+// CompileFile compiles the source file filename.
+func CompileFile(filename string) (*Program, error) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Compile(filename, src)
+}
+
+// Compile compiles src, which was read from filename. The first mistake found
+// is returned as an *Error.
+func Compile(filename string, src []byte) (prog *Program, err error) {
+	c := newCompiler(filename, tokenize(string(src)))
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			prog, err = nil, e
+		}
+	}()
+	c.compile()
+	return &Program{Bytes: c.bytes(), Symbols: c.symbols}, nil
+}
+
+// token is a word of the source, with where it was found.
+type token struct {
+	text string
+	line int
+	col  int
+}
+
+// tokenize splits src into words. Octo only separates words with space, and
+// comments start with # and run to the end of the line.
+func tokenize(src string) []token {
+	var toks []token
+	for n, text := range strings.Split(src, "\n") {
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		for i := 0; i < len(text); {
+			if isSpace(text[i]) {
+				i++
+				continue
+			}
+			start := i
+			for i < len(text) && !isSpace(text[i]) {
+				i++
+			}
+			toks = append(toks, token{text: text[start:i], line: n + 1, col: start + 1})
+		}
+	}
+	return toks
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r'
+}
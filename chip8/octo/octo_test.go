@@ -0,0 +1,101 @@
+package octo
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/headless"
+)
+
+// run compiles src and runs it until it halts, returning its registers.
+func run(t *testing.T, src string) chip8.Registers {
+	t.Helper()
+	prog, err := Compile("test.8o", []byte(src))
+	if err != nil {
+		t.Fatalf("%v\n%s", err, src)
+	}
+	res, err := headless.Run(prog.Bytes, headless.Config{Frames: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Reason != headless.StopHalt {
+		t.Fatalf("the program stopped with %s %s, not a halt\n%s", res.Reason, res.Error, src)
+	}
+	return res.Registers
+}
+
+func TestComparisons(t *testing.T) {
+	ops := map[string]func(a, b int) bool{
+		"==": func(a, b int) bool { return a == b },
+		"!=": func(a, b int) bool { return a != b },
+		">=": func(a, b int) bool { return a >= b },
+		"<=": func(a, b int) bool { return a <= b },
+		">":  func(a, b int) bool { return a > b },
+		"<":  func(a, b int) bool { return a < b },
+	}
+	pairs := [][2]int{{5, 5}, {4, 5}, {5, 4}, {0, 255}, {255, 0}, {0, 0}, {255, 255}}
+	for op, want := range ops {
+		for _, p := range pairs {
+			// v2 is the comparison with a register and v3 with a number,
+			// both written as if ... then and as if ... begin ... else ... end.
+			src := fmt.Sprintf(`: main
+	v0 := %[2]d
+	v1 := %[3]d
+	v2 := 0
+	v3 := 0
+	v4 := 0
+	v5 := 0
+	if v0 %[1]s v1 then v2 := 1
+	if v0 %[1]s %[3]d then v3 := 1
+	if v0 %[1]s v1 begin v4 := 1 else v4 := 2 end
+	if v0 %[1]s %[3]d begin v5 := 1 else v5 := 2 end
+: halt
+	jump halt
+`, op, p[0], p[1])
+			v := run(t, src).V
+			wantThen, wantElse := byte(0), byte(2)
+			if want(p[0], p[1]) {
+				wantThen, wantElse = 1, 1
+			}
+			if v[2] != wantThen || v[3] != wantThen || v[4] != wantElse || v[5] != wantElse {
+				t.Errorf("%d %s %d: got v2=%d v3=%d v4=%d v5=%d, want %d %d %d %d",
+					p[0], op, p[1], v[2], v[3], v[4], v[5], wantThen, wantThen, wantElse, wantElse)
+			}
+			if v[0] != byte(p[0]) || v[1] != byte(p[1]) {
+				t.Errorf("%d %s %d: the comparison changed v0=%d v1=%d", p[0], op, p[1], v[0], v[1])
+			}
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"undefined label", ": main\n  jump nowhere\n", "test.8o:2:8: nowhere isn't defined"},
+		{"no main", ": start\n  return\n", "test.8o:1:1: the program has no main label to start at"},
+		{"duplicate label", ": main\n: main\n", "test.8o:2:3: main is already defined"},
+		{"recursive macro", ":macro grow {\n  grow\n}\n: main\n  grow\n", "test.8o:2:3: too many macros expanded, grow probably invokes itself"},
+		{"out of range byte", ": main\n  v0 := 256\n", "test.8o:2:9: 256 is out of range here, it has to be from -128 to 255"},
+		{"out of range nibble", ": main\n  sprite v0 v1 16\n", "test.8o:2:16: 16 is out of range here, it has to be from 0 to 15"},
+		{"out of range address", ": main\n  jump 0x1000\n", "test.8o:2:8: 4096 is out of range here, it has to be from 0 to 4095"},
+		{"out of range macro argument", ":macro setv X {\n  v0 := X\n}\n: main\n  setv 300\n", "test.8o:5:8: 300 is out of range here, it has to be from -128 to 255"},
+		{"org below the program", ":org 0x100\n: main\n", "test.8o:1:6: 256 is out of range here, it has to be from 512 to 65535"},
+		{"org above memory", ": main\n  return\n:org 0x10000\n", "test.8o:3:6: 65536 is out of range here, it has to be from 512 to 65535"},
+		{"org without an address", ": main\n  return\n:org", "test.8o:3:1: expected something after :org"},
+	} {
+		_, err := Compile("test.8o", []byte(test.src))
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("%s: got error %v, want an *Error", test.name, err)
+			continue
+		}
+		if got := e.Error(); got != test.want {
+			t.Errorf("%s: got error %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package chip8
+
+import "fmt"
+
+// OriginKind is what last wrote a byte of memory.
+type OriginKind int
+
+const (
+	// OriginNone means the byte hasn't been written since the Chip8 started,
+	// e.g. the font or unused memory.
+	OriginNone OriginKind = iota
+	// OriginROM means the byte was loaded with the program.
+	OriginROM
+	// OriginInstruction means an instruction wrote the byte.
+	OriginInstruction
+	// OriginExternal means the byte was written from outside the program,
+	// e.g. by a debugger or by loading a save state.
+	OriginExternal
+)
+
+// Origin is where a byte of memory came from.
+//
+// Kind: what wrote it
+//
+// PC, Cycle: for OriginInstruction, the address of the instruction that wrote
+// it and the cycle it was executed in
+type Origin struct {
+	Kind  OriginKind
+	PC    uint16
+	Cycle uint64
+}
+
+func (o Origin) String() string {
+	switch o.Kind {
+	case OriginROM:
+		return "loaded with the program"
+	case OriginInstruction:
+		return fmt.Sprintf("written by the instruction at 0x%03X in cycle %d", o.PC, o.Cycle)
+	case OriginExternal:
+		return "written from outside the program"
+	default:
+		return "never written"
+	}
+}
+
+// SelfModification is a run of instructions executed from memory that was
+// written at runtime, i.e. self-modifying code.
+//
+// PC: the address of the instruction
+//
+// Origin: who wrote it
+//
+// Cycle, Count: the cycle it was first executed in since it was written, and
+// how many times it's been executed since
+type SelfModification struct {
+	PC     uint16
+	Origin Origin
+	Cycle  uint64
+	Count  int
+}
+
+// Provenance is a shadow of memory that records where every byte came from,
+// so that it's possible to ask who wrote a byte. It also notices when the
+// Chip8 executes bytes that were written by an instruction, which is
+// self-modifying code.
+type Provenance struct {
+	origins []Origin
+	// modified maps the address of each self-modified instruction executed to
+	// its index in executed.
+	modified map[uint16]int
+	executed []SelfModification
+}
+
+// NewProvenance returns a Provenance for a memory of size bytes, none of which
+// have been written.
+func NewProvenance(size int) *Provenance {
+	return &Provenance{origins: make([]Origin, size), modified: map[uint16]int{}}
+}
+
+// Origin returns where the byte at addr came from.
+func (p *Provenance) Origin(addr uint16) Origin {
+	if int(addr) >= len(p.origins) {
+		return Origin{}
+	}
+	return p.origins[addr]
+}
+
+// SelfModifications returns the self-modified instructions that have been
+// executed, in the order they were first executed.
+func (p *Provenance) SelfModifications() []SelfModification {
+	return append([]SelfModification{}, p.executed...)
+}
+
+// SelfModified reports whether the n bytes of code at addr were written by an
+// instruction, and if so returns the origin of the most recently written one.
+func (p *Provenance) SelfModified(addr uint16, n int) (Origin, bool) {
+	var latest Origin
+	for i := int(addr); i < int(addr)+n && i < len(p.origins); i++ {
+		if o := p.origins[i]; o.Kind == OriginInstruction && (latest.Kind != OriginInstruction || o.Cycle >= latest.Cycle) {
+			latest = o
+		}
+	}
+	return latest, latest.Kind == OriginInstruction
+}
+
+// set records that the n bytes at addr came from origin.
+func (p *Provenance) set(addr uint16, n int, origin Origin) {
+	for i := int(addr); i < int(addr)+n && i < len(p.origins); i++ {
+		p.origins[i] = origin
+	}
+}
+
+// fetch notes that the n bytes of the instruction at pc are being executed in
+// cycle, and records it if they were written by an instruction.
+func (p *Provenance) fetch(pc uint16, n int, cycle uint64) {
+	origin, ok := p.SelfModified(pc, n)
+	if !ok {
+		return
+	}
+	if i, seen := p.modified[pc]; seen && p.executed[i].Origin == origin {
+		p.executed[i].Count++
+		return
+	}
+	p.modified[pc] = len(p.executed)
+	p.executed = append(p.executed, SelfModification{PC: pc, Origin: origin, Cycle: cycle, Count: 1})
+}
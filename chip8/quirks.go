@@ -0,0 +1,88 @@
+package chip8
+
+import (
+	"sort"
+	"strings"
+)
+
+// Quirks selects between the behaviours that different CHIP-8 implementations
+// settled on for ambiguous instructions. The zero value is the modern
+// behaviour this interpreter has always had.
+//
+// ShiftUsesVy: 8xy6 and 8xyE copy Vy into Vx before shifting, rather than
+// shifting Vx in place.
+//
+// LoadStoreIncrementsI: Fx55 and Fx65 leave I pointing just past the last
+// register copied, rather than leaving it untouched.
+//
+// JumpUsesVx: Bxnn jumps to xnn + Vx, rather than Bnnn jumping to nnn + V0.
+//
+// LogicResetsVF: 8xy1, 8xy2 and 8xy3 set VF to 0.
+//
+// ClipSprites: Dxyn clips sprites at the edge of the screen rather than
+// wrapping them around to the other side.
+//
+// ExtendedMemory: the Chip8 gets the 64kb XO-CHIP address space rather than
+// 4kb of memory.
+//
+// XOChipInstructions: the XO-CHIP instructions F000 nnnn, 5xy2, 5xy3, Fn01,
+// F002, 00Dn and Fx3A are run, rather than faulting as unknown opcodes.
+type Quirks struct {
+	ShiftUsesVy          bool
+	LoadStoreIncrementsI bool
+	JumpUsesVx           bool
+	LogicResetsVF        bool
+	ClipSprites          bool
+	ExtendedMemory       bool
+	XOChipInstructions   bool
+}
+
+// The named quirks profiles.
+var (
+	QuirksVIP = Quirks{
+		ShiftUsesVy:          true,
+		LoadStoreIncrementsI: true,
+		LogicResetsVF:        true,
+		ClipSprites:          true,
+	}
+	QuirksCHIP48 = Quirks{
+		LoadStoreIncrementsI: true,
+		JumpUsesVx:           true,
+		ClipSprites:          true,
+	}
+	QuirksSCHIP = Quirks{
+		JumpUsesVx:  true,
+		ClipSprites: true,
+	}
+	QuirksXOCHIP = Quirks{
+		ShiftUsesVy:          true,
+		LoadStoreIncrementsI: true,
+		ExtendedMemory:       true,
+		XOChipInstructions:   true,
+	}
+	QuirksModern = Quirks{}
+)
+
+var quirksProfiles = map[string]Quirks{
+	"vip":    QuirksVIP,
+	"chip48": QuirksCHIP48,
+	"schip":  QuirksSCHIP,
+	"xochip": QuirksXOCHIP,
+	"modern": QuirksModern,
+}
+
+// QuirksProfile returns the quirks profile with the given name.
+func QuirksProfile(name string) (Quirks, bool) {
+	q, ok := quirksProfiles[strings.ToLower(name)]
+	return q, ok
+}
+
+// QuirksProfileNames returns the names of every quirks profile in sorted order.
+func QuirksProfileNames() []string {
+	names := make([]string, 0, len(quirksProfiles))
+	for name := range quirksProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,55 @@
+package chip8
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+)
+
+// RandomSource provides the random bytes used by Cxkk.
+type RandomSource interface {
+	Byte() (byte, error)
+}
+
+// CryptoSource is a RandomSource that reads from crypto/rand. It can't be
+// reproduced, so it's only suitable when nothing needs to be replayed.
+type CryptoSource struct{}
+
+func (CryptoSource) Byte() (byte, error) {
+	b := make([]byte, 1, 1)
+	_, err := rand.Read(b)
+	return b[0], err
+}
+
+// SeededSource is a RandomSource that produces the same bytes every time it's
+// created with the same seed. Its whole state is the seed and a counter, so
+// it can be saved and restored exactly.
+type SeededSource struct {
+	seed    uint64
+	counter uint64
+}
+
+func NewSeededSource(seed uint64) *SeededSource {
+	return &SeededSource{seed: seed}
+}
+
+// NewRandomSeed returns a seed for a SeededSource taken from crypto/rand.
+func NewRandomSeed() uint64 {
+	var b [8]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// Seed returns the seed that the source was created with.
+func (s *SeededSource) Seed() uint64 {
+	return s.seed
+}
+
+// Byte returns the next byte of the splitmix64 sequence for the seed.
+func (s *SeededSource) Byte() (byte, error) {
+	s.counter++
+	z := s.seed + s.counter*0x9E3779B97F4A7C15
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	z = z ^ (z >> 31)
+	return byte(z >> 56), nil
+}
@@ -0,0 +1,97 @@
+package chip8
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const RECORDING_VERSION = 1
+
+var recordingMagic = [4]byte{'G', 'C', '8', 'I'}
+
+// ErrBadRecording is returned by ReadInputRecording when it's given something
+// that isn't an input recording it understands.
+var ErrBadRecording = errors.New("not a valid input recording")
+
+// InputRecording holds the state of the keyboard at the start of every frame,
+// along with the seed of the random source the run used. Since execution is
+// deterministic, that's all that's needed to replay the run exactly.
+//
+// A recording made with NewInputRecording records the keyboard as frames are
+// run. One read with ReadInputRecording replays it into the keyboard instead.
+type InputRecording struct {
+	Seed      uint64
+	Frames    []uint16
+	replaying bool
+	pos       int
+}
+
+type recordingHeader struct {
+	Magic   [4]byte
+	Version uint16
+	Seed    uint64
+	Frames  uint32
+}
+
+func NewInputRecording(seed uint64) *InputRecording {
+	return &InputRecording{Seed: seed}
+}
+
+// ReadInputRecording reads a recording written by Write, ready to replay.
+func ReadInputRecording(r io.Reader) (*InputRecording, error) {
+	var header recordingHeader
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, err
+	}
+	if header.Magic != recordingMagic {
+		return nil, ErrBadRecording
+	}
+	if header.Version != RECORDING_VERSION {
+		return nil, fmt.Errorf("%w: unknown version %d", ErrBadRecording, header.Version)
+	}
+	frames := make([]uint16, header.Frames)
+	if err := binary.Read(r, binary.BigEndian, frames); err != nil {
+		return nil, err
+	}
+	return &InputRecording{Seed: header.Seed, Frames: frames, replaying: true}, nil
+}
+
+// Write writes the recording to w.
+func (rec *InputRecording) Write(w io.Writer) error {
+	header := recordingHeader{
+		Magic:   recordingMagic,
+		Version: RECORDING_VERSION,
+		Seed:    rec.Seed,
+		Frames:  uint32(len(rec.Frames)),
+	}
+	if err := binary.Write(w, binary.BigEndian, &header); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, rec.Frames)
+}
+
+// Replaying reports whether the recording is being replayed rather than
+// recorded.
+func (rec *InputRecording) Replaying() bool {
+	return rec.replaying
+}
+
+// Done reports whether every frame of a replay has been played.
+func (rec *InputRecording) Done() bool {
+	return rec.replaying && rec.pos >= len(rec.Frames)
+}
+
+// frame is called at the start of every frame. It either records the state
+// of the keyboard, or replays the recorded state into it.
+func (rec *InputRecording) frame(k *Keyboard) {
+	if !rec.replaying {
+		rec.Frames = append(rec.Frames, k.current())
+		return
+	}
+	if rec.pos < len(rec.Frames) {
+		k.Update(rec.Frames[rec.pos])
+		rec.pos++
+	}
+}
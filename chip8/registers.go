@@ -0,0 +1,43 @@
+package chip8
+
+// Registers is a copy of the CPU registers, timers and call stack of a Chip8.
+type Registers struct {
+	V          [16]byte `json:"v"`
+	I          uint16   `json:"i"`
+	PC         uint16   `json:"pc"`
+	Stack      []uint16 `json:"stack"`
+	DelayTimer byte     `json:"dt"`
+	SoundTimer byte     `json:"st"`
+}
+
+// Registers returns a copy of the registers. The Chip8 must not be running.
+func (c8 *Chip8) Registers() Registers {
+	r := Registers{
+		I:          c8.regI,
+		PC:         c8.programPtr,
+		Stack:      append([]uint16{}, c8.callStack...),
+		DelayTimer: c8.delayTimer.Read(),
+		SoundTimer: c8.beepTimer.Read(),
+	}
+	for i := range r.V {
+		r.V[i] = c8.registers[byte(i)]
+	}
+	return r
+}
+
+// SetRegisters sets the registers, timers and call stack from r. The Chip8 must
+// not be running.
+func (c8 *Chip8) SetRegisters(r Registers) error {
+	if len(r.Stack) > STACK_SIZE {
+		return ErrStackOverflow
+	}
+	c8.regI = r.I
+	c8.programPtr = r.PC
+	c8.callStack = append([]uint16{}, r.Stack...)
+	c8.delayTimer.Set(r.DelayTimer)
+	c8.beepTimer.Set(r.SoundTimer)
+	for i, v := range r.V {
+		c8.registers[byte(i)] = v
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package chip8
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// REWIND_FRAMES is the default number of frames of history kept for rewinding,
+// which is 30 seconds at 60 frames a second.
+const REWIND_FRAMES = 60 * 30
+
+// ErrNoHistory is returned by StepBack when there's nothing left to rewind to.
+var ErrNoHistory = errors.New("no more history to rewind")
+
+// Rewind is a bounded history of save states that can be stepped back through.
+//
+// Only the most recent snapshot is kept in full. Every older snapshot is kept
+// as the difference between it and the one after it, xor'd and run length
+// encoded, which is tiny since very little of the machine changes in a frame.
+// Once the history is full the oldest snapshots are forgotten.
+//
+// Each snapshot is kept with the frame and cycle it was taken at, so that
+// stepping back always goes to a snapshot from before where the Chip8 is, even
+// if it's been moved since the last one was taken.
+type Rewind struct {
+	capacity int
+	head     []byte
+	headAt   snapshotAt
+	deltas   [][]byte
+	at       []snapshotAt
+	start    int
+}
+
+// snapshotAt is how far a Chip8 had run when a snapshot was taken.
+type snapshotAt struct {
+	frames, cycles uint64
+}
+
+// before reports whether a was taken before b.
+func (a snapshotAt) before(b snapshotAt) bool {
+	return a.frames < b.frames || a.frames == b.frames && a.cycles < b.cycles
+}
+
+func NewRewind(capacity int) *Rewind {
+	return &Rewind{capacity: capacity}
+}
+
+// Len returns the number of snapshots that can be stepped back through.
+func (r *Rewind) Len() int {
+	return len(r.deltas) - r.start
+}
+
+// Record takes a snapshot of c8 and adds it to the history.
+func (r *Rewind) Record(c8 *Chip8) error {
+	var buf bytes.Buffer
+	if err := c8.SaveState(&buf); err != nil {
+		return err
+	}
+	snapshot := buf.Bytes()
+
+	if r.head != nil {
+		r.deltas = append(r.deltas, encodeDelta(snapshot, r.head))
+		r.at = append(r.at, r.headAt)
+		if r.Len() > r.capacity {
+			r.deltas[r.start] = nil
+			r.start++
+		}
+		// Reclaim the forgotten part of the slices once they're as big as
+		// the history itself.
+		if r.start >= r.capacity {
+			r.deltas = append([][]byte{}, r.deltas[r.start:]...)
+			r.at = append([]snapshotAt{}, r.at[r.start:]...)
+			r.start = 0
+		}
+	}
+	r.head = snapshot
+	r.headAt = snapshotAt{c8.frames, c8.cycles}
+	return nil
+}
+
+// StepBack restores c8 to the most recent snapshot taken before where it is
+// now, and forgets the snapshots after it. Straight after a frame is recorded
+// that's the frame before.
+func (r *Rewind) StepBack(c8 *Chip8) error {
+	if r.head == nil {
+		return ErrNoHistory
+	}
+	now := snapshotAt{c8.frames, c8.cycles}
+	for !r.headAt.before(now) {
+		if r.Len() == 0 {
+			return ErrNoHistory
+		}
+		last := len(r.deltas) - 1
+		r.head = applyDelta(r.head, r.deltas[last])
+		r.headAt = r.at[last]
+		r.deltas[last] = nil
+		r.deltas, r.at = r.deltas[:last], r.at[:last]
+	}
+	return c8.LoadState(bytes.NewReader(r.head))
+}
+
+// encodeDelta returns the difference that turns from into to. It's the length
+// of to, followed by the xor of the two as alternating runs of matching bytes
+// (stored as just their length) and differing bytes (stored as their length
+// and then the xor'd bytes).
+func encodeDelta(from, to []byte) []byte {
+	var out bytes.Buffer
+	var scratch [binary.MaxVarintLen64]byte
+	writeUvarint := func(v int) {
+		n := binary.PutUvarint(scratch[:], uint64(v))
+		out.Write(scratch[:n])
+	}
+	at := func(b []byte, i int) byte {
+		if i < len(b) {
+			return b[i]
+		}
+		return 0
+	}
+
+	writeUvarint(len(to))
+	for i := 0; i < len(to); {
+		same := i
+		for same < len(to) && at(from, same) == to[same] {
+			same++
+		}
+		diff := same
+		for diff < len(to) && at(from, diff) != to[diff] {
+			diff++
+		}
+		writeUvarint(same - i)
+		writeUvarint(diff - same)
+		for j := same; j < diff; j++ {
+			out.WriteByte(at(from, j) ^ to[j])
+		}
+		i = diff
+	}
+	return out.Bytes()
+}
+
+// applyDelta returns the result of applying a delta from encodeDelta to from.
+func applyDelta(from, delta []byte) []byte {
+	r := bytes.NewReader(delta)
+	size, _ := binary.ReadUvarint(r)
+	to := make([]byte, size)
+	copy(to, from)
+	for i := 0; i < len(to); {
+		same, _ := binary.ReadUvarint(r)
+		diff, _ := binary.ReadUvarint(r)
+		if same == 0 && diff == 0 {
+			break
+		}
+		i += int(same)
+		for j := 0; j < int(diff) && i < len(to); j++ {
+			b, _ := r.ReadByte()
+			to[i] ^= b
+			i++
+		}
+	}
+	return to
+}
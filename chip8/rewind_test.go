@@ -0,0 +1,108 @@
+package chip8
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestDeltaRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := func(n int) []byte {
+		b := make([]byte, n)
+		r.Read(b)
+		return b
+	}
+	// changed returns a copy of from with the bytes from start to end
+	// changed.
+	changed := func(from []byte, start, end int) []byte {
+		to := append([]byte{}, from...)
+		for i := start; i < end; i++ {
+			to[i] = ^to[i]
+		}
+		return to
+	}
+
+	state := random(70000)
+	for _, test := range []struct {
+		name     string
+		from, to []byte
+	}{
+		{"unchanged", state, state},
+		{"empty", nil, nil},
+		{"one byte", state, changed(state, 500, 501)},
+		// Runs of 128 and more take more than one byte to store the length
+		// of, and 16384 and more more than two.
+		{"runs longer than a byte", state, changed(state, 127, 255)},
+		{"runs longer than two bytes", state, changed(state, 20000, 40000)},
+		{"changed at the ends", state, changed(changed(state, 0, 3), 69990, 70000)},
+		{"all changed", state, changed(state, 0, len(state))},
+		{"grown", state[:1000], state},
+		{"shrunk", state, state[:1000]},
+		{"from nothing", nil, state[:300]},
+	} {
+		delta := encodeDelta(test.from, test.to)
+		if got := applyDelta(test.from, delta); !bytes.Equal(got, test.to) {
+			t.Errorf("%s: applying the delta gave %d bytes that differ from the %d wanted", test.name, len(got), len(test.to))
+		}
+		if bytes.Equal(test.from, test.to) && len(delta) > 8 {
+			t.Errorf("%s: the delta of an unchanged state is %d bytes", test.name, len(delta))
+		}
+	}
+}
+
+func TestRewind(t *testing.T) {
+	c8 := NewChip8(nullBeeper{}, QuirksModern, NewSeededSource(42))
+	if err := c8.LoadBytes(testProgram); err != nil {
+		t.Fatal(err)
+	}
+	c8.History = NewRewind(5)
+	if err := c8.History.StepBack(c8); !errors.Is(err, ErrNoHistory) {
+		t.Errorf("stepping back with no history gave %v, want ErrNoHistory", err)
+	}
+	var screens []string
+	var regs []Registers
+	for i := 0; i < 8; i++ {
+		if err := c8.RunFrame(c8.CyclesPerFrame); err != nil {
+			t.Fatal(err)
+		}
+		screens = append(screens, c8.FrameBuffer.String())
+		regs = append(regs, c8.Registers())
+	}
+	if c8.History.Len() != 5 {
+		t.Errorf("got %d snapshots to step back through, want 5", c8.History.Len())
+	}
+
+	// Stepping back from the end of a frame goes to the end of the one
+	// before.
+	for frame := 6; frame >= 2; frame-- {
+		if err := c8.History.StepBack(c8); err != nil {
+			t.Fatalf("stepping back to frame %d: %v", frame, err)
+		}
+		if c8.Frames() != uint64(frame+1) {
+			t.Fatalf("stepped back to frame %d, want %d", c8.Frames()-1, frame)
+		}
+		if c8.FrameBuffer.String() != screens[frame] || !reflect.DeepEqual(c8.Registers(), regs[frame]) {
+			t.Errorf("frame %d wasn't restored", frame)
+		}
+	}
+	if err := c8.History.StepBack(c8); !errors.Is(err, ErrNoHistory) {
+		t.Errorf("stepping back past the oldest frame gave %v, want ErrNoHistory", err)
+	}
+
+	// Stepping back part way through a frame goes back to the end of the
+	// last one.
+	for i := 0; i < 3; i++ {
+		if err := c8.ExecInstr(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c8.History.StepBack(c8); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c8.Registers(), regs[2]) || c8.Frames() != 3 {
+		t.Errorf("stepping back part way through frame 3 didn't go back to the end of frame 2")
+	}
+}
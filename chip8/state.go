@@ -0,0 +1,181 @@
+package chip8
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// STATE_VERSION is the version of the save state format written by SaveState.
+// LoadState can read every version up to and including it.
+const STATE_VERSION = 2
+
+var stateMagic = [4]byte{'G', 'C', '8', 'S'}
+
+// ErrBadState is returned by LoadState when it's given something that isn't a
+// save state it understands.
+var ErrBadState = errors.New("not a valid save state")
+
+type stateHeader struct {
+	Magic   [4]byte
+	Version uint16
+}
+
+// stateV1 is the fixed size part of a save state. In version 1 it's followed by
+// MemorySize bytes of memory. In version 2 it's followed by a stateV2 and then
+// the memory.
+type stateV1 struct {
+	Registers    [16]byte
+	RegI         uint16
+	ProgramPtr   uint16
+	StackDepth   uint8
+	CallStack    [STACK_SIZE]uint16
+	DelayTimer   byte
+	BeepTimer    byte
+	Width        uint8
+	Height       uint8
+	Selected     byte
+	Planes       [PLANES][HIRES_HEIGHT * rowWords]uint64
+	Keys         uint16
+	RPLFlags     [16]byte
+	AudioPattern [16]byte
+	Pitch        byte
+	MemorySize   uint32
+}
+
+// stateV2 records the random source, if it's a SeededSource, so that the
+// numbers drawn after loading a state are the same as they were after saving
+// it, and the instructions and frames run so far, so that the trace, profiler
+// and coverage count on from where they were.
+type stateV2 struct {
+	Seeded  uint8
+	Seed    uint64
+	Counter uint64
+	Cycles  uint64
+	Frames  uint64
+}
+
+// SaveState writes the full state of the machine to w. The Chip8 must not be
+// running while it's saved.
+func (c8 *Chip8) SaveState(w io.Writer) error {
+	header := stateHeader{Magic: stateMagic, Version: STATE_VERSION}
+	if err := binary.Write(w, binary.BigEndian, &header); err != nil {
+		return err
+	}
+
+	st := stateV1{
+		RegI:         c8.regI,
+		ProgramPtr:   c8.programPtr,
+		StackDepth:   uint8(len(c8.callStack)),
+		DelayTimer:   c8.delayTimer.Read(),
+		BeepTimer:    c8.beepTimer.Read(),
+		Width:        uint8(c8.FrameBuffer.Width),
+		Height:       uint8(c8.FrameBuffer.Height),
+		Selected:     c8.FrameBuffer.selected,
+		Keys:         c8.Keyboard.current(),
+		RPLFlags:     c8.rplFlags,
+		AudioPattern: c8.audioPattern,
+		Pitch:        c8.pitch,
+		MemorySize:   uint32(len(c8.memory)),
+	}
+	for i := range st.Registers {
+		st.Registers[i] = c8.registers[byte(i)]
+	}
+	copy(st.CallStack[:], c8.callStack)
+	for p, plane := range c8.FrameBuffer.Planes {
+		copy(st.Planes[p][:], plane)
+	}
+
+	v2 := stateV2{Cycles: c8.cycles, Frames: c8.frames}
+	if seeded, ok := c8.random.(*SeededSource); ok {
+		v2.Seeded, v2.Seed, v2.Counter = 1, seeded.seed, seeded.counter
+	}
+
+	if err := binary.Write(w, binary.BigEndian, &st); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, &v2); err != nil {
+		return err
+	}
+	_, err := w.Write(c8.memory)
+	return err
+}
+
+// LoadState restores the machine to a state written by SaveState, and clears
+// the Journal if there is one. The Chip8 must not be running while it's
+// loaded.
+func (c8 *Chip8) LoadState(r io.Reader) error {
+	var header stateHeader
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return err
+	}
+	if header.Magic != stateMagic {
+		return ErrBadState
+	}
+
+	if header.Version < 1 || header.Version > STATE_VERSION {
+		return fmt.Errorf("%w: unknown version %d", ErrBadState, header.Version)
+	}
+
+	var st stateV1
+	if err := binary.Read(r, binary.BigEndian, &st); err != nil {
+		return err
+	}
+	// Version 1 states didn't record the random source or the counts, so
+	// they're left alone.
+	var v2 stateV2
+	if header.Version >= 2 {
+		if err := binary.Read(r, binary.BigEndian, &v2); err != nil {
+			return err
+		}
+	}
+	if int(st.MemorySize) > len(c8.memory) {
+		return fmt.Errorf("%w: needs %d bytes of memory but there are only %d", ErrBadState, st.MemorySize, len(c8.memory))
+	}
+	if !(st.Width == LORES_WIDTH && st.Height == LORES_HEIGHT) && !(st.Width == HIRES_WIDTH && st.Height == HIRES_HEIGHT) {
+		return fmt.Errorf("%w: screen is %dx%d", ErrBadState, st.Width, st.Height)
+	}
+	if int(st.StackDepth) > STACK_SIZE {
+		return fmt.Errorf("%w: call stack is %d deep", ErrBadState, st.StackDepth)
+	}
+	memory := make([]byte, len(c8.memory))
+	if _, err := io.ReadFull(r, memory[:st.MemorySize]); err != nil {
+		return err
+	}
+
+	c8.memory = memory
+	for i, v := range st.Registers {
+		c8.registers[byte(i)] = v
+	}
+	c8.regI = st.RegI
+	c8.programPtr = st.ProgramPtr
+	c8.callStack = append([]uint16{}, st.CallStack[:st.StackDepth]...)
+	c8.delayTimer.Set(st.DelayTimer)
+	c8.beepTimer.Set(st.BeepTimer)
+	c8.FrameBuffer.Width = int(st.Width)
+	c8.FrameBuffer.Height = int(st.Height)
+	c8.FrameBuffer.selected = st.Selected
+	for p := range c8.FrameBuffer.Planes {
+		copy(c8.FrameBuffer.Planes[p], st.Planes[p][:])
+	}
+	c8.Keyboard.Update(st.Keys)
+	c8.rplFlags = st.RPLFlags
+	c8.audioPattern = st.AudioPattern
+	c8.pitch = st.Pitch
+	if header.Version >= 2 {
+		c8.cycles, c8.frames = v2.Cycles, v2.Frames
+	}
+	if v2.Seeded == 1 {
+		c8.random = &SeededSource{seed: v2.Seed, counter: v2.Counter}
+	}
+	c8.updateAudio()
+	if c8.Provenance != nil {
+		c8.Provenance.set(0, len(c8.memory), Origin{Kind: OriginExternal})
+	}
+	if c8.Journal != nil {
+		// The journal can't undo its way back past a jump to another state.
+		c8.Journal.Clear()
+	}
+	return nil
+}
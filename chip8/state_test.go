@@ -0,0 +1,103 @@
+package chip8
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	a := runFrames(t, 42, 20)
+	var buf bytes.Buffer
+	if err := a.SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewChip8(nullBeeper{}, QuirksModern, NewSeededSource(1))
+	if err := b.LoadState(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if ra, rb := a.Registers(), b.Registers(); !reflect.DeepEqual(ra, rb) {
+		t.Errorf("the registers differ: %+v and %+v", ra, rb)
+	}
+	if a.FrameBuffer.String() != b.FrameBuffer.String() {
+		t.Errorf("the screens differ:\n%s\n%s", a.FrameBuffer, b.FrameBuffer)
+	}
+	if !bytes.Equal(a.memory, b.memory) {
+		t.Error("the memory differs")
+	}
+	if a.Cycles() != b.Cycles() || a.Frames() != b.Frames() {
+		t.Errorf("got %d cycles and %d frames, want %d and %d", b.Cycles(), b.Frames(), a.Cycles(), a.Frames())
+	}
+
+	// Both carry on drawing the same random digits.
+	a.Break = nil
+	for i := 0; i < 10; i++ {
+		if err := a.RunFrame(a.CyclesPerFrame); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.RunFrame(b.CyclesPerFrame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.FrameBuffer.String() != b.FrameBuffer.String() {
+		t.Errorf("the screens differ after running on:\n%s\n%s", a.FrameBuffer, b.FrameBuffer)
+	}
+}
+
+func TestLoadVersion1State(t *testing.T) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, &stateHeader{Magic: stateMagic, Version: 1})
+	st := stateV1{
+		RegI:       0x300,
+		ProgramPtr: 0x204,
+		StackDepth: 1,
+		DelayTimer: 9,
+		Width:      LORES_WIDTH,
+		Height:     LORES_HEIGHT,
+		Pitch:      DEFAULT_PITCH,
+		MemorySize: MEMORY_SIZE,
+	}
+	st.Registers[3] = 0x33
+	st.CallStack[0] = 0x202
+	binary.Write(&buf, binary.BigEndian, &st)
+	memory := make([]byte, MEMORY_SIZE)
+	copy(memory[PROGRAM_OFFSET:], testProgram)
+	buf.Write(memory)
+
+	c8 := NewChip8(nullBeeper{}, QuirksModern, NewSeededSource(5))
+	c8.cycles, c8.frames = 100, 10
+	if err := c8.LoadState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := Registers{I: 0x300, PC: 0x204, Stack: []uint16{0x202}, DelayTimer: 9}
+	want.V[3] = 0x33
+	if got := c8.Registers(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got registers %+v, want %+v", got, want)
+	}
+	if !bytes.Equal(c8.memory, memory) {
+		t.Error("the memory differs")
+	}
+	// A version 1 state has no random source or counts, so they're left as
+	// they were.
+	if seeded, ok := c8.random.(*SeededSource); !ok || seeded.Seed() != 5 {
+		t.Errorf("the random source was replaced with %#v", c8.random)
+	}
+	if c8.Cycles() != 100 || c8.Frames() != 10 {
+		t.Errorf("got %d cycles and %d frames, want 100 and 10", c8.Cycles(), c8.Frames())
+	}
+}
+
+func TestLoadBadState(t *testing.T) {
+	c8 := NewChip8(nullBeeper{}, QuirksModern, nil)
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, &stateHeader{Magic: stateMagic, Version: STATE_VERSION + 1})
+	if err := c8.LoadState(&buf); !errors.Is(err, ErrBadState) {
+		t.Errorf("loading a newer version gave %v, want ErrBadState", err)
+	}
+	if err := c8.LoadState(bytes.NewReader([]byte("GC8X\x00\x01"))); !errors.Is(err, ErrBadState) {
+		t.Errorf("loading the wrong magic gave %v, want ErrBadState", err)
+	}
+}
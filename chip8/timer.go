@@ -1,55 +1,49 @@
 package chip8
 
 import (
-	"time"
+	"sync"
 )
 
+// Timer counts down from its value to 0, calling its callback when it gets
+// there. It's ticked once a frame by RunFrame, so that it runs at 60hz when
+// the machine does. The value is kept behind a mutex so that it can be read
+// and restored at any time, e.g. for save states.
 type Timer struct {
-	getVal     chan bool
-	setVal     chan byte
-	receiveVal chan byte
+	mutex *sync.Mutex
+	val   byte
+	cb    func()
 }
 
 func (t *Timer) Read() byte {
-	t.getVal <- true
-	val := <-t.receiveVal
-	return val
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.val
 }
 
 func (t *Timer) Set(newVal byte) {
-	t.setVal <- newVal
+	t.mutex.Lock()
+	t.val = newVal
+	t.mutex.Unlock()
 }
 
-func NewTimer(cb func()) *Timer {
-	getVal := make(chan bool)
-	setVal := make(chan byte)
-	receiveVal := make(chan byte)
-
-	ticker := time.NewTicker(TIMER_TICK * time.Millisecond)
-	var val byte
-	val = 0
+// tick decrements the timer, calling the callback if it reaches 0.
+func (t *Timer) tick() {
+	t.mutex.Lock()
+	fire := false
+	if t.val > 0 {
+		t.val--
+		fire = t.val == 0
+	}
+	t.mutex.Unlock()
 
-	go func() {
-		for {
-			select {
-			case newVal := <-setVal:
-				val = newVal
-			case <-getVal:
-				receiveVal <- val
-			case <-ticker.C:
-				if val > 0 {
-					val--
-					if val == 0 {
-						cb()
-					}
-				}
-			}
-		}
-	}()
+	if fire {
+		t.cb()
+	}
+}
 
+func NewTimer(cb func()) *Timer {
 	return &Timer{
-		getVal:     getVal,
-		setVal:     setVal,
-		receiveVal: receiveVal,
+		mutex: &sync.Mutex{},
+		cb:    cb,
 	}
 }
@@ -0,0 +1,89 @@
+package chip8
+
+// TraceEntry is what an instruction did, as passed to the Trace hook.
+//
+// Cycle, Frame: the number of instructions and frames executed before it
+//
+// PC, Instr: its address and its bytes - four of them for F000 nnnn
+//
+// Changes: the V registers it changed, with their new values
+//
+// I: the I register after it
+//
+// Writes: the memory it wrote, with the bytes written
+//
+// Stack: the call stack when it was executed, with the most recent return
+// address last. It isn't kept in binary traces.
+type TraceEntry struct {
+	Cycle   uint64
+	Frame   uint64
+	PC      uint16
+	Instr   []byte
+	Changes []RegisterChange
+	I       uint16
+	Writes  []MemoryWrite
+	Stack   []uint16
+	before  [16]byte
+}
+
+// RegisterChange is a new value for the register VV.
+type RegisterChange struct {
+	V     byte
+	Value byte
+}
+
+// MemoryWrite is a run of bytes written to memory at Addr.
+type MemoryWrite struct {
+	Addr uint16
+	Data []byte
+}
+
+// Opcode returns the first two bytes of the instruction.
+func (e *TraceEntry) Opcode() uint16 {
+	if len(e.Instr) < 2 {
+		return 0
+	}
+	return uint16(e.Instr[0])<<8 | uint16(e.Instr[1])
+}
+
+// Mnemonic returns the disassembled instruction, e.g. "LD V3, 0x01".
+func (e *TraceEntry) Mnemonic() string {
+	return Mnemonic(e.Instr)
+}
+
+// startTrace starts the TraceEntry for the instruction about to be executed.
+func (c8 *Chip8) startTrace() *TraceEntry {
+	entry := &TraceEntry{
+		Cycle: c8.cycles,
+		Frame: c8.frames,
+		PC:    c8.programPtr,
+		Stack: append([]uint16{}, c8.callStack...),
+	}
+	for i := range entry.before {
+		entry.before[i] = c8.registers[byte(i)]
+	}
+	if c8.inMemory(c8.programPtr, 2) {
+		entry.Instr = append([]byte{}, c8.memory[c8.programPtr:c8.programPtr+2]...)
+		if entry.Opcode() == 0xF000 && c8.inMemory(c8.programPtr, 4) {
+			entry.Instr = append(entry.Instr, c8.memory[c8.programPtr+2:c8.programPtr+4]...)
+		}
+	}
+	return entry
+}
+
+// finishTrace fills in what the instruction did and passes entry to Trace.
+func (c8 *Chip8) finishTrace(entry *TraceEntry) {
+	for i, v := range entry.before {
+		if after := c8.registers[byte(i)]; after != v {
+			entry.Changes = append(entry.Changes, RegisterChange{V: byte(i), Value: after})
+		}
+	}
+	entry.I = c8.regI
+	for _, a := range c8.accesses {
+		if a.Write {
+			data, _ := c8.ReadMemory(a.Addr, a.Len)
+			entry.Writes = append(entry.Writes, MemoryWrite{Addr: a.Addr, Data: data})
+		}
+	}
+	c8.Trace(entry)
+}
@@ -0,0 +1,569 @@
+package console
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/debugger"
+)
+
+// EXPR_HELP describes the expressions that commands take.
+const EXPR_HELP = `expressions use V0-VF, I, PC, SP, DT, ST, [addr] for a byte of memory, numbers,
+and the C operators, e.g. V3 == 0x10 && I > 0x300`
+
+// commands is every command, in the order help lists them. It's filled in by
+// init, since help refers to it.
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "break", aliases: []string{"b"}, usage: "break addr [if expr] | break if expr | break smc [if expr]",
+			help: "stop before the instruction at addr, before any instruction when expr is true, or before executing self-modifying code", run: (*Console).breakCmd},
+		{name: "watch", usage: "watch addr [len] [if expr] | watch expr",
+			help: "stop after an instruction writes to memory at addr, or changes the value of expr", run: watchCmd("watch", debugger.WatchWrite)},
+		{name: "rwatch", usage: "rwatch addr [len] [if expr]",
+			help: "stop after an instruction reads memory at addr", run: watchCmd("rwatch", debugger.WatchRead)},
+		{name: "awatch", usage: "awatch addr [len] [if expr]",
+			help: "stop after an instruction reads or writes memory at addr", run: watchCmd("awatch", debugger.WatchAccess)},
+		{name: "info", aliases: []string{"i"}, usage: "info",
+			help: "list breakpoints and watchpoints", run: (*Console).infoCmd},
+		{name: "delete", aliases: []string{"d"}, usage: "delete n",
+			help: "delete breakpoint n", run: (*Console).deleteCmd},
+		{name: "reverse-step", aliases: []string{"rs"}, usage: "reverse-step [n]",
+			help: "undo the last n instructions, by default 1", run: (*Console).reverseStepCmd},
+		{name: "reverse-continue", aliases: []string{"rc"}, usage: "reverse-continue",
+			help: "run backwards until a breakpoint or watchpoint is hit, stopping before the instruction that hit a watchpoint", run: (*Console).reverseContinueCmd},
+		{name: "step-back", aliases: []string{"sb"}, usage: "step-back [n]",
+			help: "go back n frames through the rewind history, by default 1", run: (*Console).stepBackCmd},
+		{name: "provenance", usage: "provenance addr [len] | provenance smc",
+			help: "show which instruction last wrote the memory at addr, or list the self-modifying code that has been executed", run: (*Console).provenanceCmd},
+		{name: "x", usage: "x/NFU addr",
+			help: "examine N units of memory at addr in format F (x hex, d decimal, c char, t binary, i instructions) and unit U (b byte, h two bytes)", run: (*Console).examineCmd},
+		{name: "set", usage: "set reg = expr | set [addr] = expr",
+			help: "set V0-VF, I, PC, DT, ST or a byte of memory", run: (*Console).setCmd},
+		{name: "poke", usage: "poke addr byte...",
+			help: "write bytes to memory starting at addr", run: (*Console).pokeCmd},
+		{name: "print", aliases: []string{"p"}, usage: "print expr",
+			help: "print the value of expr", run: (*Console).printCmd},
+		{name: "regs", usage: "regs",
+			help: "print the registers and timers", run: (*Console).regsCmd},
+		{name: "bt", aliases: []string{"backtrace", "where"}, usage: "bt",
+			help: "print the call stack", run: (*Console).btCmd},
+		{name: "disas", usage: "disas [addr [count]]",
+			help: "disassemble count instructions from addr, by default 10 from the PC", run: (*Console).disasCmd},
+		{name: "fb", usage: "fb",
+			help: "print the frame buffer, one digit per pixel", run: (*Console).fbCmd},
+		{name: "dump", usage: "dump mem file [addr [len]]",
+			help: "write memory, by default all of it, to file", run: (*Console).dumpCmd},
+		{name: "history", usage: "history",
+			help: "list the commands run so far; !! runs the last again and !n runs the nth", run: (*Console).historyCmd},
+		{name: "help", aliases: []string{"h", "?"}, usage: "help [command]",
+			help: "list the commands, or describe one", run: (*Console).helpCmd},
+	}
+}
+
+func (c *Console) breakCmd(w io.Writer, args []string) error {
+	args, cond, err := splitCond(args)
+	if err != nil {
+		return err
+	}
+	var bp *debugger.Breakpoint
+	switch {
+	case len(args) == 1 && args[0] == "smc":
+		if bp, err = c.debugger.BreakSelfModifying(cond); err != nil {
+			return err
+		}
+	case len(args) == 0 && cond != nil:
+		bp = c.debugger.BreakIf(cond)
+	case len(args) > 0:
+		addr, err := c.evalAddr(strings.Join(args, " "))
+		if err != nil {
+			return err
+		}
+		bp = c.debugger.Break(addr, cond)
+	default:
+		return usage("break")
+	}
+	fmt.Fprintf(w, "added %v\n", bp)
+	return nil
+}
+
+// watchCmd returns the function that runs the watch command name, which adds
+// memory watchpoints of the given kind. A number is always the address of a
+// memory watchpoint. Anything else is an expression to watch the value of,
+// which only watch takes.
+func watchCmd(name string, kind debugger.Kind) func(c *Console, w io.Writer, args []string) error {
+	return func(c *Console, w io.Writer, args []string) error {
+		return c.watch(w, name, kind, args)
+	}
+}
+
+func (c *Console) watch(w io.Writer, name string, kind debugger.Kind, args []string) error {
+	args, cond, err := splitCond(args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return usage(name)
+	}
+	var bp *debugger.Breakpoint
+	addr, err := strconv.ParseUint(args[0], 0, 16)
+	if err == nil && len(args) <= 2 {
+		n := 1
+		if len(args) == 2 {
+			if n, err = c.eval(args[1]); err != nil {
+				return err
+			}
+		}
+		bp, err = c.debugger.Watch(kind, uint16(addr), n, cond)
+	} else if kind == debugger.WatchWrite {
+		var value *debugger.Expr
+		if value, err = debugger.ParseExpr(strings.Join(args, " ")); err == nil {
+			bp, err = c.debugger.WatchValue(value, cond)
+		}
+	} else {
+		err = usage(name)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "added %v\n", bp)
+	return nil
+}
+
+func (c *Console) infoCmd(w io.Writer, args []string) error {
+	bps := c.debugger.Breakpoints()
+	if len(bps) == 0 {
+		fmt.Fprintln(w, "no breakpoints or watchpoints")
+	}
+	for _, bp := range bps {
+		fmt.Fprintf(w, "%v (hit %d times)\n", bp, bp.Hits)
+	}
+	return nil
+}
+
+func (c *Console) deleteCmd(w io.Writer, args []string) error {
+	if len(args) != 1 {
+		return usage("delete")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("bad breakpoint number %q", args[0])
+	}
+	return c.debugger.Delete(id)
+}
+
+// examineCmd runs x/NFU addr.
+func (c *Console) examineCmd(w io.Writer, args []string) error {
+	count, format, unit := 1, byte('x'), 1
+	if len(args) > 0 && strings.HasPrefix(args[0], "/") {
+		spec := args[0][1:]
+		args = args[1:]
+		digits := 0
+		for digits < len(spec) && spec[digits] >= '0' && spec[digits] <= '9' {
+			digits++
+		}
+		if digits > 0 {
+			count, _ = strconv.Atoi(spec[:digits])
+		}
+		for _, f := range spec[digits:] {
+			switch f {
+			case 'x', 'd', 'c', 't', 'i':
+				format = byte(f)
+			case 'b':
+				unit = 1
+			case 'h':
+				unit = 2
+			default:
+				return fmt.Errorf("unknown format or unit %q", f)
+			}
+		}
+	}
+	if len(args) == 0 {
+		return usage("x")
+	}
+	addr, err := c.evalAddr(strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+	if format == 'i' {
+		return c.disassemble(w, addr, count)
+	}
+
+	n := count * unit
+	if int(addr)+n > c.c8.MemorySize() {
+		n = (c.c8.MemorySize() - int(addr)) / unit * unit
+	}
+	data, err := c.c8.ReadMemory(addr, n)
+	if err != nil {
+		return err
+	}
+	perLine := 16 / unit
+	if format == 't' {
+		perLine = 8 / unit
+	}
+	for i := 0; i < len(data); i += unit {
+		if i/unit%perLine == 0 {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "0x%04X:", int(addr)+i)
+		}
+		v := int(data[i])
+		if unit == 2 {
+			v = v<<8 | int(data[i+1])
+		}
+		switch format {
+		case 'x':
+			fmt.Fprintf(w, " %0*X", unit*2, v)
+		case 'd':
+			fmt.Fprintf(w, " %d", v)
+		case 't':
+			fmt.Fprintf(w, " %0*b", unit*8, v)
+		case 'c':
+			if v >= 0x20 && v < 0x7F {
+				fmt.Fprintf(w, " '%c'", v)
+			} else {
+				fmt.Fprintf(w, " %d", v)
+			}
+		}
+	}
+	fmt.Fprintln(w)
+	return nil
+}
+
+// setCmd runs set target = expr.
+func (c *Console) setCmd(w io.Writer, args []string) error {
+	parts := strings.SplitN(strings.Join(args, " "), "=", 2)
+	if len(parts) != 2 {
+		return usage("set")
+	}
+	target := strings.ToUpper(strings.TrimSpace(parts[0]))
+	v, err := c.eval(parts[1])
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(target, "[") && strings.HasSuffix(target, "]") {
+		addr, err := c.evalAddr(target[1 : len(target)-1])
+		if err != nil {
+			return err
+		}
+		if v < 0 || v > 0xFF {
+			return fmt.Errorf("0x%X doesn't fit in a byte", v)
+		}
+		return c.c8.WriteMemory(addr, []byte{byte(v)})
+	}
+
+	regs := c.c8.Registers()
+	max := 0xFF
+	switch {
+	case target == "I":
+		max = 0xFFFF
+		regs.I = uint16(v)
+	case target == "PC":
+		max = c.c8.MemorySize() - 1
+		regs.PC = uint16(v)
+	case target == "DT":
+		regs.DelayTimer = byte(v)
+	case target == "ST":
+		regs.SoundTimer = byte(v)
+	case len(target) == 2 && target[0] == 'V':
+		x, err := strconv.ParseUint(target[1:], 16, 8)
+		if err != nil {
+			return fmt.Errorf("unknown register %q", parts[0])
+		}
+		regs.V[x] = byte(v)
+	default:
+		return fmt.Errorf("can't set %q", strings.TrimSpace(parts[0]))
+	}
+	if v < 0 || v > max {
+		return fmt.Errorf("0x%X is out of range for %s", v, target)
+	}
+	return c.c8.SetRegisters(regs)
+}
+
+func (c *Console) pokeCmd(w io.Writer, args []string) error {
+	if len(args) < 2 {
+		return usage("poke")
+	}
+	addr, err := c.evalAddr(args[0])
+	if err != nil {
+		return err
+	}
+	var data []byte
+	for _, arg := range args[1:] {
+		v, err := c.eval(arg)
+		if err != nil {
+			return err
+		}
+		if v < 0 || v > 0xFF {
+			return fmt.Errorf("%s doesn't fit in a byte", arg)
+		}
+		data = append(data, byte(v))
+	}
+	return c.c8.WriteMemory(addr, data)
+}
+
+func (c *Console) printCmd(w io.Writer, args []string) error {
+	if len(args) == 0 {
+		return usage("print")
+	}
+	v, err := c.eval(strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "0x%X (%d)\n", v, v)
+	return nil
+}
+
+func (c *Console) regsCmd(w io.Writer, args []string) error {
+	regs := c.c8.Registers()
+	for i := 0; i < 16; i += 4 {
+		fmt.Fprintf(w, "V%X %02X  V%X %02X  V%X %02X  V%X %02X\n",
+			i, regs.V[i], i+1, regs.V[i+1], i+2, regs.V[i+2], i+3, regs.V[i+3])
+	}
+	fmt.Fprintf(w, "I  %03X  PC %03X  SP %d  DT %02X  ST %02X\n",
+		regs.I, regs.PC, len(regs.Stack), regs.DelayTimer, regs.SoundTimer)
+	return nil
+}
+
+func (c *Console) reverseStepCmd(w io.Writer, args []string) error {
+	n := 1
+	if len(args) > 1 {
+		return usage("reverse-step")
+	}
+	if len(args) == 1 {
+		v, err := c.eval(args[0])
+		if err != nil {
+			return err
+		}
+		n = v
+	}
+	for i := 0; i < n; i++ {
+		if err := c.debugger.ReverseStep(); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(w, "at 0x%03X\n", c.c8.Registers().PC)
+	return nil
+}
+
+func (c *Console) stepBackCmd(w io.Writer, args []string) error {
+	n := 1
+	if len(args) > 1 {
+		return usage("step-back")
+	}
+	if len(args) == 1 {
+		v, err := c.eval(args[0])
+		if err != nil {
+			return err
+		}
+		n = v
+	}
+	for i := 0; i < n; i++ {
+		if err := c.debugger.StepBack(); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(w, "at frame %d, 0x%03X\n", c.c8.Frames(), c.c8.Registers().PC)
+	return nil
+}
+
+func (c *Console) reverseContinueCmd(w io.Writer, args []string) error {
+	if len(args) != 0 {
+		return usage("reverse-continue")
+	}
+	err := c.debugger.ReverseContinue()
+	var hit *debugger.Hit
+	if !errors.As(err, &hit) {
+		return err
+	}
+	fmt.Fprintf(w, "%s, at 0x%03X\n", hit.Reason, c.c8.Registers().PC)
+	return nil
+}
+
+// provenanceCmd runs provenance addr [len], printing a line for each run of
+// bytes with the same origin, or provenance smc.
+func (c *Console) provenanceCmd(w io.Writer, args []string) error {
+	p := c.c8.Provenance
+	if p == nil {
+		return debugger.ErrNoProvenance
+	}
+	if len(args) == 1 && args[0] == "smc" {
+		mods := p.SelfModifications()
+		if len(mods) == 0 {
+			fmt.Fprintln(w, "no self-modifying code has been executed")
+		}
+		for _, m := range mods {
+			fmt.Fprintf(w, "0x%03X %v, executed %d times from cycle %d\n", m.PC, m.Origin, m.Count, m.Cycle)
+		}
+		return nil
+	}
+	if len(args) < 1 || len(args) > 2 {
+		return usage("provenance")
+	}
+	addr, err := c.evalAddr(args[0])
+	if err != nil {
+		return err
+	}
+	n := 1
+	if len(args) == 2 {
+		if n, err = c.eval(args[1]); err != nil {
+			return err
+		}
+	}
+	end := int(addr) + n
+	if end > c.c8.MemorySize() {
+		end = c.c8.MemorySize()
+	}
+	for start := int(addr); start < end; {
+		origin := p.Origin(uint16(start))
+		next := start + 1
+		for next < end && p.Origin(uint16(next)) == origin {
+			next++
+		}
+		if next-start == 1 {
+			fmt.Fprintf(w, "0x%03X: %v\n", start, origin)
+		} else {
+			fmt.Fprintf(w, "0x%03X-0x%03X: %v\n", start, next-1, origin)
+		}
+		start = next
+	}
+	return nil
+}
+
+func (c *Console) btCmd(w io.Writer, args []string) error {
+	for i, f := range debugger.Backtrace(c.c8) {
+		fmt.Fprintf(w, "#%d  0x%03X in %s\n", i, f.Addr, f.Name)
+	}
+	return nil
+}
+
+func (c *Console) disasCmd(w io.Writer, args []string) error {
+	addr, count := c.c8.Registers().PC, 10
+	if len(args) > 2 {
+		return usage("disas")
+	}
+	if len(args) > 0 {
+		var err error
+		if addr, err = c.evalAddr(args[0]); err != nil {
+			return err
+		}
+	}
+	if len(args) > 1 {
+		var err error
+		if count, err = c.eval(args[1]); err != nil {
+			return err
+		}
+	}
+	return c.disassemble(w, addr, count)
+}
+
+// disassemble writes count instructions from addr, marking the PC with =>.
+func (c *Console) disassemble(w io.Writer, addr uint16, count int) error {
+	// Leave room for F000 nnnn instructions, which take up four bytes.
+	end := int(addr) + 4*count
+	if end > c.c8.MemorySize() {
+		end = c.c8.MemorySize()
+	}
+	mem, err := c.c8.ReadMemory(addr, end-int(addr))
+	if err != nil {
+		return err
+	}
+	pc := c.c8.Registers().PC
+	builder := chip8.DisassembleSymbols(mem, addr, c.c8.Symbols, nil)
+	lines := strings.SplitN(builder.String(), "\n", count+1)
+	for _, line := range lines[:len(lines)-1] {
+		var lineAddr uint16
+		fmt.Sscanf(line, "0x%X", &lineAddr)
+		mark := "  "
+		if lineAddr == pc {
+			mark = "=>"
+		}
+		fmt.Fprintf(w, "%s %s\n", mark, line)
+	}
+	return nil
+}
+
+func (c *Console) fbCmd(w io.Writer, args []string) error {
+	_, err := io.WriteString(w, c.c8.FrameBuffer.String())
+	return err
+}
+
+func (c *Console) dumpCmd(w io.Writer, args []string) error {
+	if len(args) < 2 || len(args) > 4 || args[0] != "mem" {
+		return usage("dump")
+	}
+	addr, n := uint16(0), c.c8.MemorySize()
+	if len(args) > 2 {
+		var err error
+		if addr, err = c.evalAddr(args[2]); err != nil {
+			return err
+		}
+		n -= int(addr)
+	}
+	if len(args) > 3 {
+		var err error
+		if n, err = c.eval(args[3]); err != nil {
+			return err
+		}
+	}
+	data, err := c.c8.ReadMemory(addr, n)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(args[1], data, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "wrote %d bytes from 0x%03X to %s\n", len(data), addr, args[1])
+	return nil
+}
+
+func (c *Console) historyCmd(w io.Writer, args []string) error {
+	for i, line := range c.history {
+		fmt.Fprintf(w, "%4d  %s\n", i+1, line)
+	}
+	return nil
+}
+
+func (c *Console) helpCmd(w io.Writer, args []string) error {
+	if len(args) > 0 {
+		cmd, err := lookup(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s - %s\n", cmd.usage, cmd.help)
+		if len(cmd.aliases) > 0 {
+			fmt.Fprintf(w, "also: %s\n", strings.Join(cmd.aliases, ", "))
+		}
+		return nil
+	}
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-32s %s\n", cmd.usage, cmd.help)
+	}
+	fmt.Fprintln(w, "commands can be shortened to any unique prefix, e.g. dis for disas")
+	fmt.Fprintln(w, EXPR_HELP)
+	return nil
+}
+
+// splitCond splits a trailing "if expr" off args and parses it.
+func splitCond(args []string) ([]string, *debugger.Expr, error) {
+	for i, arg := range args {
+		if arg == "if" {
+			cond, err := debugger.ParseExpr(strings.Join(args[i+1:], " "))
+			return args[:i], cond, err
+		}
+	}
+	return args, nil, nil
+}
+
+func usage(name string) error {
+	cmd, _ := lookup(name)
+	return fmt.Errorf("usage: %s", cmd.usage)
+}
@@ -0,0 +1,160 @@
+// Package console parses and runs debugger commands, such as x/32xb 0x300,
+// set V5 = 0x20 or break 0x2A0 if V3 == 1, against a stopped Chip8. It's
+// shared by the terminal debugger and the remote debugger servers, so that
+// they all understand the same commands.
+//
+// Commands can be abbreviated to any unique prefix, and some have short
+// aliases, e.g. b for break. Every command run is kept in the history, and
+// !! or !n runs the last or nth command again.
+package console
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/debugger"
+)
+
+// HISTORY_SIZE is the number of commands kept in the history.
+const HISTORY_SIZE = 500
+
+// ErrUnknownCommand is returned for a command that doesn't match any command,
+// or that matches more than one.
+var ErrUnknownCommand = errors.New("unknown command")
+
+// command is one of the commands a Console understands.
+type command struct {
+	name    string
+	aliases []string
+	usage   string
+	help    string
+	run     func(c *Console, w io.Writer, args []string) error
+}
+
+// Console runs commands against a Chip8 and its Debugger.
+type Console struct {
+	c8       *chip8.Chip8
+	debugger *debugger.Debugger
+	history  []string
+}
+
+func New(c8 *chip8.Chip8, d *debugger.Debugger) *Console {
+	return &Console{c8: c8, debugger: d}
+}
+
+// History returns the commands run so far, oldest first.
+func (c *Console) History() []string {
+	return append([]string{}, c.history...)
+}
+
+// Exec runs the command in line, writing its output to w. The Chip8 must not
+// be running.
+func (c *Console) Exec(w io.Writer, line string) error {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "!") {
+		recalled, err := c.recall(line)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, recalled)
+		line = recalled
+	}
+	if line == "" {
+		return nil
+	}
+	c.history = append(c.history, line)
+	if len(c.history) > HISTORY_SIZE {
+		c.history = c.history[len(c.history)-HISTORY_SIZE:]
+	}
+
+	name, args := splitCommand(line)
+	cmd, err := lookup(name)
+	if err != nil {
+		return err
+	}
+	return cmd.run(c, w, args)
+}
+
+// recall returns the command from the history that !! or !n refers to.
+func (c *Console) recall(line string) (string, error) {
+	if len(c.history) == 0 {
+		return "", fmt.Errorf("no commands in the history")
+	}
+	if line == "!!" {
+		return c.history[len(c.history)-1], nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 1 || n > len(c.history) {
+		return "", fmt.Errorf("no command %s in the history", line)
+	}
+	return c.history[n-1], nil
+}
+
+// splitCommand splits line into the command name and its arguments. A /
+// after the name starts the first argument, so that x/32xb 0x300 is x with
+// the arguments /32xb and 0x300.
+func splitCommand(line string) (string, []string) {
+	fields := strings.Fields(line)
+	name := fields[0]
+	if slash := strings.Index(name, "/"); slash > 0 {
+		return name[:slash], append([]string{name[slash:]}, fields[1:]...)
+	}
+	return name, fields[1:]
+}
+
+// lookup finds the command name refers to, by its name, an alias, or a
+// unique prefix of its name.
+func lookup(name string) (*command, error) {
+	var matches []*command
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, nil
+		}
+		for _, alias := range cmd.aliases {
+			if alias == name {
+				return cmd, nil
+			}
+		}
+		if strings.HasPrefix(cmd.name, name) {
+			matches = append(matches, cmd)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("%w %q, try help", ErrUnknownCommand, name)
+	case 1:
+		return matches[0], nil
+	}
+	var names []string
+	for _, cmd := range matches {
+		names = append(names, cmd.name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("%w %q could be %s", ErrUnknownCommand, name, strings.Join(names, ", "))
+}
+
+// eval parses and evaluates an expression.
+func (c *Console) eval(text string) (int, error) {
+	expr, err := debugger.ParseExpr(text)
+	if err != nil {
+		return 0, err
+	}
+	return expr.Eval(c.c8)
+}
+
+// evalAddr evaluates an expression that has to be an address.
+func (c *Console) evalAddr(text string) (uint16, error) {
+	v, err := c.eval(text)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 || v >= c.c8.MemorySize() {
+		return 0, fmt.Errorf("address 0x%X: %w", v, chip8.ErrMemoryFault)
+	}
+	return uint16(v), nil
+}
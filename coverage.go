@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/coverage"
+)
+
+// coverageOptions holds the options for recording coverage.
+type coverageOptions struct {
+	file     string
+	lcovFile string
+}
+
+func (c *coverageOptions) register(flags *flag.FlagSet) {
+	flags.StringVar(&c.file, "coverage", "", "file to write a coverage report to")
+	flags.StringVar(&c.lcovFile, "lcov", "", "file to write the coverage to as lcov, which needs -symbols")
+}
+
+// startCoverage starts recording coverage, if a report or an LCOV file was
+// asked for, and returns the Coverage to set as Chip8.Coverage before the rom
+// is loaded and a function that writes the files once the Chip8 has stopped.
+// Without either file the Coverage is nil.
+func startCoverage(opts options) (*chip8.Coverage, func()) {
+	if opts.coverage.file == "" && opts.coverage.lcovFile == "" {
+		return nil, func() {}
+	}
+	if opts.coverage.lcovFile != "" && opts.symbols == nil {
+		panic("-lcov needs the source lines from -symbols")
+	}
+	size := chip8.MEMORY_SIZE
+	if opts.quirks.ExtendedMemory {
+		size = chip8.EXTENDED_MEMORY_SIZE
+	}
+	cov := chip8.NewCoverage(size)
+	return cov, func() {
+		save := func(filename string, write func(w io.Writer) error) {
+			file, err := os.Create(filename)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "could not write coverage: %v\n", err)
+				return
+			}
+			defer file.Close()
+			if err := write(file); err != nil {
+				fmt.Fprintf(os.Stderr, "could not write coverage: %v\n", err)
+				return
+			}
+			fmt.Fprintf(os.Stderr, "Saved coverage to %s\n", filename)
+		}
+		if opts.coverage.file != "" {
+			save(opts.coverage.file, func(w io.Writer) error {
+				return coverage.Report(w, cov, opts.symbols)
+			})
+		}
+		if opts.coverage.lcovFile != "" {
+			save(opts.coverage.lcovFile, func(w io.Writer) error {
+				return coverage.WriteLCOV(w, cov, opts.symbols)
+			})
+		}
+	}
+}
@@ -0,0 +1,260 @@
+// Package coverage reports on the instructions recorded by a chip8.Coverage:
+// which ones ran, which didn't, and which way the conditional skips went.
+//
+// The instructions of the program are the ones with source lines in its
+// symbol table, if it has one. Otherwise they're found by following its
+// control flow from the entry point, as the disassembler does, so that the
+// data mixed in with the code isn't counted, along with any others that were
+// executed, such as the targets of computed jumps.
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/chip8/disasm"
+	"github.com/zabrahams/gochip8/symbols"
+)
+
+// Instructions returns the address of every instruction in the program that
+// cov recorded, in order, using the source lines in table if it's set.
+func Instructions(cov *chip8.Coverage, table *symbols.Table) []uint16 {
+	program := cov.Program()
+	inProgram := func(addr uint16) bool {
+		return int(addr) >= chip8.PROGRAM_OFFSET && int(addr) < chip8.PROGRAM_OFFSET+len(program)
+	}
+	if table != nil {
+		var addrs []uint16
+		for _, addr := range table.Lines() {
+			if inProgram(addr) {
+				addrs = append(addrs, addr)
+			}
+		}
+		return addrs
+	}
+
+	listing := disasm.Analyze(program, chip8.PROGRAM_OFFSET)
+	code := map[uint16]bool{}
+	for i := range program {
+		if addr := chip8.PROGRAM_OFFSET + uint16(i); listing.IsCode(addr) {
+			code[addr] = true
+		}
+	}
+	for _, addr := range cov.Executed() {
+		if inProgram(addr) {
+			code[addr] = true
+		}
+	}
+	return sorted(code)
+}
+
+// Branches returns the address of every conditional skip among the
+// Instructions, and of any other skip that was executed, in order.
+func Branches(cov *chip8.Coverage, table *symbols.Table) []uint16 {
+	skips := map[uint16]bool{}
+	for _, addr := range Instructions(cov, table) {
+		if instr := instrAt(cov, addr); instr != nil && chip8.IsSkip(uint16(instr[0])<<8|uint16(instr[1])) {
+			skips[addr] = true
+		}
+	}
+	for _, addr := range cov.Executed() {
+		if _, ok := cov.Branch(addr); ok {
+			skips[addr] = true
+		}
+	}
+	return sorted(skips)
+}
+
+// Report writes a text report of the coverage to w: how much of the program
+// was executed, the ranges of instructions that weren't, the outcomes of the
+// conditional skips, anything executed outside the program, and the
+// disassembly of the program marked with how often each instruction ran.
+// table can be nil.
+func Report(w io.Writer, cov *chip8.Coverage, table *symbols.Table) error {
+	var b strings.Builder
+	instrs := Instructions(cov, table)
+	var covered int
+	var uncovered [][]uint16
+	for i, addr := range instrs {
+		if cov.Count(addr) > 0 {
+			covered++
+			continue
+		}
+		if i > 0 && len(uncovered) > 0 && cov.Count(instrs[i-1]) == 0 {
+			uncovered[len(uncovered)-1] = append(uncovered[len(uncovered)-1], addr)
+		} else {
+			uncovered = append(uncovered, []uint16{addr})
+		}
+	}
+	fmt.Fprintf(&b, "%d of %d instructions executed (%s)\n", covered, len(instrs), percent(covered, len(instrs)))
+
+	branches := Branches(cov, table)
+	var outcomes int
+	for _, addr := range branches {
+		br, _ := cov.Branch(addr)
+		if br.Taken > 0 {
+			outcomes++
+		}
+		if br.NotTaken > 0 {
+			outcomes++
+		}
+	}
+	fmt.Fprintf(&b, "%d of %d skip outcomes seen (%s)\n", outcomes, 2*len(branches), percent(outcomes, 2*len(branches)))
+
+	fmt.Fprintf(&b, "\nNot executed:\n")
+	for _, run := range uncovered {
+		if len(run) == 1 {
+			fmt.Fprintf(&b, "  0x%03X\n", run[0])
+		} else {
+			fmt.Fprintf(&b, "  0x%03X-0x%03X  (%d instructions)\n", run[0], run[len(run)-1], len(run))
+		}
+	}
+
+	fmt.Fprintf(&b, "\nSkips:\n%-7s  %-16s %10s %10s\n", "address", "instruction", "taken", "not taken")
+	for _, addr := range branches {
+		br, _ := cov.Branch(addr)
+		fmt.Fprintf(&b, "0x%03X    %-16s %10d %10d\n", addr, mnemonic(cov, addr), br.Taken, br.NotTaken)
+	}
+
+	program := cov.Program()
+	var outside []uint16
+	for _, addr := range cov.Executed() {
+		if int(addr) < chip8.PROGRAM_OFFSET || int(addr) >= chip8.PROGRAM_OFFSET+len(program) {
+			outside = append(outside, addr)
+		}
+	}
+	if len(outside) > 0 {
+		fmt.Fprintf(&b, "\nExecuted outside the program:\n%10s  %s\n", "count", "address")
+		for _, addr := range outside {
+			fmt.Fprintf(&b, "%10d  0x%03X\n", cov.Count(addr), addr)
+		}
+	}
+
+	disassembly := chip8.DisassembleSymbols(program, chip8.PROGRAM_OFFSET, table, cov.Annotate)
+	fmt.Fprintf(&b, "\nDisassembly:\n%s", disassembly.String())
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteLCOV writes the coverage to w as an LCOV tracefile, using the source
+// lines in table. Each source line's count is that of the most executed
+// instruction assembled from it, and each conditional skip is a block of two
+// branches, taken and not taken.
+func WriteLCOV(w io.Writer, cov *chip8.Coverage, table *symbols.Table) error {
+	type line struct {
+		count    uint64
+		branches []uint16
+	}
+	files := map[string]map[int]*line{}
+	skips := map[uint16]bool{}
+	for _, addr := range Branches(cov, table) {
+		skips[addr] = true
+	}
+	for _, addr := range table.Lines() {
+		src, _ := table.Line(addr)
+		lines, ok := files[src.File]
+		if !ok {
+			lines = map[int]*line{}
+			files[src.File] = lines
+		}
+		l, ok := lines[src.Line]
+		if !ok {
+			l = &line{}
+			lines[src.Line] = l
+		}
+		if n := cov.Count(addr); n > l.count {
+			l.count = n
+		}
+		if skips[addr] {
+			l.branches = append(l.branches, addr)
+		}
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "TN:")
+	for _, name := range names {
+		lines := files[name]
+		numbers := make([]int, 0, len(lines))
+		for n := range lines {
+			numbers = append(numbers, n)
+		}
+		sort.Ints(numbers)
+
+		fmt.Fprintf(b, "SF:%s\n", name)
+		var branchesFound, branchesHit, linesHit int
+		for _, n := range numbers {
+			for block, addr := range lines[n].branches {
+				br, ran := cov.Branch(addr)
+				for i, count := range []uint64{br.Taken, br.NotTaken} {
+					taken := "-"
+					if ran {
+						taken = fmt.Sprint(count)
+					}
+					fmt.Fprintf(b, "BRDA:%d,%d,%d,%s\n", n, block, i, taken)
+					branchesFound++
+					if count > 0 {
+						branchesHit++
+					}
+				}
+			}
+		}
+		fmt.Fprintf(b, "BRF:%d\nBRH:%d\n", branchesFound, branchesHit)
+		for _, n := range numbers {
+			fmt.Fprintf(b, "DA:%d,%d\n", n, lines[n].count)
+			if lines[n].count > 0 {
+				linesHit++
+			}
+		}
+		fmt.Fprintf(b, "LF:%d\nLH:%d\nend_of_record\n", len(numbers), linesHit)
+	}
+	return b.Flush()
+}
+
+// mnemonic disassembles the instruction at addr in the program, or returns
+// "?" if it's outside it.
+func mnemonic(cov *chip8.Coverage, addr uint16) string {
+	instr := instrAt(cov, addr)
+	if instr == nil {
+		return "?"
+	}
+	return chip8.Mnemonic(instr)
+}
+
+// instrAt returns the bytes of the instruction at addr in the program, or nil
+// if it isn't all inside it.
+func instrAt(cov *chip8.Coverage, addr uint16) []byte {
+	program := cov.Program()
+	i := int(addr) - chip8.PROGRAM_OFFSET
+	if i < 0 || i+2 > len(program) {
+		return nil
+	}
+	if program[i] == 0xF0 && program[i+1] == 0x00 && i+4 <= len(program) {
+		return program[i : i+4]
+	}
+	return program[i : i+2]
+}
+
+func sorted(set map[uint16]bool) []uint16 {
+	addrs := make([]uint16, 0, len(set))
+	for addr := range set {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+func percent(n, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f%%", 100*float64(n)/float64(total))
+}
@@ -0,0 +1,97 @@
+package coverage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/chip8/asm"
+	"github.com/zabrahams/gochip8/headless"
+)
+
+// testSource counts V0 up to 3 and halts, leaving the code after the halt
+// unexecuted.
+const testSource = `start:
+  LD V0, 0
+loop:
+  ADD V0, 1
+  SE V0, 3
+  JP loop
+  SNE V0, 3
+halt:
+  JP halt
+never:
+  SE V1, 1
+`
+
+// run assembles testSource and runs it until it halts, returning its coverage
+// and symbols.
+func run(t *testing.T) (*chip8.Coverage, *asm.Program) {
+	t.Helper()
+	prog, err := asm.Assemble("test.s", []byte(testSource))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cov := chip8.NewCoverage(chip8.MEMORY_SIZE)
+	res, err := headless.Run(prog.Bytes, headless.Config{Frames: 10, Coverage: cov})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Reason != headless.StopHalt {
+		t.Fatalf("the program stopped with %s %s, not a halt", res.Reason, res.Error)
+	}
+	return cov, prog
+}
+
+func TestWriteLCOV(t *testing.T) {
+	cov, prog := run(t)
+	var b strings.Builder
+	if err := WriteLCOV(&b, cov, prog.Symbols); err != nil {
+		t.Fatal(err)
+	}
+	// The halt is stopped at before the jump is executed, so it has no count.
+	want := `TN:
+SF:test.s
+BRDA:5,0,0,1
+BRDA:5,0,1,2
+BRDA:7,0,0,0
+BRDA:7,0,1,1
+BRDA:11,0,0,-
+BRDA:11,0,1,-
+BRF:6
+BRH:3
+DA:2,1
+DA:4,3
+DA:5,3
+DA:6,2
+DA:7,1
+DA:9,0
+DA:11,0
+LF:7
+LH:5
+end_of_record
+`
+	if got := b.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestReport(t *testing.T) {
+	cov, prog := run(t)
+	var b strings.Builder
+	if err := Report(&b, cov, prog.Symbols); err != nil {
+		t.Fatal(err)
+	}
+	report := b.String()
+	for _, want := range []string{
+		"5 of 7 instructions executed (71.43%)\n",
+		"3 of 6 skip outcomes seen (50.00%)\n",
+		"  0x20A-0x20C  (2 instructions)\n",
+		"         3  0x204   3003   SE V0, 0x03               test.s:5 ; taken 1, not taken 2\n",
+		"         -  0x20C   3101   SE V1, 0x01               <never> test.s:11\n",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("the report doesn't contain %q:\n%s", want, report)
+		}
+	}
+}
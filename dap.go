@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/dap"
+	"github.com/zabrahams/gochip8/frontend"
+)
+
+// runDAP serves the debug adapter protocol, on stdin and stdout or on a TCP
+// address if -listen is given. The rom to debug comes from the client's launch
+// request, so programFile isn't used. Since stdout may be carrying the
+// protocol, everything else is logged to stderr.
+func runDAP(programFile string, opts options) {
+	if opts.listen == "" && opts.frontend == "term" {
+		// The terminal frontend would draw over the protocol.
+		opts.frontend = "null"
+	}
+	fe, err := frontend.New(opts.frontend)
+	if err != nil {
+		panic(err)
+	}
+	defer fe.Close()
+
+	c8 := newChip8(fe.Audio, opts)
+	c8.Journal = chip8.NewJournal(chip8.JOURNAL_SIZE)
+	c8.Provenance = chip8.NewProvenance(c8.MemorySize())
+	var stopCoverage func()
+	c8.Coverage, stopCoverage = startCoverage(opts)
+	defer stopCoverage()
+	var stopHooks func()
+	c8.Trace, stopHooks = startHooks(programFile, opts)
+	defer stopHooks()
+	server := dap.New(c8)
+
+	served := make(chan error, 1)
+	if opts.listen == "" {
+		go func() {
+			served <- server.Serve(os.Stdin, os.Stdout)
+		}()
+	} else {
+		l, err := net.Listen("tcp", opts.listen)
+		if err != nil {
+			panic(err)
+		}
+		defer l.Close()
+		fmt.Fprintf(os.Stderr, "Waiting for a debug adapter client on %s\n", l.Addr())
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				served <- err
+				return
+			}
+			defer conn.Close()
+			served <- server.Serve(conn, conn)
+		}()
+	}
+
+	for {
+		select {
+		case err := <-served:
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "debug adapter stopped: %v\n", err)
+			}
+			writeRecording(opts)
+			return
+		default:
+		}
+		input := fe.Input.Poll()
+		if input.Quit {
+			break
+		}
+		if opts.input == nil || !opts.input.Replaying() {
+			c8.Keyboard.Update(input.Keys)
+		}
+		fe.Display.Update(c8.FrameBuffer)
+		time.Sleep(time.Millisecond)
+	}
+	writeRecording(opts)
+}
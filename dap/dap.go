@@ -0,0 +1,663 @@
+// Package dap serves a Chip8 over the Debug Adapter Protocol, so that editors
+// can launch a ROM, set breakpoints, step through it and inspect its
+// registers and memory.
+//
+// The client sees a single thread. The program is shown as a disassembly
+// listing, one instruction per line, which breakpoints can be set on by line.
+// If the Chip8 has Symbols, the program is shown as the source it was built
+// from instead, and breakpoints are set by source line. Breakpoints can also
+// be set on addresses with setInstructionBreakpoints. If the Chip8 has a
+// Journal, the client can step and continue backwards too.
+package dap
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/console"
+	"github.com/zabrahams/gochip8/debugger"
+	"github.com/zabrahams/gochip8/symbols"
+)
+
+// threadID is the ID of the one thread the client sees.
+const threadID = 1
+
+// The variablesReference of each scope.
+const (
+	registersRef = 1 + iota
+	timersRef
+)
+
+// listingRef is the sourceReference of the disassembly listing.
+const listingRef = 1
+
+// errDisconnect is returned by a handler when the client has disconnected.
+var errDisconnect = errors.New("client disconnected")
+
+// Server serves one Chip8 to one client.
+type Server struct {
+	c8          *chip8.Chip8
+	debugger    *debugger.Debugger
+	console     *console.Console
+	w           io.Writer
+	seq         int
+	events      []event
+	listing     *listing
+	stopOnEntry bool
+	// faults is the channel returned by Run while the Chip8 is running, and
+	// nil otherwise.
+	faults <-chan error
+	// lineBreakpoints and instrBreakpoints are the IDs in the debugger of the
+	// breakpoints set by setBreakpoints and setInstructionBreakpoints.
+	lineBreakpoints  []int
+	instrBreakpoints []int
+}
+
+// New returns a Server for c8. It takes over c8.Break for its breakpoints.
+// The program is loaded into c8 by the launch request.
+func New(c8 *chip8.Chip8) *Server {
+	d := debugger.New(c8)
+	return &Server{c8: c8, debugger: d, console: console.New(c8, d)}
+}
+
+// Serve reads requests from r and writes responses and events to w until the
+// client disconnects. The Chip8 is left stopped.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	s.w = w
+	done := make(chan struct{})
+	defer close(done)
+	requests, errs := readRequests(r, done)
+	defer s.pause()
+	for {
+		select {
+		case req := <-requests:
+			body, err := s.handle(req)
+			if err == errDisconnect {
+				return s.respond(req, body, nil)
+			}
+			if err := s.respond(req, body, err); err != nil {
+				return err
+			}
+			if req.Command == "initialize" {
+				s.event("initialized", nil)
+			}
+		case err := <-errs:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		case err, ok := <-s.faults:
+			s.faults = nil
+			if ok {
+				s.stopped(err)
+			}
+		}
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(req *request) (interface{}, error) {
+	switch req.Command {
+	case "initialize":
+		return map[string]bool{
+			"supportsConfigurationDoneRequest": true,
+			"supportsConditionalBreakpoints":   true,
+			"supportsInstructionBreakpoints":   true,
+			"supportsReadMemoryRequest":        true,
+			"supportsDisassembleRequest":       true,
+			"supportsStepBack":                 s.c8.Journal != nil,
+		}, nil
+	case "launch":
+		return nil, s.launch(req.Arguments)
+	case "configurationDone":
+		if s.stopOnEntry {
+			s.event("stopped", stoppedBody("entry", ""))
+			return nil, nil
+		}
+		s.resume()
+		return nil, nil
+	case "setBreakpoints":
+		return s.setBreakpoints(req.Arguments)
+	case "setInstructionBreakpoints":
+		return s.setInstructionBreakpoints(req.Arguments)
+	case "threads":
+		return map[string]interface{}{
+			"threads": []map[string]interface{}{{"id": threadID, "name": "chip8"}},
+		}, nil
+	case "stackTrace":
+		return s.stackTrace(), nil
+	case "scopes":
+		return map[string]interface{}{
+			"scopes": []map[string]interface{}{
+				{"name": "Registers", "variablesReference": registersRef, "expensive": false},
+				{"name": "Timers", "variablesReference": timersRef, "expensive": false},
+			},
+		}, nil
+	case "variables":
+		return s.variables(req.Arguments)
+	case "source":
+		if s.listing == nil {
+			return nil, fmt.Errorf("no program launched")
+		}
+		return map[string]string{"content": s.listing.content}, nil
+	case "continue":
+		s.resume()
+		return map[string]bool{"allThreadsContinued": true}, nil
+	case "next":
+		if cond := debugger.OverCall(s.c8); cond != nil {
+			s.debugger.Until("step", cond)
+			s.resume()
+			return nil, nil
+		}
+		s.step()
+		return nil, nil
+	case "stepIn":
+		s.step()
+		return nil, nil
+	case "stepOut":
+		cond := debugger.OutOfCall(s.c8)
+		if cond == nil {
+			return nil, fmt.Errorf("not in a subroutine")
+		}
+		s.debugger.Until("step", cond)
+		s.resume()
+		return nil, nil
+	case "stepBack":
+		return nil, s.reverse(s.debugger.ReverseStep)
+	case "reverseContinue":
+		return nil, s.reverse(s.debugger.ReverseContinue)
+	case "pause":
+		if s.faults == nil {
+			return nil, nil
+		}
+		if err := s.pause(); err != nil {
+			s.stopped(err)
+		} else {
+			s.event("stopped", stoppedBody("pause", ""))
+		}
+		return nil, nil
+	case "readMemory":
+		return s.readMemory(req.Arguments)
+	case "disassemble":
+		return s.disassemble(req.Arguments)
+	case "evaluate":
+		return s.evaluate(req.Arguments)
+	case "disconnect", "terminate":
+		return nil, errDisconnect
+	}
+	return nil, fmt.Errorf("unsupported request %q", req.Command)
+}
+
+// respond sends the response to req. If err is set the request failed.
+func (s *Server) respond(req *request, body interface{}, err error) error {
+	s.seq++
+	resp := response{
+		Seq:        s.seq,
+		Type:       "response",
+		RequestSeq: req.Seq,
+		Success:    err == nil,
+		Command:    req.Command,
+		Body:       body,
+	}
+	if err != nil {
+		resp.Message = err.Error()
+	}
+	return writeMessage(s.w, resp)
+}
+
+// event queues an event to be sent once the response to the current request
+// has been.
+func (s *Server) event(name string, body interface{}) {
+	s.events = append(s.events, event{Type: "event", Event: name, Body: body})
+}
+
+// flush sends the queued events.
+func (s *Server) flush() error {
+	for _, e := range s.events {
+		s.seq++
+		e.Seq = s.seq
+		if err := writeMessage(s.w, e); err != nil {
+			return err
+		}
+	}
+	s.events = s.events[:0]
+	return nil
+}
+
+func stoppedBody(reason, description string) map[string]interface{} {
+	return map[string]interface{}{
+		"reason":            reason,
+		"description":       description,
+		"threadId":          threadID,
+		"allThreadsStopped": true,
+	}
+}
+
+func (s *Server) launch(raw json.RawMessage) error {
+	var args struct {
+		Program     string `json:"program"`
+		StopOnEntry bool   `json:"stopOnEntry"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return err
+	}
+	program, err := ioutil.ReadFile(args.Program)
+	if err != nil {
+		return err
+	}
+	if err := s.c8.LoadBytes(program); err != nil {
+		return err
+	}
+	s.listing = newListing(filepath.Base(args.Program)+".s", program)
+	s.stopOnEntry = args.StopOnEntry
+	return nil
+}
+
+// resume starts the Chip8 running, if it isn't already.
+func (s *Server) resume() {
+	if s.faults == nil {
+		s.faults = s.c8.Run()
+	}
+}
+
+// pause stops the Chip8 if it's running. If it faulted or hit a breakpoint
+// before it could be stopped that error is returned.
+func (s *Server) pause() error {
+	faults := s.faults
+	s.faults = nil
+	s.debugger.Until("", nil)
+	if faults == nil {
+		return nil
+	}
+	select {
+	case s.c8.Stop <- struct{}{}:
+	case err, ok := <-faults:
+		if ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// step executes one instruction and reports the stop.
+func (s *Server) step() {
+	if err := s.pause(); err != nil {
+		s.stopped(err)
+		return
+	}
+	if err := s.debugger.Step(); err != nil {
+		s.stopped(err)
+		return
+	}
+	s.event("stopped", stoppedBody("step", ""))
+}
+
+// reverse pauses the Chip8 and steps or continues backwards with undo, one
+// of the Debugger's reverse methods, then reports the stop.
+func (s *Server) reverse(undo func() error) error {
+	if s.c8.Journal == nil {
+		return debugger.ErrNoJournal
+	}
+	if err := s.pause(); err != nil {
+		s.stopped(err)
+		return nil
+	}
+	err := undo()
+	switch {
+	case err == nil:
+		s.event("stopped", stoppedBody("step", ""))
+	case errors.Is(err, chip8.ErrNoHistory):
+		s.event("stopped", stoppedBody("step", err.Error()))
+	default:
+		s.stopped(err)
+	}
+	return nil
+}
+
+// stopped queues the events for the Chip8 stopping with err.
+func (s *Server) stopped(err error) {
+	var hit *debugger.Hit
+	switch {
+	case errors.As(err, &hit):
+		reason := "step"
+		if hit.Breakpoint != nil {
+			switch hit.Breakpoint.Kind {
+			case debugger.BreakPC, debugger.BreakCond:
+				reason = "breakpoint"
+			default:
+				reason = "data breakpoint"
+			}
+		}
+		s.event("stopped", stoppedBody(reason, hit.Reason))
+	case errors.Is(err, chip8.ErrExit):
+		s.event("exited", map[string]int{"exitCode": 0})
+		s.event("terminated", nil)
+	default:
+		s.event("output", map[string]string{"category": "stderr", "output": err.Error() + "\n"})
+		s.event("stopped", map[string]interface{}{
+			"reason":            "exception",
+			"description":       "fault",
+			"text":              err.Error(),
+			"threadId":          threadID,
+			"allThreadsStopped": true,
+		})
+	}
+}
+
+type sourceBreakpoint struct {
+	Line      int    `json:"line"`
+	Condition string `json:"condition"`
+}
+
+// setBreakpoints sets breakpoints by line, either in the disassembly listing
+// or, if the Chip8 has Symbols, in the source the program was built from. A
+// breakpoint on a source line without an instruction moves down to the next
+// line that has one.
+func (s *Server) setBreakpoints(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		Source struct {
+			Path            string `json:"path"`
+			SourceReference int    `json:"sourceReference"`
+		} `json:"source"`
+		Breakpoints []sourceBreakpoint `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	for _, id := range s.lineBreakpoints {
+		s.debugger.Delete(id)
+	}
+	s.lineBreakpoints = nil
+	results := []map[string]interface{}{}
+	for _, sbp := range args.Breakpoints {
+		result := map[string]interface{}{"verified": false, "line": sbp.Line}
+		results = append(results, result)
+		var addr uint16
+		var ok bool
+		switch {
+		case args.Source.SourceReference == 0 && s.c8.Symbols != nil:
+			var line int
+			if addr, line, ok = sourceAddr(s.c8.Symbols, args.Source.Path, sbp.Line); ok {
+				result["line"] = line
+			}
+		case s.listing == nil:
+			result["message"] = "no program launched"
+			continue
+		default:
+			addr, ok = s.listing.addr(sbp.Line)
+		}
+		if !ok {
+			result["message"] = "no instruction on this line"
+			continue
+		}
+		bp, err := s.addBreakpoint(addr, sbp.Condition)
+		if err != nil {
+			result["message"] = err.Error()
+			continue
+		}
+		s.lineBreakpoints = append(s.lineBreakpoints, bp.ID)
+		result["id"] = bp.ID
+		result["verified"] = true
+		result["instructionReference"] = fmt.Sprintf("0x%03X", addr)
+	}
+	return map[string]interface{}{"breakpoints": results}, nil
+}
+
+func (s *Server) setInstructionBreakpoints(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		Breakpoints []struct {
+			InstructionReference string `json:"instructionReference"`
+			Offset               int    `json:"offset"`
+			Condition            string `json:"condition"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	for _, id := range s.instrBreakpoints {
+		s.debugger.Delete(id)
+	}
+	s.instrBreakpoints = nil
+	results := []map[string]interface{}{}
+	for _, ibp := range args.Breakpoints {
+		result := map[string]interface{}{"verified": false}
+		results = append(results, result)
+		addr, err := parseReference(ibp.InstructionReference, ibp.Offset)
+		if err != nil {
+			result["message"] = err.Error()
+			continue
+		}
+		bp, err := s.addBreakpoint(addr, ibp.Condition)
+		if err != nil {
+			result["message"] = err.Error()
+			continue
+		}
+		s.instrBreakpoints = append(s.instrBreakpoints, bp.ID)
+		result["id"] = bp.ID
+		result["verified"] = true
+		result["instructionReference"] = fmt.Sprintf("0x%03X", addr)
+	}
+	return map[string]interface{}{"breakpoints": results}, nil
+}
+
+// addBreakpoint adds a breakpoint at addr, with an optional condition.
+func (s *Server) addBreakpoint(addr uint16, condition string) (*debugger.Breakpoint, error) {
+	var cond *debugger.Expr
+	if condition != "" {
+		var err error
+		if cond, err = debugger.ParseExpr(condition); err != nil {
+			return nil, err
+		}
+	}
+	return s.debugger.Break(addr, cond), nil
+}
+
+// sourceLine returns the source line the instruction at addr was built from,
+// if the Chip8 has Symbols that know it.
+func (s *Server) sourceLine(addr uint16) (symbols.Source, bool) {
+	if s.c8.Symbols == nil {
+		return symbols.Source{}, false
+	}
+	return s.c8.Symbols.Line(addr)
+}
+
+// stackTrace returns a frame for the program counter, and one for each return
+// address on the call stack.
+func (s *Server) stackTrace() interface{} {
+	frames := []map[string]interface{}{}
+	for i, f := range debugger.Backtrace(s.c8) {
+		frame := map[string]interface{}{
+			"id":                          i,
+			"name":                        f.Name,
+			"line":                        0,
+			"column":                      0,
+			"instructionPointerReference": fmt.Sprintf("0x%03X", f.Addr),
+		}
+		if src, ok := s.sourceLine(f.Addr); ok {
+			frame["line"] = src.Line
+			frame["column"] = 1
+			frame["source"] = map[string]interface{}{
+				"name": filepath.Base(src.File),
+				"path": absPath(src.File),
+			}
+		} else if s.listing != nil {
+			if line := s.listing.line(f.Addr); line != 0 {
+				frame["line"] = line
+				frame["column"] = 1
+				frame["source"] = map[string]interface{}{
+					"name":            s.listing.name,
+					"sourceReference": listingRef,
+				}
+			}
+		}
+		frames = append(frames, frame)
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}
+}
+
+func (s *Server) variables(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	regs := s.c8.Registers()
+	vars := []map[string]interface{}{}
+	variable := func(name, value string) map[string]interface{} {
+		v := map[string]interface{}{"name": name, "value": value, "variablesReference": 0}
+		vars = append(vars, v)
+		return v
+	}
+	switch args.VariablesReference {
+	case registersRef:
+		for i, v := range regs.V {
+			variable(fmt.Sprintf("V%X", i), fmt.Sprintf("0x%02X", v))
+		}
+		variable("I", fmt.Sprintf("0x%03X", regs.I))["memoryReference"] = fmt.Sprintf("0x%03X", regs.I)
+		variable("PC", fmt.Sprintf("0x%03X", regs.PC))["memoryReference"] = fmt.Sprintf("0x%03X", regs.PC)
+		variable("SP", strconv.Itoa(len(regs.Stack)))
+	case timersRef:
+		variable("DT", fmt.Sprintf("0x%02X", regs.DelayTimer))
+		variable("ST", fmt.Sprintf("0x%02X", regs.SoundTimer))
+	default:
+		return nil, fmt.Errorf("unknown variables reference %d", args.VariablesReference)
+	}
+	return map[string]interface{}{"variables": vars}, nil
+}
+
+func (s *Server) readMemory(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		MemoryReference string `json:"memoryReference"`
+		Offset          int    `json:"offset"`
+		Count           int    `json:"count"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	addr, err := parseReference(args.MemoryReference, args.Offset)
+	if err != nil {
+		return nil, err
+	}
+	n := args.Count
+	if max := s.c8.MemorySize() - int(addr); n > max {
+		n = max
+	}
+	if n < 0 {
+		n = 0
+	}
+	data, err := s.c8.ReadMemory(addr, n)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"address":         fmt.Sprintf("0x%03X", addr),
+		"data":            base64.StdEncoding.EncodeToString(data),
+		"unreadableBytes": args.Count - n,
+	}, nil
+}
+
+// disassemble disassembles instructionCount instructions, starting
+// instructionOffset instructions from the memory reference. Instructions are
+// taken to be two bytes apart, apart from F000 nnnn.
+func (s *Server) disassemble(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		MemoryReference   string `json:"memoryReference"`
+		Offset            int    `json:"offset"`
+		InstructionOffset int    `json:"instructionOffset"`
+		InstructionCount  int    `json:"instructionCount"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	start, err := parseReference(args.MemoryReference, args.Offset)
+	if err != nil {
+		return nil, err
+	}
+	addr := int(start) + args.InstructionOffset*2
+	instrs := []map[string]interface{}{}
+	for i := 0; i < args.InstructionCount; i++ {
+		instr := map[string]interface{}{"address": fmt.Sprintf("0x%03X", addr)}
+		instrs = append(instrs, instr)
+		if addr < 0 || addr+2 > s.c8.MemorySize() {
+			instr["instruction"] = "??"
+			addr += 2
+			continue
+		}
+		n := 4
+		if addr+n > s.c8.MemorySize() {
+			n = 2
+		}
+		data, _ := s.c8.ReadMemory(uint16(addr), n)
+		builder := chip8.Disassemble(data, uint16(addr))
+		fields := strings.SplitN(strings.SplitN(builder.String(), "\n", 2)[0], "   ", 3)
+		instr["instructionBytes"] = fields[1]
+		instr["instruction"] = fields[2]
+		if s.listing != nil {
+			if line := s.listing.line(uint16(addr)); line != 0 {
+				instr["line"] = line
+				instr["location"] = map[string]interface{}{"name": s.listing.name, "sourceReference": listingRef}
+			}
+		}
+		addr += len(fields[1]) / 2
+	}
+	return map[string]interface{}{"instructions": instrs}, nil
+}
+
+// evaluate evaluates a debugger expression, such as V3 + 1 or [I]. What's
+// typed in the debug console is run as a console command instead.
+func (s *Server) evaluate(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		Expression string `json:"expression"`
+		Context    string `json:"context"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	if args.Context == "repl" {
+		var out strings.Builder
+		if err := s.console.Exec(&out, args.Expression); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"result":             strings.TrimRight(out.String(), "\n"),
+			"variablesReference": 0,
+		}, nil
+	}
+	expr, err := debugger.ParseExpr(args.Expression)
+	if err != nil {
+		return nil, err
+	}
+	v, err := expr.Eval(s.c8)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"result":             fmt.Sprintf("0x%X (%d)", v, v),
+		"variablesReference": 0,
+	}, nil
+}
+
+// parseReference parses a memory or instruction reference, which is an
+// address in decimal or in hex with a leading 0x, and adds offset to it.
+func parseReference(ref string, offset int) (uint16, error) {
+	addr, err := strconv.ParseInt(ref, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("bad reference %q", ref)
+	}
+	addr += int64(offset)
+	if addr < 0 || addr > 0xFFFF {
+		return 0, fmt.Errorf("reference %q + %d out of range", ref, offset)
+	}
+	return uint16(addr), nil
+}
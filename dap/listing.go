@@ -0,0 +1,86 @@
+package dap
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/symbols"
+)
+
+// listing is a source the client can show and set breakpoints in: the
+// disassembly of the program, one instruction per line.
+type listing struct {
+	name    string
+	content string
+	addrs   []uint16
+	lines   map[uint16]int
+}
+
+// newListing disassembles program, as loaded at chip8.PROGRAM_OFFSET.
+func newListing(name string, program []byte) *listing {
+	builder := chip8.Disassemble(program, chip8.PROGRAM_OFFSET)
+	l := &listing{name: name, content: builder.String(), lines: map[uint16]int{}}
+	for i, line := range strings.Split(strings.TrimRight(l.content, "\n"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		addr, err := strconv.ParseUint(fields[0], 0, 16)
+		if err != nil {
+			continue
+		}
+		l.addrs = append(l.addrs, uint16(addr))
+		l.lines[uint16(addr)] = i + 1
+	}
+	return l
+}
+
+// addr returns the address of the instruction on line, counting from 1.
+func (l *listing) addr(line int) (uint16, bool) {
+	if line < 1 || line > len(l.addrs) {
+		return 0, false
+	}
+	return l.addrs[line-1], true
+}
+
+// line returns the line, counting from 1, of the instruction at addr, or 0 if
+// there isn't one.
+func (l *listing) line(addr uint16) int {
+	return l.lines[addr]
+}
+
+// sourceAddr returns the address of the first instruction built from line of
+// the source file path, or if there isn't one the first instruction on a later
+// line of the file, along with the line it's on.
+func sourceAddr(table *symbols.Table, path string, line int) (uint16, int, bool) {
+	var addr uint16
+	found := 0
+	for _, a := range table.Lines() {
+		src, _ := table.Line(a)
+		if src.Line < line || !sameFile(src.File, path) {
+			continue
+		}
+		if found == 0 || src.Line < found {
+			addr, found = a, src.Line
+		}
+	}
+	return addr, found, found != 0
+}
+
+// sameFile reports whether the file named in a symbol table, which is usually
+// relative to where the program was built, is the one at path.
+func sameFile(file, path string) bool {
+	if absPath(file) == absPath(path) {
+		return true
+	}
+	return !filepath.IsAbs(file) && strings.HasSuffix(absPath(path), string(filepath.Separator)+filepath.Clean(file))
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
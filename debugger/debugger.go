@@ -0,0 +1,306 @@
+// Package debugger adds breakpoints and watchpoints to a Chip8. It hooks into
+// Chip8.Break, so a running Chip8 stops before the instruction after a hit and
+// Run reports why on its error channel.
+package debugger
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/zabrahams/gochip8/chip8"
+)
+
+// ErrNoBreakpoint is returned when deleting a breakpoint that doesn't exist.
+var ErrNoBreakpoint = errors.New("no such breakpoint")
+
+// ErrNoJournal is returned when stepping backwards through a Chip8 that
+// doesn't have a Journal to undo instructions with.
+var ErrNoJournal = errors.New("no journal to step back through")
+
+// ErrNoHistory is returned when stepping back through the frames of a Chip8
+// that doesn't have a History of them.
+var ErrNoHistory = errors.New("no rewind history to step back through")
+
+// ErrNoProvenance is returned when breaking on self-modifying code in a Chip8
+// that doesn't have a Provenance to tell which code was written at runtime.
+var ErrNoProvenance = errors.New("no provenance to find self-modifying code with")
+
+// Kind is the kind of thing a Breakpoint stops on.
+type Kind int
+
+const (
+	// BreakPC stops before the instruction at Addr is executed.
+	BreakPC Kind = iota
+	// BreakCond stops before any instruction when Cond is true.
+	BreakCond
+	// WatchRead stops after an instruction reads memory in [Addr, Addr+Len).
+	WatchRead
+	// WatchWrite stops after an instruction writes memory in [Addr, Addr+Len).
+	WatchWrite
+	// WatchAccess stops after an instruction reads or writes memory in
+	// [Addr, Addr+Len).
+	WatchAccess
+	// WatchValue stops after an instruction changes the value of Value, e.g.
+	// a register.
+	WatchValue
+	// BreakSelfModifying stops before executing an instruction that was
+	// written by another instruction.
+	BreakSelfModifying
+)
+
+func (k Kind) String() string {
+	switch k {
+	case BreakPC:
+		return "breakpoint"
+	case BreakCond:
+		return "conditional breakpoint"
+	case WatchRead:
+		return "read watchpoint"
+	case WatchWrite:
+		return "write watchpoint"
+	case WatchAccess:
+		return "access watchpoint"
+	case BreakSelfModifying:
+		return "self-modifying code breakpoint"
+	default:
+		return "watchpoint"
+	}
+}
+
+// Breakpoint is a breakpoint or watchpoint. If Cond is set the breakpoint only
+// stops the Chip8 when Cond is true as well.
+type Breakpoint struct {
+	ID    int
+	Kind  Kind
+	Addr  uint16
+	Len   int
+	Value *Expr
+	Cond  *Expr
+	Hits  int
+	last  int
+}
+
+func (bp *Breakpoint) String() string {
+	var s string
+	switch bp.Kind {
+	case BreakPC:
+		s = fmt.Sprintf("%d: %v at 0x%03X", bp.ID, bp.Kind, bp.Addr)
+	case BreakCond, BreakSelfModifying:
+		s = fmt.Sprintf("%d: %v", bp.ID, bp.Kind)
+	case WatchValue:
+		s = fmt.Sprintf("%d: %v on %v", bp.ID, bp.Kind, bp.Value)
+	default:
+		s = fmt.Sprintf("%d: %v on 0x%03X-0x%03X", bp.ID, bp.Kind, bp.Addr, int(bp.Addr)+bp.Len-1)
+	}
+	if bp.Cond != nil {
+		s += fmt.Sprintf(" if %v", bp.Cond)
+	}
+	return s
+}
+
+// Hit describes why a Debugger stopped the Chip8. It wraps chip8.ErrBreak.
+// Breakpoint is nil if the Chip8 stopped because of the condition given to
+// Until.
+type Hit struct {
+	Breakpoint *Breakpoint
+	Reason     string
+}
+
+func (h *Hit) Error() string {
+	return fmt.Sprintf("%v: %s", chip8.ErrBreak, h.Reason)
+}
+
+func (h *Hit) Unwrap() error {
+	return chip8.ErrBreak
+}
+
+// Debugger keeps a set of breakpoints and watchpoints for a Chip8.
+// Breakpoints must only be added and deleted while the Chip8 isn't running.
+type Debugger struct {
+	c8          *chip8.Chip8
+	breakpoints []*Breakpoint
+	nextID      int
+	checked     uint64
+	started     bool
+	until       func(c8 *chip8.Chip8) bool
+	untilReason string
+}
+
+// New returns a Debugger for c8 and sets c8.Break to check its breakpoints.
+func New(c8 *chip8.Chip8) *Debugger {
+	d := &Debugger{c8: c8, nextID: 1}
+	c8.Break = d.check
+	return d
+}
+
+// Breakpoints returns the breakpoints in the order they were added.
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	return append([]*Breakpoint{}, d.breakpoints...)
+}
+
+// Break adds a breakpoint on the instruction at addr. cond may be nil.
+func (d *Debugger) Break(addr uint16, cond *Expr) *Breakpoint {
+	return d.add(&Breakpoint{Kind: BreakPC, Addr: addr, Cond: cond})
+}
+
+// BreakIf adds a breakpoint that stops before any instruction when cond is
+// true.
+func (d *Debugger) BreakIf(cond *Expr) *Breakpoint {
+	return d.add(&Breakpoint{Kind: BreakCond, Cond: cond})
+}
+
+// BreakSelfModifying adds a breakpoint that stops before executing code that
+// was written at runtime. The Chip8 must have a Provenance.
+func (d *Debugger) BreakSelfModifying(cond *Expr) (*Breakpoint, error) {
+	if d.c8.Provenance == nil {
+		return nil, ErrNoProvenance
+	}
+	return d.add(&Breakpoint{Kind: BreakSelfModifying, Cond: cond}), nil
+}
+
+// Watch adds a watchpoint on the n bytes of memory at addr. kind must be
+// WatchRead, WatchWrite or WatchAccess.
+func (d *Debugger) Watch(kind Kind, addr uint16, n int, cond *Expr) (*Breakpoint, error) {
+	if kind != WatchRead && kind != WatchWrite && kind != WatchAccess {
+		return nil, fmt.Errorf("%v isn't a memory watchpoint", kind)
+	}
+	if n < 1 || int(addr)+n > d.c8.MemorySize() {
+		return nil, fmt.Errorf("watch 0x%03X-0x%03X: %w", addr, int(addr)+n-1, chip8.ErrMemoryFault)
+	}
+	return d.add(&Breakpoint{Kind: kind, Addr: addr, Len: n, Cond: cond}), nil
+}
+
+// WatchValue adds a watchpoint that stops when the value of value changes.
+func (d *Debugger) WatchValue(value, cond *Expr) (*Breakpoint, error) {
+	last, err := value.Eval(d.c8)
+	if err != nil {
+		return nil, err
+	}
+	return d.add(&Breakpoint{Kind: WatchValue, Value: value, Cond: cond, last: last}), nil
+}
+
+// Delete deletes the breakpoint with the given ID.
+func (d *Debugger) Delete(id int) error {
+	for i, bp := range d.breakpoints {
+		if bp.ID == id {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %d", ErrNoBreakpoint, id)
+}
+
+// Until sets a one off condition that's checked before every instruction along
+// with the breakpoints. When it's true the Chip8 stops, with reason as the
+// reason. The condition is cleared once the Chip8 stops for any reason other
+// than Stop, and can be cleared early by passing a nil cond.
+func (d *Debugger) Until(reason string, cond func(c8 *chip8.Chip8) bool) {
+	d.until, d.untilReason = cond, reason
+}
+
+func (d *Debugger) add(bp *Breakpoint) *Breakpoint {
+	bp.ID = d.nextID
+	d.nextID++
+	d.breakpoints = append(d.breakpoints, bp)
+	return bp
+}
+
+// check is the Chip8's Break hook. It's called before every instruction, so
+// watchpoints are checked against the instruction that's just run. Each
+// instruction is only checked once, so resuming after a hit doesn't stop at
+// the same place again.
+func (d *Debugger) check(c8 *chip8.Chip8) error {
+	cycles := c8.Cycles()
+	if d.started && cycles == d.checked {
+		return nil
+	}
+	ran := d.started
+	d.started, d.checked = true, cycles
+	return d.match(c8, ran)
+}
+
+// match returns a *Hit for the breakpoints that stop c8 where it is, or nil
+// if none do. Memory watchpoints are only checked if ran is set, since
+// otherwise nothing has run for them to have been hit by.
+func (d *Debugger) match(c8 *chip8.Chip8, ran bool) error {
+	var hits []*Hit
+	pc := c8.Registers().PC
+	for _, bp := range d.breakpoints {
+		var reason string
+		switch bp.Kind {
+		case BreakPC:
+			if pc == bp.Addr {
+				reason = fmt.Sprintf("%v %d at 0x%03X", bp.Kind, bp.ID, pc)
+			}
+		case BreakCond:
+			reason = fmt.Sprintf("%v %d", bp.Kind, bp.ID)
+		case BreakSelfModifying:
+			if c8.Provenance == nil {
+				break
+			}
+			if origin, ok := c8.Provenance.SelfModified(pc, 2); ok {
+				reason = fmt.Sprintf("%v %d: 0x%03X was %v", bp.Kind, bp.ID, pc, origin)
+			}
+		case WatchValue:
+			v, err := bp.Value.Eval(c8)
+			if err != nil {
+				return &Hit{Breakpoint: bp, Reason: fmt.Sprintf("%v %d: %v", bp.Kind, bp.ID, err)}
+			}
+			if v != bp.last {
+				reason = fmt.Sprintf("%v %d: %v changed from 0x%X to 0x%X", bp.Kind, bp.ID, bp.Value, bp.last, v)
+				bp.last = v
+			}
+		default:
+			if ran {
+				reason = d.checkAccesses(bp, c8.Accesses())
+			}
+		}
+		if reason == "" {
+			continue
+		}
+		if bp.Cond != nil {
+			v, err := bp.Cond.Eval(c8)
+			if err != nil {
+				return &Hit{Breakpoint: bp, Reason: fmt.Sprintf("%v %d: %v", bp.Kind, bp.ID, err)}
+			}
+			if v == 0 {
+				continue
+			}
+			reason += fmt.Sprintf(" (%v)", bp.Cond)
+		}
+		bp.Hits++
+		hits = append(hits, &Hit{Breakpoint: bp, Reason: reason})
+	}
+	if d.until != nil && d.until(c8) {
+		hits = append(hits, &Hit{Reason: d.untilReason})
+	}
+	if len(hits) == 0 {
+		return nil
+	}
+	d.until = nil
+	// Report the oldest breakpoint, but mention the rest.
+	hit := hits[0]
+	for _, other := range hits[1:] {
+		hit.Reason += "; " + other.Reason
+	}
+	return hit
+}
+
+// checkAccesses returns why the memory watchpoint bp was hit by accesses, or
+// "" if it wasn't.
+func (d *Debugger) checkAccesses(bp *Breakpoint, accesses []chip8.MemoryAccess) string {
+	for _, a := range accesses {
+		if a.Write && bp.Kind == WatchRead || !a.Write && bp.Kind == WatchWrite {
+			continue
+		}
+		if int(a.Addr) >= int(bp.Addr)+bp.Len || int(a.Addr)+a.Len <= int(bp.Addr) {
+			continue
+		}
+		op := "read"
+		if a.Write {
+			op = "write"
+		}
+		return fmt.Sprintf("%v %d: %s of 0x%03X-0x%03X", bp.Kind, bp.ID, op, a.Addr, int(a.Addr)+a.Len-1)
+	}
+	return ""
+}
@@ -0,0 +1,359 @@
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/zabrahams/gochip8/chip8"
+)
+
+// Expr is a parsed debugger expression, such as `V3 == 0x10 && I > 0x300`.
+//
+// Operands are numbers (decimal, or hex with 0x), the registers V0 to VF, I,
+// PC, SP (the depth of the call stack), DT and ST, and [addr], the byte of
+// memory at addr. The operators are, from loosest to tightest binding:
+//
+//	||
+//	&&
+//	== != < <= > >=
+//	| ^
+//	&
+//	<< >>
+//	+ -
+//	! ~ - (unary)
+//
+// Comparisons and logical operators evaluate to 1 or 0, and anything that
+// isn't 0 is true.
+type Expr struct {
+	text string
+	root node
+}
+
+// ParseExpr parses an expression.
+func ParseExpr(text string) (*Expr, error) {
+	p := &parser{text: text}
+	p.next()
+	root, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+	return &Expr{text: text, root: root}, nil
+}
+
+func (e *Expr) String() string {
+	return e.text
+}
+
+// Eval evaluates the expression against the current state of c8, which must
+// not be running.
+func (e *Expr) Eval(c8 *chip8.Chip8) (int, error) {
+	return e.root.eval(c8)
+}
+
+type node interface {
+	eval(c8 *chip8.Chip8) (int, error)
+}
+
+type number int
+
+func (n number) eval(c8 *chip8.Chip8) (int, error) {
+	return int(n), nil
+}
+
+type register string
+
+func (r register) eval(c8 *chip8.Chip8) (int, error) {
+	regs := c8.Registers()
+	switch r {
+	case "I":
+		return int(regs.I), nil
+	case "PC":
+		return int(regs.PC), nil
+	case "SP":
+		return len(regs.Stack), nil
+	case "DT":
+		return int(regs.DelayTimer), nil
+	case "ST":
+		return int(regs.SoundTimer), nil
+	}
+	x, _ := strconv.ParseUint(string(r[1:]), 16, 8)
+	return int(regs.V[x]), nil
+}
+
+type memory struct {
+	addr node
+}
+
+func (m memory) eval(c8 *chip8.Chip8) (int, error) {
+	addr, err := m.addr.eval(c8)
+	if err != nil {
+		return 0, err
+	}
+	if addr < 0 || addr > 0xFFFF {
+		return 0, fmt.Errorf("address 0x%X out of range", addr)
+	}
+	b, err := c8.ReadMemory(uint16(addr), 1)
+	if err != nil {
+		return 0, fmt.Errorf("address 0x%X: %w", addr, err)
+	}
+	return int(b[0]), nil
+}
+
+type unary struct {
+	op      string
+	operand node
+}
+
+func (u unary) eval(c8 *chip8.Chip8) (int, error) {
+	v, err := u.operand.eval(c8)
+	if err != nil {
+		return 0, err
+	}
+	switch u.op {
+	case "!":
+		return truth(v == 0), nil
+	case "~":
+		return ^v, nil
+	default:
+		return -v, nil
+	}
+}
+
+type binary struct {
+	op          string
+	left, right node
+}
+
+func (b binary) eval(c8 *chip8.Chip8) (int, error) {
+	l, err := b.left.eval(c8)
+	if err != nil {
+		return 0, err
+	}
+	// && and || short circuit, so [addr] on the right is only read if needed.
+	switch {
+	case b.op == "&&" && l == 0:
+		return 0, nil
+	case b.op == "||" && l != 0:
+		return 1, nil
+	}
+	r, err := b.right.eval(c8)
+	if err != nil {
+		return 0, err
+	}
+	switch b.op {
+	case "&&", "||":
+		return truth(r != 0), nil
+	case "==":
+		return truth(l == r), nil
+	case "!=":
+		return truth(l != r), nil
+	case "<":
+		return truth(l < r), nil
+	case "<=":
+		return truth(l <= r), nil
+	case ">":
+		return truth(l > r), nil
+	case ">=":
+		return truth(l >= r), nil
+	case "|":
+		return l | r, nil
+	case "^":
+		return l ^ r, nil
+	case "&":
+		return l & r, nil
+	case "<<":
+		return l << uint(r&0x1F), nil
+	case ">>":
+		return l >> uint(r&0x1F), nil
+	case "+":
+		return l + r, nil
+	default:
+		return l - r, nil
+	}
+}
+
+func truth(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// precedence lists the binary operators from loosest to tightest binding.
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"|", "^"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+}
+
+// operators is every operator, longest first so that the lexer matches
+// greedily.
+var operators = []string{
+	"||", "&&", "==", "!=", "<=", ">=", "<<", ">>",
+	"<", ">", "|", "^", "&", "+", "-", "!", "~", "(", ")", "[", "]",
+}
+
+const (
+	tokEOF = iota
+	tokNumber
+	tokName
+	tokOp
+)
+
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+type parser struct {
+	text string
+	pos  int
+	tok  token
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("bad expression %q at column %d: %s", p.text, p.tok.pos+1, fmt.Sprintf(format, args...))
+}
+
+// next reads the next token into p.tok.
+func (p *parser) next() {
+	for p.pos < len(p.text) && p.text[p.pos] == ' ' {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.text) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return
+	}
+	c := rune(p.text[p.pos])
+	if unicode.IsLetter(c) || unicode.IsDigit(c) {
+		for p.pos < len(p.text) && (unicode.IsLetter(rune(p.text[p.pos])) || unicode.IsDigit(rune(p.text[p.pos]))) {
+			p.pos++
+		}
+		kind := tokName
+		if unicode.IsDigit(c) {
+			kind = tokNumber
+		}
+		p.tok = token{kind: kind, text: p.text[start:p.pos], pos: start}
+		return
+	}
+	for _, op := range operators {
+		if strings.HasPrefix(p.text[p.pos:], op) {
+			p.pos += len(op)
+			p.tok = token{kind: tokOp, text: op, pos: start}
+			return
+		}
+	}
+	// Let the parser report the stray character.
+	p.pos++
+	p.tok = token{kind: tokOp, text: p.text[start:p.pos], pos: start}
+}
+
+// parseBinary parses a run of operands joined by the operators at level of
+// the precedence table or tighter.
+func (p *parser) parseBinary(level int) (node, error) {
+	if level == len(precedence) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOp && contains(precedence[level], p.tok.text) {
+		op := p.tok.text
+		p.next()
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.tok.kind == tokOp && (p.tok.text == "!" || p.tok.text == "~" || p.tok.text == "-") {
+		op := p.tok.text
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unary{op: op, operand: operand}, nil
+	}
+	return p.parseOperand()
+}
+
+func (p *parser) parseOperand() (node, error) {
+	tok := p.tok
+	switch {
+	case tok.kind == tokNumber:
+		n, err := strconv.ParseInt(tok.text, 0, 32)
+		if err != nil {
+			return nil, p.errorf("bad number %q", tok.text)
+		}
+		p.next()
+		return number(n), nil
+	case tok.kind == tokName:
+		name := strings.ToUpper(tok.text)
+		if !isRegister(name) {
+			return nil, p.errorf("unknown register %q", tok.text)
+		}
+		p.next()
+		return register(name), nil
+	case tok.kind == tokOp && (tok.text == "(" || tok.text == "["):
+		closing := ")"
+		if tok.text == "[" {
+			closing = "]"
+		}
+		p.next()
+		inner, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokOp || p.tok.text != closing {
+			return nil, p.errorf("expected %q", closing)
+		}
+		p.next()
+		if closing == "]" {
+			return memory{addr: inner}, nil
+		}
+		return inner, nil
+	case tok.kind == tokEOF:
+		return nil, p.errorf("unexpected end of expression")
+	default:
+		return nil, p.errorf("unexpected %q", tok.text)
+	}
+}
+
+// isRegister reports whether name, in upper case, names a register.
+func isRegister(name string) bool {
+	switch name {
+	case "I", "PC", "SP", "DT", "ST":
+		return true
+	}
+	if len(name) != 2 || name[0] != 'V' {
+		return false
+	}
+	_, err := strconv.ParseUint(name[1:], 16, 8)
+	return err == nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
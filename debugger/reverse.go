@@ -0,0 +1,62 @@
+package debugger
+
+import "fmt"
+
+// ReverseStep undoes the last instruction executed, using the Chip8's
+// Journal. The Chip8 must not be running.
+func (d *Debugger) ReverseStep() error {
+	if d.c8.Journal == nil {
+		return ErrNoJournal
+	}
+	err := d.c8.Journal.StepBack(d.c8)
+	d.stoppedAt()
+	return err
+}
+
+// StepBack goes back to the end of the last frame before where the Chip8 is,
+// through the same History of frames that rewinding uses. It reaches further
+// back than the Journal does, but a frame at a time. The Chip8 must not be
+// running.
+func (d *Debugger) StepBack() error {
+	if d.c8.History == nil {
+		return ErrNoHistory
+	}
+	err := d.c8.History.StepBack(d.c8)
+	d.stoppedAt()
+	return err
+}
+
+// ReverseContinue undoes instructions until a breakpoint would have stopped
+// the Chip8, and returns a *Hit for it. A memory watchpoint stops it before
+// the instruction that accessed the memory, so that it's easy to see who
+// wrote a byte. If the start of the Journal is reached first
+// chip8.ErrNoHistory is returned. The Chip8 must not be running.
+func (d *Debugger) ReverseContinue() error {
+	if d.c8.Journal == nil {
+		return ErrNoJournal
+	}
+	defer d.stoppedAt()
+	for {
+		if err := d.c8.Journal.StepBack(d.c8); err != nil {
+			return fmt.Errorf("reached the start of the history: %w", err)
+		}
+		if err := d.match(d.c8, true); err != nil {
+			return err
+		}
+	}
+}
+
+// stoppedAt is called once the Chip8 has been moved somewhere other than by
+// running it. It marks where it is as checked, so that continuing from there
+// doesn't stop straight away, and catches the value watchpoints up with the
+// values there.
+func (d *Debugger) stoppedAt() {
+	d.started, d.checked = true, d.c8.Cycles()
+	for _, bp := range d.breakpoints {
+		if bp.Kind == WatchValue {
+			if v, err := bp.Value.Eval(d.c8); err == nil {
+				bp.last = v
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package debugger
+
+import (
+	"fmt"
+
+	"github.com/zabrahams/gochip8/chip8"
+)
+
+// OverCall returns a condition for Until that's true once the subroutine
+// called by the CALL at the program counter has returned, or nil if the
+// instruction at the program counter isn't a CALL.
+func OverCall(c8 *chip8.Chip8) func(c8 *chip8.Chip8) bool {
+	regs := c8.Registers()
+	instr, err := c8.ReadMemory(regs.PC, 2)
+	if err != nil || instr[0]>>4 != 0x2 {
+		return nil
+	}
+	ret, depth := regs.PC+2, len(regs.Stack)
+	return func(c8 *chip8.Chip8) bool {
+		regs := c8.Registers()
+		return regs.PC == ret && len(regs.Stack) == depth
+	}
+}
+
+// OutOfCall returns a condition for Until that's true once the current
+// subroutine has returned, or nil if the Chip8 isn't in a subroutine.
+func OutOfCall(c8 *chip8.Chip8) func(c8 *chip8.Chip8) bool {
+	depth := len(c8.Registers().Stack)
+	if depth == 0 {
+		return nil
+	}
+	return func(c8 *chip8.Chip8) bool {
+		return len(c8.Registers().Stack) < depth
+	}
+}
+
+// Frame is a frame of a backtrace: the address the frame is at, and the name
+// of the subroutine it's in.
+type Frame struct {
+	Addr uint16
+	Name string
+}
+
+// Backtrace returns a frame for the program counter, and one for each CALL on
+// the call stack, innermost first. Subroutines are named by their label in the
+// Chip8's Symbols, or else sub_ and their address, found from the CALL that
+// entered them, and the outermost frame is named main.
+func Backtrace(c8 *chip8.Chip8) []Frame {
+	regs := c8.Registers()
+	frames := []Frame{{Addr: regs.PC, Name: "main"}}
+	for i := len(regs.Stack) - 1; i >= 0; i-- {
+		call := regs.Stack[i] - 2
+		frames[len(frames)-1].Name = "?"
+		if instr, err := c8.ReadMemory(call, 2); err == nil && instr[0]>>4 == 0x2 {
+			frames[len(frames)-1].Name = subName(c8, uint16(instr[0]&0xF)<<8|uint16(instr[1]))
+		}
+		frames = append(frames, Frame{Addr: call, Name: "main"})
+	}
+	return frames
+}
+
+// subName returns the name of the subroutine at addr.
+func subName(c8 *chip8.Chip8, addr uint16) string {
+	if c8.Symbols != nil {
+		if name, ok := c8.Symbols.Label(addr); ok {
+			return name
+		}
+	}
+	return fmt.Sprintf("sub_%03X", addr)
+}
+
+// Step executes the one instruction at the program counter, and returns a
+// *Hit if it hit a watchpoint or stopped on a breakpoint, as running it would
+// have. Either way the debugger carries on from where it stops, so continuing
+// doesn't stop there again. The Chip8 must not be running.
+func (d *Debugger) Step() error {
+	defer d.stoppedAt()
+	if err := d.c8.ExecInstr(); err != nil {
+		return err
+	}
+	return d.match(d.c8, true)
+}
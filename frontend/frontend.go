@@ -0,0 +1,68 @@
+// Package frontend keeps a registry of the frontends that connect a Chip8 to
+// a display, input and audio. A frontend registers itself, usually from an
+// init function, so that a program only gets the frontends, and their
+// dependencies, that it imports.
+package frontend
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/zabrahams/gochip8/chip8"
+)
+
+// Frontend is a display, input source and audio sink that work together.
+type Frontend struct {
+	Display chip8.Display
+	Input   chip8.InputSource
+	Audio   chip8.AudioSink
+}
+
+// Close closes the frontend's audio sink and display, and its input source if
+// it has a Close method.
+func (f *Frontend) Close() {
+	f.Audio.Close()
+	f.Display.Close()
+	if input, ok := f.Input.(interface{ Close() }); ok {
+		input.Close()
+	}
+}
+
+// Factory creates a frontend.
+type Factory func() (*Frontend, error)
+
+var factories = map[string]Factory{}
+
+// Register makes a frontend available under name. It panics if the name is
+// already taken.
+func Register(name string, f Factory) {
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("frontend %s registered twice", name))
+	}
+	factories[name] = f
+}
+
+// New creates the frontend registered under name.
+func New(name string) (*Frontend, error) {
+	f, ok := factories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown frontend: %s", name)
+	}
+	return f()
+}
+
+// Names returns the names of every registered frontend in sorted order.
+func Names() []string {
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Registered reports whether a frontend is registered under name.
+func Registered(name string) bool {
+	_, ok := factories[name]
+	return ok
+}
@@ -0,0 +1,28 @@
+package frontend
+
+import "github.com/zabrahams/gochip8/chip8"
+
+// The null frontend shows nothing, plays nothing and never has any input.
+func init() {
+	Register("null", func() (*Frontend, error) {
+		return &Frontend{Display: NullDisplay{}, Input: NullInput{}, Audio: NullAudio{}}, nil
+	})
+}
+
+type NullDisplay struct{}
+
+func (NullDisplay) Update(fb *chip8.FrameBuffer) {}
+
+func (NullDisplay) Close() {}
+
+type NullInput struct{}
+
+func (NullInput) Poll() chip8.InputState {
+	return chip8.InputState{}
+}
+
+type NullAudio struct{}
+
+func (NullAudio) Beep() {}
+
+func (NullAudio) Close() {}
@@ -0,0 +1,112 @@
+// Package sdlfrontend registers the SDL frontend, which draws to a window,
+// reads the keyboard and plays sound with SDL.
+package sdlfrontend
+
+import (
+	"github.com/veandco/go-sdl2/sdl"
+	"github.com/zabrahams/gochip8/beeper"
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/frontend"
+	"github.com/zabrahams/gochip8/screen"
+)
+
+func init() {
+	frontend.Register("sdl", func() (*frontend.Frontend, error) {
+		// The screen initializes SDL, so it has to be created first.
+		s := screen.NewScreen()
+		return &frontend.Frontend{Display: s, Input: Input{}, Audio: beeper.NewSDLBeeper()}, nil
+	})
+}
+
+// Input reads the keyboard with SDL. The keypad is mapped to the left hand
+// side of a qwerty keyboard:
+//
+//	1 2 3 4
+//	Q W E R
+//	A S D F
+//	Z X C V
+//
+// F5 and F9 save and load the state, '.' pauses and backspace rewinds.
+type Input struct{}
+
+func (Input) Poll() chip8.InputState {
+	var state chip8.InputState
+	for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
+		switch event.(type) {
+		case *sdl.QuitEvent:
+			state.Quit = true
+		case *sdl.KeyboardEvent:
+			kevent := event.(*sdl.KeyboardEvent)
+			if kevent.Type != sdl.KEYUP {
+				break
+			}
+			switch kevent.Keysym.Sym {
+			case sdl.K_F5:
+				state.Hotkeys = append(state.Hotkeys, chip8.HotkeySaveState)
+			case sdl.K_F9:
+				state.Hotkeys = append(state.Hotkeys, chip8.HotkeyLoadState)
+			case sdl.K_PERIOD:
+				state.Hotkeys = append(state.Hotkeys, chip8.HotkeyPause)
+			}
+		}
+	}
+
+	kbState := sdl.GetKeyboardState()
+	state.Keys = parseKbState(kbState)
+	state.Rewind = kbState[sdl.SCANCODE_BACKSPACE] == 1
+	return state
+}
+
+func parseKbState(kbState []uint8) uint16 {
+	var keys uint16
+	keys = 0
+	if kbState[sdl.SCANCODE_1] == 1 {
+		keys = keys | (0x1 << 0)
+	}
+	if kbState[sdl.SCANCODE_2] == 1 {
+		keys = keys | (0x1 << 1)
+	}
+	if kbState[sdl.SCANCODE_3] == 1 {
+		keys = keys | (0x1 << 2)
+	}
+	if kbState[sdl.SCANCODE_4] == 1 {
+		keys = keys | (0x1 << 3)
+	}
+	if kbState[sdl.SCANCODE_Q] == 1 {
+		keys = keys | (0x1 << 4)
+	}
+	if kbState[sdl.SCANCODE_W] == 1 {
+		keys = keys | (0x1 << 5)
+	}
+	if kbState[sdl.SCANCODE_E] == 1 {
+		keys = keys | (0x1 << 6)
+	}
+	if kbState[sdl.SCANCODE_R] == 1 {
+		keys = keys | (0x1 << 7)
+	}
+	if kbState[sdl.SCANCODE_A] == 1 {
+		keys = keys | (0x1 << 8)
+	}
+	if kbState[sdl.SCANCODE_S] == 1 {
+		keys = keys | (0x1 << 9)
+	}
+	if kbState[sdl.SCANCODE_D] == 1 {
+		keys = keys | (0x1 << 10)
+	}
+	if kbState[sdl.SCANCODE_F] == 1 {
+		keys = keys | (0x1 << 11)
+	}
+	if kbState[sdl.SCANCODE_Z] == 1 {
+		keys = keys | (0x1 << 12)
+	}
+	if kbState[sdl.SCANCODE_X] == 1 {
+		keys = keys | (0x1 << 13)
+	}
+	if kbState[sdl.SCANCODE_C] == 1 {
+		keys = keys | (0x1 << 14)
+	}
+	if kbState[sdl.SCANCODE_V] == 1 {
+		keys = keys | (0x1 << 15)
+	}
+	return keys
+}
@@ -0,0 +1,204 @@
+package frontend
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/internal/terminal"
+)
+
+// KEY_HOLD is how long the term frontend counts a key as held after the
+// terminal sends it. Terminals don't report key releases, so a key that's held
+// down stays held through the terminal's key repeat.
+const KEY_HOLD = 200 * time.Millisecond
+
+// The term frontend draws the screen on a terminal with ANSI escape codes,
+// rings the terminal bell for the beeper and reads the keypad from the same
+// keys as sdl. Esc or ctrl-c quits. If stdin isn't a terminal there's no
+// input, and ctrl-c is the only way to quit.
+func init() {
+	Register("term", func() (*Frontend, error) {
+		var input chip8.InputSource = NullInput{}
+		if in, err := NewTermInput(os.Stdin); err != nil {
+			fmt.Fprintf(os.Stderr, "the term frontend has no keypad input: %v\n", err)
+		} else {
+			input = in
+		}
+		return &Frontend{Display: NewTermDisplay(os.Stdout), Input: input, Audio: TermAudio{w: os.Stdout}}, nil
+	})
+}
+
+// TermDisplay draws the screen on a terminal, two rows of pixels to each line
+// of text.
+type TermDisplay struct {
+	w    *bufio.Writer
+	last string
+}
+
+func NewTermDisplay(w io.Writer) *TermDisplay {
+	return &TermDisplay{w: bufio.NewWriter(w)}
+}
+
+// Update redraws the terminal if the screen has changed since the last update.
+func (d *TermDisplay) Update(fb *chip8.FrameBuffer) {
+	current := fb.String()
+	if current == d.last {
+		return
+	}
+	d.last = current
+
+	d.w.WriteString("\x1b[H\x1b[2J")
+	for y := 0; y < fb.Height; y += 2 {
+		for x := 0; x < fb.Width; x++ {
+			top := fb.Pixel(x, y) > 0
+			bottom := y+1 < fb.Height && fb.Pixel(x, y+1) > 0
+			switch {
+			case top && bottom:
+				d.w.WriteString("█")
+			case top:
+				d.w.WriteString("▀")
+			case bottom:
+				d.w.WriteString("▄")
+			default:
+				d.w.WriteString(" ")
+			}
+		}
+		d.w.WriteString("\n")
+	}
+	d.w.Flush()
+}
+
+func (d *TermDisplay) Close() {
+	d.w.Flush()
+}
+
+// TermAudio rings the terminal bell.
+type TermAudio struct {
+	w io.Writer
+}
+
+func (a TermAudio) Beep() {
+	a.w.Write([]byte("\a"))
+}
+
+func (a TermAudio) Close() {}
+
+// TERM_KEYS are the keys that press keypad keys 0 to F, in order, the same as
+// sdl's.
+const TERM_KEYS = "1234qwerasdfzxcv"
+
+// TermInput reads the keypad and hotkeys from a terminal in raw mode: the keys
+// in TERM_KEYS, F5 and F9 to save and load the state, . to pause, backspace
+// to rewind, and Esc or ctrl-c to quit.
+type TermInput struct {
+	restore func()
+	bytes   chan []byte
+	held    map[byte]time.Time
+	quit    bool
+	hotkeys []chip8.Hotkey
+}
+
+// NewTermInput puts the terminal into raw mode, with ctrl-c read as a key
+// rather than interrupting, and starts reading keys from r. It fails if stdin
+// isn't a terminal.
+func NewTermInput(r io.Reader) (*TermInput, error) {
+	restore, err := terminal.RawMode("-isig")
+	if err != nil {
+		return nil, err
+	}
+	in := newTermInput()
+	in.restore = restore
+	go in.read(r)
+	return in, nil
+}
+
+func newTermInput() *TermInput {
+	return &TermInput{bytes: make(chan []byte, 16), held: map[byte]time.Time{}}
+}
+
+func (in *TermInput) read(r io.Reader) {
+	for {
+		buf := make([]byte, 64)
+		n, err := r.Read(buf)
+		if n > 0 {
+			in.bytes <- buf[:n]
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Poll handles the keys read since the last poll.
+func (in *TermInput) Poll() chip8.InputState {
+	for {
+		select {
+		case b := <-in.bytes:
+			in.feed(b, time.Now())
+		default:
+			return in.state(time.Now())
+		}
+	}
+}
+
+// feed handles one read from the terminal at now. An escape sequence is sent
+// all at once, so it's always at the start of a read.
+func (in *TermInput) feed(b []byte, now time.Time) {
+	switch s := string(b); {
+	case s == "\x1b":
+		in.quit = true
+		return
+	case strings.HasPrefix(s, "\x1b[15~"):
+		in.hotkeys = append(in.hotkeys, chip8.HotkeySaveState)
+		return
+	case strings.HasPrefix(s, "\x1b[20~"):
+		in.hotkeys = append(in.hotkeys, chip8.HotkeyLoadState)
+		return
+	case strings.HasPrefix(s, "\x1b"):
+		return
+	}
+	for _, c := range b {
+		switch c {
+		case 0x03:
+			in.quit = true
+		case '.':
+			in.hotkeys = append(in.hotkeys, chip8.HotkeyPause)
+		default:
+			if c >= 'A' && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			in.held[c] = now.Add(KEY_HOLD)
+		}
+	}
+}
+
+// state returns the input at now and clears the hotkeys.
+func (in *TermInput) state(now time.Time) chip8.InputState {
+	state := chip8.InputState{Quit: in.quit, Hotkeys: in.hotkeys}
+	in.hotkeys = nil
+	for c, until := range in.held {
+		if !now.Before(until) {
+			delete(in.held, c)
+			continue
+		}
+		if key := strings.IndexByte(TERM_KEYS, c); key >= 0 {
+			state.Keys |= 1 << key
+		}
+		if c == 0x7F || c == 0x08 {
+			state.Rewind = true
+		}
+	}
+	return state
+}
+
+// Close puts the terminal back the way it was.
+func (in *TermInput) Close() {
+	if in.restore != nil {
+		in.restore()
+	}
+}
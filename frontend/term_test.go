@@ -0,0 +1,44 @@
+package frontend
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zabrahams/gochip8/chip8"
+)
+
+func TestTermInput(t *testing.T) {
+	in := newTermInput()
+	now := time.Now()
+	in.feed([]byte("1V."), now)
+	in.feed([]byte("\x1b[15~"), now)
+	in.feed([]byte("\x7f"), now)
+	want := chip8.InputState{
+		Keys:    1<<0 | 1<<15,
+		Rewind:  true,
+		Hotkeys: []chip8.Hotkey{chip8.HotkeyPause, chip8.HotkeySaveState},
+	}
+	if got := in.state(now); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// The keys stay held for KEY_HOLD after the last time they were sent,
+	// and the hotkeys are only reported once.
+	in.feed([]byte("v"), now.Add(KEY_HOLD/2))
+	want = chip8.InputState{Keys: 1 << 15}
+	if got := in.state(now.Add(KEY_HOLD)); !reflect.DeepEqual(got, want) {
+		t.Errorf("after KEY_HOLD got %+v, want %+v", got, want)
+	}
+	if got := in.state(now.Add(2 * KEY_HOLD)); !reflect.DeepEqual(got, chip8.InputState{}) {
+		t.Errorf("after the keys were released got %+v", got)
+	}
+
+	for _, quit := range []string{"\x1b", "\x03"} {
+		in := newTermInput()
+		in.feed([]byte(quit), now)
+		if !in.state(now).Quit {
+			t.Errorf("%q didn't quit", quit)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/frontend"
+	"github.com/zabrahams/gochip8/gdbserver"
+)
+
+// runGDB serves the rom over the GDB remote protocol, showing the screen and
+// reading the keyboard with the frontend while a client drives it.
+func runGDB(programFile string, opts options) {
+	fmt.Println("Starting Chip8 Emulator")
+
+	fe, err := frontend.New(opts.frontend)
+	if err != nil {
+		panic(err)
+	}
+	defer fe.Close()
+
+	c8 := newChip8(fe.Audio, opts)
+	c8.Provenance = chip8.NewProvenance(c8.MemorySize())
+	var stopCoverage func()
+	c8.Coverage, stopCoverage = startCoverage(opts)
+	defer stopCoverage()
+	c8.Load(programFile)
+	c8.Journal = chip8.NewJournal(chip8.JOURNAL_SIZE)
+	var stopHooks func()
+	c8.Trace, stopHooks = startHooks(programFile, opts)
+	defer stopHooks()
+
+	l, err := net.Listen("tcp", opts.listen)
+	if err != nil {
+		panic(err)
+	}
+	defer l.Close()
+	fmt.Printf("Waiting for gdb on %s\n", l.Addr())
+	server := gdbserver.New(c8)
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(l)
+	}()
+
+	for {
+		select {
+		case err := <-served:
+			fmt.Printf("gdb server stopped: %v\n", err)
+			return
+		default:
+		}
+		input := fe.Input.Poll()
+		if input.Quit {
+			println("Quit")
+			break
+		}
+		if opts.input == nil || !opts.input.Replaying() {
+			c8.Keyboard.Update(input.Keys)
+		}
+		fe.Display.Update(c8.FrameBuffer)
+		time.Sleep(time.Millisecond)
+	}
+	writeRecording(opts)
+	fmt.Println("Closing Chip8 Emulator")
+}
@@ -0,0 +1,421 @@
+// Package gdbserver serves a Chip8 over the GDB Remote Serial Protocol, so
+// that gdb, or anything else that speaks the protocol, can step, continue, set
+// breakpoints and watchpoints, and read and write registers and memory. If
+// the Chip8 has a Journal, gdb can also step and continue backwards.
+//
+// The target has its own description, sent in response to
+// qXfer:features:read, with the registers V0 to VF, I, PC, SP (the depth of
+// the call stack), DT and ST. Memory addresses are Chip8 addresses.
+package gdbserver
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/console"
+	"github.com/zabrahams/gochip8/debugger"
+)
+
+// Signals reported in stop replies.
+const (
+	sigInt  = 0x02
+	sigIll  = 0x04
+	sigTrap = 0x05
+	sigSegv = 0x0B
+)
+
+// Server serves one Chip8 to one client at a time.
+type Server struct {
+	c8       *chip8.Chip8
+	debugger *debugger.Debugger
+	// console runs the commands sent with gdb's monitor command.
+	console *console.Console
+	// breakpoints maps the Z packet type and address of each breakpoint the
+	// client has set to its ID in the debugger.
+	breakpoints map[breakpointKey]int
+	lastStop    string
+}
+
+type breakpointKey struct {
+	typ  byte
+	addr uint16
+}
+
+// New returns a Server for c8. It takes over c8.Break for its breakpoints.
+func New(c8 *chip8.Chip8) *Server {
+	d := debugger.New(c8)
+	return &Server{
+		c8:          c8,
+		debugger:    d,
+		console:     console.New(c8, d),
+		breakpoints: map[breakpointKey]int{},
+		lastStop:    fmt.Sprintf("S%02x", sigTrap),
+	}
+}
+
+// Serve accepts connections on l and serves them one after another, until l is
+// closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		err = s.ServeConn(conn)
+		conn.Close()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+	}
+}
+
+// ServeConn serves the client on conn until it detaches, kills the target or
+// disconnects. The Chip8 is left stopped.
+func (s *Server) ServeConn(conn io.ReadWriter) error {
+	w := &syncWriter{w: conn}
+	done := make(chan struct{})
+	defer close(done)
+	events := readEvents(conn, w, done)
+	for e := range events {
+		if e.err != nil {
+			return e.err
+		}
+		if e.interrupt {
+			// The target is already stopped.
+			continue
+		}
+		reply, quit := s.handle(e.packet, events)
+		if err := writePacket(w, reply); err != nil {
+			return err
+		}
+		if quit {
+			return nil
+		}
+	}
+	return nil
+}
+
+// handle runs the command in packet and returns the reply. It reports whether
+// the client is finished with the target.
+func (s *Server) handle(packet string, events <-chan event) (string, bool) {
+	if packet == "" {
+		return "", false
+	}
+	args := packet[1:]
+	switch packet[0] {
+	case '?':
+		return s.lastStop, false
+	case 'g':
+		regs := s.c8.Registers()
+		var b strings.Builder
+		for n := range registers {
+			b.WriteString(encodeRegister(regs, n))
+		}
+		return b.String(), false
+	case 'G':
+		regs := s.c8.Registers()
+		for n := range registers {
+			v, rest, err := decodeRegister(args, n)
+			if err != nil {
+				return "E01", false
+			}
+			if err := setRegisterValue(&regs, n, v); err != nil {
+				return "E01", false
+			}
+			args = rest
+		}
+		return s.setRegisters(regs), false
+	case 'p':
+		n, err := strconv.ParseUint(args, 16, 8)
+		if err != nil || int(n) >= len(registers) {
+			return "E01", false
+		}
+		return encodeRegister(s.c8.Registers(), int(n)), false
+	case 'P':
+		parts := strings.SplitN(args, "=", 2)
+		n, err := strconv.ParseUint(parts[0], 16, 8)
+		if err != nil || len(parts) != 2 || int(n) >= len(registers) {
+			return "E01", false
+		}
+		v, _, err := decodeRegister(parts[1], int(n))
+		if err != nil {
+			return "E01", false
+		}
+		regs := s.c8.Registers()
+		if err := setRegisterValue(&regs, int(n), v); err != nil {
+			return "E01", false
+		}
+		return s.setRegisters(regs), false
+	case 'm':
+		addr, n, _, err := parseMemoryArgs(args)
+		if err != nil {
+			return "E01", false
+		}
+		data, err := s.c8.ReadMemory(addr, n)
+		if err != nil {
+			return "E14", false
+		}
+		return hex.EncodeToString(data), false
+	case 'M':
+		addr, n, rest, err := parseMemoryArgs(args)
+		if err != nil || !strings.HasPrefix(rest, ":") {
+			return "E01", false
+		}
+		data, err := hex.DecodeString(rest[1:])
+		if err != nil || len(data) != n {
+			return "E01", false
+		}
+		if err := s.c8.WriteMemory(addr, data); err != nil {
+			return "E14", false
+		}
+		return "OK", false
+	case 's', 'c':
+		if args != "" {
+			addr, err := strconv.ParseUint(args, 16, 16)
+			if err != nil {
+				return "E01", false
+			}
+			regs := s.c8.Registers()
+			regs.PC = uint16(addr)
+			if reply := s.setRegisters(regs); reply != "OK" {
+				return reply, false
+			}
+		}
+		if packet[0] == 's' {
+			s.lastStop = s.stopReply(s.debugger.Step())
+		} else {
+			s.lastStop = s.resume(events)
+		}
+		return s.lastStop, false
+	case 'b':
+		return s.reverse(args), false
+	case 'Z', 'z':
+		return s.breakpoint(packet[0] == 'Z', args), false
+	case 'q':
+		return s.query(args), false
+	case 'H':
+		return "OK", false
+	case 'D':
+		return "OK", true
+	case 'k':
+		return "", true
+	}
+	return "", false
+}
+
+// query answers the general query packets that gdb needs to connect, and
+// qRcmd, which runs a monitor command in the debugger console.
+func (s *Server) query(args string) string {
+	switch {
+	case strings.HasPrefix(args, "Supported"):
+		supported := "PacketSize=4000;qXfer:features:read+;swbreak+;hwbreak+"
+		if s.c8.Journal != nil {
+			supported += ";ReverseStep+;ReverseContinue+"
+		}
+		return supported
+	case strings.HasPrefix(args, "Xfer:features:read:target.xml:"):
+		var offset, length int
+		spec := strings.TrimPrefix(args, "Xfer:features:read:target.xml:")
+		if _, err := fmt.Sscanf(spec, "%x,%x", &offset, &length); err != nil {
+			return "E01"
+		}
+		if offset >= len(targetXML) {
+			return "l"
+		}
+		if offset+length >= len(targetXML) {
+			return "l" + targetXML[offset:]
+		}
+		return "m" + targetXML[offset:offset+length]
+	case strings.HasPrefix(args, "Rcmd,"):
+		return s.monitor(strings.TrimPrefix(args, "Rcmd,"))
+	case args == "Attached":
+		return "1"
+	case args == "C":
+		return "QC1"
+	case args == "fThreadInfo":
+		return "m1"
+	case args == "sThreadInfo":
+		return "l"
+	}
+	return ""
+}
+
+// monitor runs the hex encoded console command in a qRcmd packet and returns
+// its output, hex encoded.
+func (s *Server) monitor(arg string) string {
+	line, err := hex.DecodeString(arg)
+	if err != nil {
+		return "E01"
+	}
+	var out strings.Builder
+	if err := s.console.Exec(&out, string(line)); err != nil {
+		fmt.Fprintln(&out, err)
+	}
+	if out.Len() == 0 {
+		return "OK"
+	}
+	return hex.EncodeToString([]byte(out.String()))
+}
+
+// breakpoint inserts or removes the breakpoint or watchpoint in a Z or z
+// packet.
+func (s *Server) breakpoint(insert bool, args string) string {
+	parts := strings.Split(args, ",")
+	if len(parts) < 3 || len(parts[0]) != 1 {
+		return "E01"
+	}
+	addr, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return "E01"
+	}
+	n, err := strconv.ParseUint(parts[2], 16, 16)
+	if err != nil {
+		return "E01"
+	}
+	key := breakpointKey{typ: parts[0][0], addr: uint16(addr)}
+
+	if !insert {
+		if id, ok := s.breakpoints[key]; ok {
+			s.debugger.Delete(id)
+			delete(s.breakpoints, key)
+		}
+		return "OK"
+	}
+	if _, ok := s.breakpoints[key]; ok {
+		return "OK"
+	}
+	var bp *debugger.Breakpoint
+	switch key.typ {
+	case '0', '1':
+		bp = s.debugger.Break(key.addr, nil)
+	case '2', '3', '4':
+		kind := map[byte]debugger.Kind{
+			'2': debugger.WatchWrite,
+			'3': debugger.WatchRead,
+			'4': debugger.WatchAccess,
+		}[key.typ]
+		bp, err = s.debugger.Watch(kind, key.addr, int(n), nil)
+		if err != nil {
+			return "E14"
+		}
+	default:
+		return ""
+	}
+	s.breakpoints[key] = bp.ID
+	return "OK"
+}
+
+// reverse runs a bs or bc packet, which steps or continues backwards through
+// the Chip8's Journal, and returns the stop reply. It returns "" for any other
+// b packet, and an error if the Chip8 has no Journal.
+func (s *Server) reverse(args string) string {
+	var err error
+	switch args {
+	case "s":
+		err = s.debugger.ReverseStep()
+	case "c":
+		err = s.debugger.ReverseContinue()
+	default:
+		return ""
+	}
+	switch {
+	case errors.Is(err, debugger.ErrNoJournal):
+		return "E01"
+	case errors.Is(err, chip8.ErrNoHistory):
+		// Tell gdb it's reached the start of the recording.
+		s.lastStop = fmt.Sprintf("T%02xreplaylog:begin;", sigTrap)
+	default:
+		s.lastStop = s.stopReply(err)
+	}
+	return s.lastStop
+}
+
+// resume runs the Chip8 until it stops on a breakpoint, faults, or the client
+// interrupts it, and returns the stop reply.
+func (s *Server) resume(events <-chan event) string {
+	faults := s.c8.Run()
+	for {
+		select {
+		case err := <-faults:
+			return s.stopReply(err)
+		case e, ok := <-events:
+			if !ok || e.err != nil || e.interrupt {
+				select {
+				case s.c8.Stop <- struct{}{}:
+				case err, ok := <-faults:
+					if ok {
+						return s.stopReply(err)
+					}
+				}
+				return fmt.Sprintf("S%02x", sigInt)
+			}
+			// gdb doesn't send anything but an interrupt while the target
+			// runs, so anything else is dropped.
+		}
+	}
+}
+
+// stopReply returns the stop reply for the error a step or continue stopped
+// with.
+func (s *Server) stopReply(err error) string {
+	var hit *debugger.Hit
+	switch {
+	case err == nil:
+		return fmt.Sprintf("S%02x", sigTrap)
+	case errors.As(err, &hit):
+		bp := hit.Breakpoint
+		if bp == nil {
+			return fmt.Sprintf("S%02x", sigTrap)
+		}
+		switch bp.Kind {
+		case debugger.WatchWrite:
+			return fmt.Sprintf("T%02xwatch:%x;", sigTrap, bp.Addr)
+		case debugger.WatchRead:
+			return fmt.Sprintf("T%02xrwatch:%x;", sigTrap, bp.Addr)
+		case debugger.WatchAccess:
+			return fmt.Sprintf("T%02xawatch:%x;", sigTrap, bp.Addr)
+		}
+		return fmt.Sprintf("T%02xswbreak:;", sigTrap)
+	case errors.Is(err, chip8.ErrExit):
+		return "W00"
+	case errors.Is(err, chip8.ErrUnknownOpcode):
+		return fmt.Sprintf("S%02x", sigIll)
+	default:
+		return fmt.Sprintf("S%02x", sigSegv)
+	}
+}
+
+func (s *Server) setRegisters(regs chip8.Registers) string {
+	if err := s.c8.SetRegisters(regs); err != nil {
+		return "E01"
+	}
+	return "OK"
+}
+
+// parseMemoryArgs parses the "addr,length" at the start of an m or M packet
+// and returns the rest.
+func parseMemoryArgs(args string) (uint16, int, string, error) {
+	comma := strings.Index(args, ",")
+	if comma < 0 {
+		return 0, 0, "", fmt.Errorf("bad memory range %q", args)
+	}
+	end := strings.Index(args, ":")
+	if end < 0 {
+		end = len(args)
+	}
+	addr, err := strconv.ParseUint(args[:comma], 16, 16)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	n, err := strconv.ParseUint(args[comma+1:end], 16, 16)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	return uint16(addr), int(n), args[end:], nil
+}
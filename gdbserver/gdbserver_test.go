@@ -0,0 +1,158 @@
+package gdbserver
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/frontend"
+)
+
+// testProgram counts up in V0 forever, storing each count at 0x300.
+var testProgram = []byte{
+	0x60, 0x05, // 0x200 LD V0, 0x05
+	0x70, 0x01, // 0x202 ADD V0, 0x01
+	0xA3, 0x00, // 0x204 LD I, 0x300
+	0xF0, 0x55, // 0x206 LD [I], V0
+	0x12, 0x02, // 0x208 JP 0x202
+}
+
+// client speaks the remote serial protocol to a Server over a connection.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// startServer serves a Chip8 running testProgram on a loopback address and
+// returns a client connected to it.
+func startServer(t *testing.T) *client {
+	c8 := chip8.NewChip8(frontend.NullAudio{}, chip8.QuirksModern, chip8.NewSeededSource(1))
+	c8.Clock = chip8.FreeClock{}
+	if err := c8.LoadBytes(testProgram); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go New(c8).Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// send sends packet and returns the reply.
+func (c *client) send(packet string) string {
+	c.t.Helper()
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := fmt.Fprintf(c.conn, "$%s#%02x", packet, checksum(packet)); err != nil {
+		c.t.Fatalf("sending %s: %v", packet, err)
+	}
+	reply, _, err := readPacket(c.r)
+	if err != nil {
+		c.t.Fatalf("reading the reply to %s: %v", packet, err)
+	}
+	if _, err := c.conn.Write([]byte{'+'}); err != nil {
+		c.t.Fatalf("acknowledging the reply to %s: %v", packet, err)
+	}
+	return reply
+}
+
+// expect sends packet and checks that the reply is want.
+func (c *client) expect(packet, want string) {
+	c.t.Helper()
+	if got := c.send(packet); got != want {
+		c.t.Errorf("%s: got reply %q, want %q", packet, got, want)
+	}
+}
+
+// registers sends g and returns V0 and the PC from the reply.
+func (c *client) registers() (byte, uint16) {
+	c.t.Helper()
+	reply := c.send("g")
+	var v0 byte
+	var lo, hi byte
+	if _, err := fmt.Sscanf(reply[0:2]+" "+reply[36:38]+" "+reply[38:40], "%02x %02x %02x", &v0, &lo, &hi); err != nil {
+		c.t.Fatalf("bad g reply %q: %v", reply, err)
+	}
+	return v0, uint16(hi)<<8 | uint16(lo)
+}
+
+// expectRegisters checks V0 and the PC.
+func (c *client) expectRegisters(v0 byte, pc uint16) {
+	c.t.Helper()
+	gotV0, gotPC := c.registers()
+	if gotV0 != v0 || gotPC != pc {
+		c.t.Errorf("got V0=%02X PC=0x%03X, want V0=%02X PC=0x%03X", gotV0, gotPC, v0, pc)
+	}
+}
+
+func TestSession(t *testing.T) {
+	c := startServer(t)
+	c.expect("?", "S05")
+	c.expectRegisters(0x00, 0x200)
+
+	c.expect("M300,2:abcd", "OK")
+	c.expect("m300,2", "abcd")
+
+	c.expect("Z0,202,2", "OK")
+	c.expect("c", "T05swbreak:;")
+	c.expectRegisters(0x05, 0x202)
+
+	c.expect("s", "S05")
+	c.expectRegisters(0x06, 0x204)
+	c.expect("s", "S05")
+	c.expect("s", "S05")
+	c.expect("m300,1", "06")
+	c.expectRegisters(0x06, 0x208)
+
+	// Stepping onto the breakpoint stops there, and continuing from it runs
+	// the loop again rather than stopping straight away.
+	c.expect("s", "T05swbreak:;")
+	c.expectRegisters(0x06, 0x202)
+	c.expect("c", "T05swbreak:;")
+	c.expectRegisters(0x07, 0x202)
+
+	c.expect("z0,202,2", "OK")
+	c.expect("Z2,300,1", "OK")
+	c.expect("c", "T05watch:300;")
+	c.expectRegisters(0x08, 0x208)
+	c.expect("m300,1", "08")
+
+	c.expect("k", "")
+}
+
+func TestStepWatchpoint(t *testing.T) {
+	c := startServer(t)
+	c.expect("Z2,300,1", "OK")
+	for i := 0; i < 3; i++ {
+		c.expect("s", "S05")
+	}
+	// The watchpoint is hit by the step that writes, not by the next
+	// continue.
+	c.expect("s", "T05watch:300;")
+	c.expectRegisters(0x06, 0x208)
+	c.expect("z2,300,1", "OK")
+	c.expect("Z0,202,2", "OK")
+	c.expect("c", "T05swbreak:;")
+	c.expectRegisters(0x06, 0x202)
+}
+
+func TestBadPackets(t *testing.T) {
+	c := startServer(t)
+	for _, packet := range []string{"mzz,1", "M300,2:ab", "Z0,nope,2", "pff"} {
+		if reply := c.send(packet); !strings.HasPrefix(reply, "E") {
+			t.Errorf("%s: got reply %q, want an error", packet, reply)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package gdbserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// interrupt is the byte a client sends, outside of a packet, to stop a running
+// target.
+const interrupt = 0x03
+
+// ErrBadChecksum is returned when a packet's checksum doesn't match its
+// contents.
+var ErrBadChecksum = errors.New("bad packet checksum")
+
+// event is something read from the client: either a packet, or an interrupt.
+type event struct {
+	packet    string
+	interrupt bool
+	err       error
+}
+
+// readEvents reads packets and interrupts from r and sends them on the
+// returned channel, acknowledging each packet on w as it goes. It stops after
+// the first read error, which is sent on the channel, or once done is closed.
+func readEvents(r io.Reader, w io.Writer, done <-chan struct{}) <-chan event {
+	events := make(chan event)
+	send := func(e event) bool {
+		select {
+		case events <- e:
+			return true
+		case <-done:
+			return false
+		}
+	}
+	go func() {
+		br := bufio.NewReader(r)
+		for {
+			packet, isInterrupt, err := readPacket(br)
+			switch {
+			case errors.Is(err, ErrBadChecksum):
+				// Ask for the packet again.
+				_, err = w.Write([]byte{'-'})
+				if err == nil {
+					continue
+				}
+			case err == nil && !isInterrupt:
+				_, err = w.Write([]byte{'+'})
+			}
+			if err != nil {
+				send(event{err: err})
+				return
+			}
+			if !send(event{packet: packet, interrupt: isInterrupt}) {
+				return
+			}
+		}
+	}()
+	return events
+}
+
+// syncWriter serialises writes, so that acknowledgements written by
+// readEvents don't get mixed into the middle of a reply.
+type syncWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.w.Write(p)
+}
+
+// readPacket reads the next packet or interrupt, skipping acknowledgements.
+func readPacket(r *bufio.Reader) (string, bool, error) {
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", false, err
+		}
+		switch c {
+		case interrupt:
+			return "", true, nil
+		case '$':
+		default:
+			// Acks, and any noise between packets. The connection is reliable,
+			// so requests to resend a reply are ignored.
+			continue
+		}
+		data, err := r.ReadString('#')
+		if err != nil {
+			return "", false, err
+		}
+		data = data[:len(data)-1]
+		var sum [2]byte
+		if _, err := io.ReadFull(r, sum[:]); err != nil {
+			return "", false, err
+		}
+		want, err := strconv.ParseUint(string(sum[:]), 16, 8)
+		if err != nil || byte(want) != checksum(data) {
+			return "", false, ErrBadChecksum
+		}
+		return unescape(data), false, nil
+	}
+}
+
+// writePacket frames data as a packet and writes it to w.
+func writePacket(w io.Writer, data string) error {
+	data = escape(data)
+	_, err := fmt.Fprintf(w, "$%s#%02x", data, checksum(data))
+	return err
+}
+
+func checksum(data string) byte {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+// escape escapes the characters that can't appear in a packet as is.
+func escape(data string) string {
+	var out []byte
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; c {
+		case '$', '#', '}', '*':
+			out = append(out, '}', c^0x20)
+		default:
+			out = append(out, c)
+		}
+	}
+	return string(out)
+}
+
+func unescape(data string) string {
+	var out []byte
+	for i := 0; i < len(data); i++ {
+		if data[i] == '}' && i+1 < len(data) {
+			i++
+			out = append(out, data[i]^0x20)
+			continue
+		}
+		out = append(out, data[i])
+	}
+	return string(out)
+}
@@ -0,0 +1,123 @@
+package gdbserver
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/zabrahams/gochip8/chip8"
+)
+
+// register describes one of the registers in the target description, in the
+// order they're sent in a g packet. Values are little endian.
+type register struct {
+	name string
+	size int
+	typ  string
+}
+
+// registers is the register file: V0 to VF, I, PC, SP (the depth of the call
+// stack), DT and ST.
+var registers = func() []register {
+	regs := make([]register, 0, 21)
+	for i := 0; i < 16; i++ {
+		regs = append(regs, register{name: fmt.Sprintf("v%x", i), size: 1, typ: "uint8"})
+	}
+	return append(regs,
+		register{name: "i", size: 2, typ: "data_ptr"},
+		register{name: "pc", size: 2, typ: "code_ptr"},
+		register{name: "sp", size: 1, typ: "uint8"},
+		register{name: "dt", size: 1, typ: "uint8"},
+		register{name: "st", size: 1, typ: "uint8"},
+	)
+}()
+
+// Register numbers of the registers after VF.
+const (
+	regI = 16 + iota
+	regPC
+	regSP
+	regDT
+	regST
+)
+
+// targetXML is the target description sent for qXfer:features:read.
+var targetXML = func() string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.gochip8.chip8">
+`)
+	for i, r := range registers {
+		fmt.Fprintf(&b, "    <reg name=%q bitsize=\"%d\" type=%q regnum=\"%d\"/>\n", r.name, r.size*8, r.typ, i)
+	}
+	b.WriteString("  </feature>\n</target>\n")
+	return b.String()
+}()
+
+// registerValue returns the value of register n.
+func registerValue(regs chip8.Registers, n int) uint16 {
+	switch n {
+	case regI:
+		return regs.I
+	case regPC:
+		return regs.PC
+	case regSP:
+		return uint16(len(regs.Stack))
+	case regDT:
+		return uint16(regs.DelayTimer)
+	case regST:
+		return uint16(regs.SoundTimer)
+	default:
+		return uint16(regs.V[n])
+	}
+}
+
+// setRegisterValue sets register n to v. The stack can only be made
+// shallower by setting SP, since there'd be nothing to fill it with otherwise.
+func setRegisterValue(regs *chip8.Registers, n int, v uint16) error {
+	switch n {
+	case regI:
+		regs.I = v
+	case regPC:
+		regs.PC = v
+	case regSP:
+		if int(v) > len(regs.Stack) {
+			return fmt.Errorf("can't grow the call stack to %d", v)
+		}
+		regs.Stack = regs.Stack[:v]
+	case regDT:
+		regs.DelayTimer = byte(v)
+	case regST:
+		regs.SoundTimer = byte(v)
+	default:
+		regs.V[n] = byte(v)
+	}
+	return nil
+}
+
+// encodeRegister encodes register n as little endian hex.
+func encodeRegister(regs chip8.Registers, n int) string {
+	v := registerValue(regs, n)
+	b := []byte{byte(v), byte(v >> 8)}
+	return hex.EncodeToString(b[:registers[n].size])
+}
+
+// decodeRegister decodes the little endian hex value of register n from the
+// start of data and returns the rest.
+func decodeRegister(data string, n int) (uint16, string, error) {
+	size := registers[n].size * 2
+	if len(data) < size {
+		return 0, "", fmt.Errorf("short value for %s", registers[n].name)
+	}
+	b, err := hex.DecodeString(data[:size])
+	if err != nil {
+		return 0, "", err
+	}
+	v := uint16(b[0])
+	if len(b) == 2 {
+		v |= uint16(b[1]) << 8
+	}
+	return v, data[size:], nil
+}
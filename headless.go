@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/zabrahams/gochip8/headless"
+)
+
+// headlessOptions holds the options that only the headless command takes.
+type headlessOptions struct {
+	frames   int
+	untilPC  string
+	keysFile string
+	pngFile  string
+	textFile string
+	jsonFile string
+}
+
+func (h *headlessOptions) register(flags *flag.FlagSet) {
+	flags.IntVar(&h.frames, "frames", 600, "most frames to run for")
+	flags.StringVar(&h.untilPC, "until-pc", "", "stop when the program counter reaches this address")
+	flags.StringVar(&h.keysFile, "keys", "", "key script file")
+	flags.StringVar(&h.pngFile, "png", "", "file to write the final screen to as a png")
+	flags.StringVar(&h.textFile, "text", "", "file to write the final screen to as text")
+	flags.StringVar(&h.jsonFile, "json", "", "file to write the final registers to as json")
+}
+
+func runHeadless(programFile string, opts options) {
+	program, err := ioutil.ReadFile(programFile)
+	if err != nil {
+		panic(err)
+	}
+
+	cfg := headless.Config{
+		Quirks:         opts.quirks,
+		Seed:           opts.seed,
+		CyclesPerFrame: opts.cycles,
+		Frames:         opts.headless.frames,
+		Input:          opts.input,
+	}
+	var stopHooks func()
+	cfg.Trace, stopHooks = startHooks(programFile, opts)
+	defer stopHooks()
+	var stopCoverage func()
+	cfg.Coverage, stopCoverage = startCoverage(opts)
+	defer stopCoverage()
+	if opts.headless.untilPC != "" {
+		pc, err := strconv.ParseUint(opts.headless.untilPC, 0, 16)
+		if err != nil {
+			panic(fmt.Sprintf("bad -until-pc address: %s", opts.headless.untilPC))
+		}
+		cfg.StopAtPC = true
+		cfg.StopPC = uint16(pc)
+	}
+	if opts.headless.keysFile != "" {
+		file, err := os.Open(opts.headless.keysFile)
+		if err != nil {
+			panic(err)
+		}
+		cfg.Keys, err = headless.ParseKeyScript(file)
+		file.Close()
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	res, err := headless.Run(program, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	if opts.headless.pngFile != "" {
+		writeFile(opts.headless.pngFile, func(w io.Writer) error {
+			return headless.WritePNG(w, res.FrameBuffer)
+		})
+	}
+	if opts.headless.textFile != "" {
+		writeFile(opts.headless.textFile, func(w io.Writer) error {
+			_, err := io.WriteString(w, res.FrameBuffer.String())
+			return err
+		})
+	}
+	writeJSON := func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	}
+	if opts.headless.jsonFile != "" {
+		writeFile(opts.headless.jsonFile, writeJSON)
+	} else {
+		writeJSON(os.Stdout)
+	}
+	if res.Reason == headless.StopFault {
+		os.Exit(1)
+	}
+}
+
+// writeFile creates filename and writes to it with write.
+func writeFile(filename string, write func(w io.Writer) error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+	if err := write(file); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,134 @@
+// Package headless runs Chip8 programs without a display, keyboard or sound,
+// for CI and batch jobs. It doesn't depend on SDL.
+package headless
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/frontend"
+)
+
+// The reasons a headless run can stop.
+const (
+	StopFrames = "frames"
+	StopPC     = "pc"
+	StopHalt   = "halt"
+	StopExit   = "exit"
+	StopFault  = "fault"
+)
+
+// errHalt stops a run at a jump to itself, which is how most programs halt.
+var errHalt = errors.New("the program jumped to itself")
+
+// Config controls a headless run.
+//
+// Frames: the most frames to run for
+//
+// StopAtPC, StopPC: if StopAtPC is set the run stops when the program
+// counter reaches StopPC, before executing the instruction there
+//
+// Keys: key presses and releases to script into the keyboard
+//
+// Input: a recording to replay into the keyboard instead of Keys
+//
+// Trace: if set, it's called with what every instruction executed does
+//
+// Coverage: if set, every instruction executed is recorded into it
+type Config struct {
+	Quirks         chip8.Quirks
+	Seed           uint64
+	CyclesPerFrame int
+	Frames         int
+	StopAtPC       bool
+	StopPC         uint16
+	Keys           KeyScript
+	Input          *chip8.InputRecording
+	Trace          func(e *chip8.TraceEntry)
+	Coverage       *chip8.Coverage
+}
+
+// Result is the state of the machine at the end of a headless run.
+type Result struct {
+	Frames      int                `json:"frames"`
+	Reason      string             `json:"reason"`
+	Error       string             `json:"error,omitempty"`
+	Registers   chip8.Registers    `json:"registers"`
+	FrameBuffer *chip8.FrameBuffer `json:"-"`
+}
+
+// Run loads program into a fresh Chip8 and runs it until it has run
+// cfg.Frames frames, reached cfg.StopPC, halted by jumping to itself, exited or
+// faulted. Only a failure to load the program is returned as an error - a fault
+// is recorded in the Result.
+func Run(program []byte, cfg Config) (*Result, error) {
+	c8 := chip8.NewChip8(frontend.NullAudio{}, cfg.Quirks, chip8.NewSeededSource(cfg.Seed))
+	c8.Coverage = cfg.Coverage
+	if err := c8.LoadBytes(program); err != nil {
+		return nil, err
+	}
+	if cfg.CyclesPerFrame > 0 {
+		c8.CyclesPerFrame = cfg.CyclesPerFrame
+	}
+	c8.Input = cfg.Input
+	c8.Trace = cfg.Trace
+	c8.Break = func(c8 *chip8.Chip8) error {
+		pc := c8.Registers().PC
+		if cfg.StopAtPC && pc == cfg.StopPC {
+			return chip8.ErrBreak
+		}
+		instr, err := c8.ReadMemory(pc, 2)
+		if err == nil && instr[0]>>4 == 0x1 && uint16(instr[0]&0x0F)<<8|uint16(instr[1]) == pc {
+			return errHalt
+		}
+		return nil
+	}
+
+	res := &Result{Reason: StopFrames, FrameBuffer: c8.FrameBuffer}
+	var keys uint16
+	for res.Frames < cfg.Frames {
+		if cfg.Input == nil {
+			keys = cfg.Keys.apply(res.Frames, keys)
+			c8.Keyboard.Update(keys)
+		}
+		err := c8.RunFrame(c8.CyclesPerFrame)
+		res.Frames++
+		if err != nil {
+			switch {
+			case errors.Is(err, chip8.ErrBreak):
+				res.Reason = StopPC
+			case errors.Is(err, errHalt):
+				res.Reason = StopHalt
+			case errors.Is(err, chip8.ErrExit):
+				res.Reason = StopExit
+			default:
+				res.Reason = StopFault
+				res.Error = err.Error()
+			}
+			break
+		}
+	}
+	res.Registers = c8.Registers()
+	return res, nil
+}
+
+// WritePNG writes the frame buffer as a PNG, one image pixel per screen pixel.
+func WritePNG(w io.Writer, fb *chip8.FrameBuffer) error {
+	palette := color.Palette{
+		color.Gray{0x00},
+		color.Gray{0xFF},
+		color.Gray{0xAA},
+		color.Gray{0x55},
+	}
+	img := image.NewPaletted(image.Rect(0, 0, fb.Width, fb.Height), palette)
+	for y := 0; y < fb.Height; y++ {
+		for x := 0; x < fb.Width; x++ {
+			img.SetColorIndex(x, y, fb.Pixel(x, y))
+		}
+	}
+	return png.Encode(w, img)
+}
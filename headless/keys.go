@@ -0,0 +1,84 @@
+package headless
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// KeyEvent presses or releases a key at the start of a frame.
+type KeyEvent struct {
+	Frame int
+	Key   byte
+	Down  bool
+}
+
+// KeyScript is a list of key events, in frame order.
+type KeyScript []KeyEvent
+
+// ParseKeyScript reads a key script. Each line is a frame number, press or
+// release, and a key from 0 to F, e.g.
+//
+//	# wait for the title screen, then tap 5
+//	120 press 5
+//	125 release 5
+//
+// Blank lines and lines starting with # are ignored.
+func ParseKeyScript(r io.Reader) (KeyScript, error) {
+	var script KeyScript
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected frame, press or release, and key", line)
+		}
+		frame, err := strconv.Atoi(fields[0])
+		if err != nil || frame < 0 {
+			return nil, fmt.Errorf("line %d: bad frame number %q", line, fields[0])
+		}
+		var down bool
+		switch fields[1] {
+		case "press":
+			down = true
+		case "release":
+			down = false
+		default:
+			return nil, fmt.Errorf("line %d: expected press or release, got %q", line, fields[1])
+		}
+		key, err := strconv.ParseUint(fields[2], 16, 8)
+		if err != nil || key > 0xF {
+			return nil, fmt.Errorf("line %d: bad key %q", line, fields[2])
+		}
+		script = append(script, KeyEvent{Frame: frame, Key: byte(key), Down: down})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(script, func(i, j int) bool { return script[i].Frame < script[j].Frame })
+	return script, nil
+}
+
+// apply returns the keyboard state at the start of frame, given the state at
+// the end of the frame before.
+func (s KeyScript) apply(frame int, keys uint16) uint16 {
+	for _, e := range s {
+		if e.Frame != frame {
+			continue
+		}
+		if e.Down {
+			keys |= 1 << e.Key
+		} else {
+			keys &^= 1 << e.Key
+		}
+	}
+	return keys
+}
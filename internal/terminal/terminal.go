@@ -0,0 +1,46 @@
+// Package terminal switches the terminal attached to stdin in and out of raw
+// mode and finds its size, by running stty.
+package terminal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Stty runs stty on the terminal attached to stdin and returns its output.
+func Stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// RawMode switches the terminal to reading a key at a time without echoing
+// it, and returns a function that restores the old settings. Any extra stty
+// settings are applied too; without -isig ctrl-c still interrupts.
+func RawMode(extra ...string) (func(), error) {
+	saved, err := Stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("stdin isn't a terminal: %w", err)
+	}
+	if _, err := Stty(append([]string{"-icanon", "-echo", "min", "1"}, extra...)...); err != nil {
+		return nil, err
+	}
+	return func() { Stty(saved) }, nil
+}
+
+// Size returns the number of rows and columns of the terminal, or 24 by 80 if
+// it can't be found.
+func Size() (int, int) {
+	var rows, cols int
+	out, err := Stty("size")
+	if err != nil {
+		return 24, 80
+	}
+	if _, err := fmt.Sscanf(out, "%d %d", &rows, &cols); err != nil || rows == 0 {
+		return 24, 80
+	}
+	return rows, cols
+}
@@ -0,0 +1,125 @@
+// Package testroms is the corpus of roms that the disassembler, assembler and
+// compiler tests round trip: some real programs, a few written out by hand to
+// cover the awkward cases, and a lot of random ones.
+//
+// The real programs are in testdata:
+//
+//	maze.ch8    Maze, by David Winter, from his public domain collection
+//	ibm.ch8     the IBM logo demo that's used to try out new interpreters
+package testroms
+
+import (
+	"embed"
+	"fmt"
+	"math/rand"
+	"path"
+
+	"github.com/zabrahams/gochip8/chip8"
+)
+
+// RANDOM_ROMS is the number of random roms in the corpus.
+const RANDOM_ROMS = 200
+
+//go:embed testdata/*.ch8
+var testdata embed.FS
+
+// ROM is a rom in the corpus.
+//
+// Name: the file it came from, or what it's for
+//
+// Bytes: the rom, to be loaded at chip8.PROGRAM_OFFSET
+type ROM struct {
+	Name  string
+	Bytes []byte
+}
+
+// The roms written out by hand.
+var (
+	// Sprite draws a sprite that sits between the code and a subroutine
+	// at an odd address.
+	Sprite = []byte{
+		0x00, 0xE0, // 0x200 CLS
+		0xA2, 0x0A, // 0x202 LD I, 0x20A
+		0xD0, 0x15, // 0x204 DRW V0, V1, 0x5
+		0x22, 0x0F, // 0x206 CALL 0x20F
+		0x12, 0x00, // 0x208 JP 0x200
+		0xF0, 0x90, 0x90, 0x90, 0xF0, // 0x20A sprite
+		0x00, 0xEE, // 0x20F RET
+	}
+	// LongSkip jumps to an odd address and skips over the four byte
+	// LD I, LONG.
+	LongSkip = []byte{
+		0x12, 0x03, // 0x200 JP 0x203
+		0xFF,       // 0x202 data
+		0x60, 0x01, // 0x203 LD V0, 0x01
+		0x30, 0x01, // 0x205 SE V0, 0x01
+		0x12, 0x03, // 0x207 JP 0x203
+		0xF0, 0x00, 0x02, 0x10, // 0x209 LD I, LONG 0x0210
+		0x00, 0xFD, // 0x20D EXIT
+		0x00, // 0x20F data
+	}
+	// JumpTable has a computed jump into a table of jumps.
+	JumpTable = []byte{
+		0x60, 0x00, // 0x200 LD V0, 0x00
+		0xB2, 0x04, // 0x202 JP V0, 0x204
+		0x12, 0x08, // 0x204 JP 0x208
+		0x12, 0x0A, // 0x206 JP 0x20A
+		0x00, 0xFD, // 0x208 EXIT
+		0x00, 0xFD, // 0x20A EXIT
+		0x93, 0x41, // 0x20C data
+	}
+)
+
+// ROMs returns the whole corpus: the real roms in testdata in name order, then
+// the ones written out by hand, then RANDOM_ROMS random ones, which are the
+// same every time.
+func ROMs() []ROM {
+	var roms []ROM
+	files, err := testdata.ReadDir("testdata")
+	if err != nil {
+		panic(err)
+	}
+	for _, file := range files {
+		data, err := testdata.ReadFile(path.Join("testdata", file.Name()))
+		if err != nil {
+			panic(err)
+		}
+		roms = append(roms, ROM{Name: file.Name(), Bytes: data})
+	}
+	roms = append(roms,
+		ROM{Name: "sprite", Bytes: Sprite},
+		ROM{Name: "long skip", Bytes: LongSkip},
+		ROM{Name: "jump table", Bytes: JumpTable},
+	)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < RANDOM_ROMS; i++ {
+		roms = append(roms, ROM{Name: fmt.Sprintf("random %d", i), Bytes: Random(r)})
+	}
+	return roms
+}
+
+// Random returns a rom of random instructions and data. Most of the jumps,
+// calls and LD Is are pointed back into the rom, so that there's code to
+// follow and data to find rather than just a jump off into empty memory.
+func Random(r *rand.Rand) []byte {
+	rom := make([]byte, 2+r.Intn(1024))
+	r.Read(rom)
+	for i := 0; i+1 < len(rom); i += 2 {
+		switch rom[i] >> 4 {
+		case 0x1, 0x2, 0xA, 0xB:
+			if r.Intn(4) == 0 {
+				continue
+			}
+			addr := chip8.PROGRAM_OFFSET + r.Intn(len(rom))
+			rom[i] = rom[i]&0xF0 | byte(addr>>8)
+			rom[i+1] = byte(addr)
+		case 0x0:
+			// Make the instructions that stop the flow rarer than random
+			// bytes would, so that more of the rom is followed.
+			if r.Intn(2) == 0 {
+				rom[i] |= 0x60
+			}
+		}
+	}
+	return rom
+}
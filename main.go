@@ -1,31 +1,98 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 
-	"github.com/veandco/go-sdl2/sdl"
-	"github.com/zabrahams/gochip8/beeper"
 	"github.com/zabrahams/gochip8/chip8"
-	"github.com/zabrahams/gochip8/screen"
+	"github.com/zabrahams/gochip8/chip8/disasm"
+	"github.com/zabrahams/gochip8/frontend"
+	"github.com/zabrahams/gochip8/symbols"
 )
 
 const helpMsg = `
 gochip8 is a chip 8 emulator! 
 You can use it as follows:
-./gochip8 mode rom
+./gochip8 mode [options] rom
 where mode is either:
 	run - runs the rom
-	dis - dissassembles the rom
+	dis - disassembles the rom, following its jumps and calls to tell its code from its data
 	debug - runs the rom in debug mode
+	headless - runs the rom without a display until it halts by jumping to itself, and dumps the final state
+	gdb - serves the rom to gdb over the remote serial protocol
+	dap - serves the debug adapter protocol for editors, which pick the rom when they launch it
+	trace - prints a binary trace written with -trace-format binary, in place of the rom
+	asm - assembles a source file, in place of the rom, written with the mnemonics dis uses
+	octo - compiles an octo source file, in place of the rom
 and rom is a path to the rom
+while running, F5 saves the state of the machine to rom.state and F9 loads it again.
+holding backspace rewinds the last 30 seconds.
+in debug mode, press h for the debugger's keys, and type :help for its commands.
+options are:
+	-quirks profile - the quirks profile to run the rom with (vip, chip48, schip, xochip or modern), of which only xochip runs the XO-CHIP instructions
+	-cycles n - the number of instructions to execute per 60hz frame
+	-seed n - the seed for the random number generator, picked at random if not given
+	-record file - record the keyboard and seed to file, so that the run can be replayed
+	-replay file - replay a run recorded with -record
+	-frontend name - how to show the screen and read the keyboard: sdl (the default), term or null
+	-trace file - write what every instruction executed does to file
+	-trace-format format - write the trace as text (the default) or binary
+	-trace-pc range - only trace the instructions at these addresses, e.g. 0x200-0x2FF
+	-trace-ops list - only trace these instructions, e.g. DRW,CALL
+	-trace-frames range - only trace the instructions executed in these frames, e.g. 10-20
+	-profile file - count where the instructions are spent, writing a report to stderr on exit and a profile for go tool pprof to file
+	-coverage file - write a report of which instructions ran, and which way each skip went, to file on exit
+	-lcov file - write the coverage to file as an lcov tracefile on exit, which needs -symbols
+	-symbols file - a symbol file with the labels and source lines of the rom, from asm or octo, which the disassembly, debugger, trace and -lcov show
+headless also takes:
+	-frames n - the most frames to run for (default 600)
+	-until-pc addr - stop when the program counter reaches addr
+	-keys file - a script of key presses, one "frame press|release key" per line
+	-png file - write the final screen to file as a png
+	-text file - write the final screen to file as text
+	-json file - write the final registers to file as json (default stdout)
+dis also takes:
+	-format format - write the disassembly as asm (the default), or as octo source that assembles back into the rom
+asm and octo also take:
+	-o file - the file to write the rom to, by default the source file with a .ch8 extension
+	-symbols file - write the labels and the source line of each instruction to file
+gdb also takes:
+	-listen addr - the address to listen for gdb on (default localhost:1234)
+dap also takes:
+	-listen addr - the address to listen for a client on, instead of using stdin and stdout
+trace takes the -trace-pc, -trace-ops and -trace-frames filters, and also:
+	-grep regexp - only print the entries that match regexp
+a binary built with -tags nosdl doesn't need SDL, and defaults to the term frontend.
+the sdl and term frontends both take the keypad from 1234 qwer asdf zxcv, F5 and F9 to save and load the state, . to pause and backspace to rewind.
+the term frontend quits on Esc or ctrl-c, and counts a key as held until the terminal stops repeating it.
 `
 
+// options holds the settings parsed from the command line that are shared
+// between the subcommands.
+type options struct {
+	quirks      chip8.Quirks
+	cycles      int
+	seed        uint64
+	input       *chip8.InputRecording
+	recordFile  string
+	frontend    string
+	headless    headlessOptions
+	listen      string
+	trace       traceOptions
+	profileFile string
+	coverage    coverageOptions
+	symbols     *symbols.Table
+	disFormat   string
+	asm         asmOptions
+}
+
 func main() {
 
-	if len(os.Args) < 3 {
-		fmt.Println(helpMsg)
+	if len(os.Args) < 2 {
+		fmt.Print(helpMsg)
 		os.Exit(1)
 	}
 
@@ -33,25 +100,112 @@ func main() {
 	if subcommand == "" {
 		panic("need subcommand: run, dis or debug")
 	}
-	programFile := os.Args[2]
-	if programFile == "" {
-		panic("no program file given")
+
+	var opts options
+	flags := flag.NewFlagSet(subcommand, flag.ExitOnError)
+	flags.Usage = func() { fmt.Print(helpMsg) }
+	quirksName := flags.String("quirks", "modern", "quirks profile: "+strings.Join(chip8.QuirksProfileNames(), ", "))
+	cycles := flags.Int("cycles", chip8.INSTRS_PER_FRAME, "instructions executed per frame")
+	seed := flags.Uint64("seed", 0, "random number generator seed")
+	recordFile := flags.String("record", "", "file to record input to")
+	replayFile := flags.String("replay", "", "file to replay input from")
+	defaultFrontend := "term"
+	if frontend.Registered("sdl") {
+		defaultFrontend = "sdl"
+	}
+	flags.StringVar(&opts.frontend, "frontend", defaultFrontend, "frontend: "+strings.Join(frontend.Names(), ", "))
+	if subcommand == "headless" {
+		opts.headless.register(flags)
+	}
+	compiling := subcommand == "asm" || subcommand == "octo"
+	if subcommand != "dis" && !compiling {
+		opts.trace.register(flags, subcommand == "trace")
+	}
+	if subcommand != "dis" && subcommand != "trace" && !compiling {
+		flags.StringVar(&opts.profileFile, "profile", "", "file to write a pprof profile to, along with a report on stderr")
+		opts.coverage.register(flags)
+	}
+	if subcommand == "dis" {
+		flags.StringVar(&opts.disFormat, "format", disasm.FORMAT_ASM, "disassembly format: asm or octo")
+	}
+	var symbolsFile string
+	if compiling {
+		opts.asm.register(flags)
+	} else {
+		flags.StringVar(&symbolsFile, "symbols", "", "symbol file with the labels and source lines of the rom")
+	}
+	if subcommand == "gdb" {
+		flags.StringVar(&opts.listen, "listen", "localhost:1234", "address to listen for gdb on")
+	}
+	if subcommand == "dap" {
+		flags.StringVar(&opts.listen, "listen", "", "address to listen for a client on")
+	}
+	flags.Parse(os.Args[2:])
+
+	programFile := flags.Arg(0)
+	// dap is the one command that doesn't need a file, since its client picks
+	// the rom.
+	if programFile == "" && subcommand != "dap" {
+		fmt.Print(helpMsg)
+		os.Exit(1)
+	}
+
+	quirks, ok := chip8.QuirksProfile(*quirksName)
+	if !ok {
+		panic(fmt.Sprintf("unknown quirks profile: %s", *quirksName))
+	}
+	opts.quirks = quirks
+	opts.cycles = *cycles
+	if symbolsFile != "" {
+		var err error
+		if opts.symbols, err = symbols.Load(symbolsFile); err != nil {
+			panic(err)
+		}
+	}
+
+	opts.seed = chip8.NewRandomSeed()
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			opts.seed = *seed
+		}
+	})
+	if *replayFile != "" {
+		file, err := os.Open(*replayFile)
+		if err != nil {
+			panic(err)
+		}
+		opts.input, err = chip8.ReadInputRecording(file)
+		file.Close()
+		if err != nil {
+			panic(err)
+		}
+		opts.seed = opts.input.Seed
+	} else if *recordFile != "" {
+		opts.input = chip8.NewInputRecording(opts.seed)
+		opts.recordFile = *recordFile
 	}
 
-	switch subcommand {
-	case "run":
-		run(programFile)
-	case "debug":
-		debug(programFile)
-	case "dis":
-		dis(programFile)
-	default:
+	command, ok := commands[subcommand]
+	if !ok {
 		panic(fmt.Sprintf("unknown command: %s", subcommand))
 	}
+	command(programFile, opts)
+}
 
+// commands maps each subcommand to the function that runs it.
+var commands = map[string]func(programFile string, opts options){
+	"run":      run,
+	"debug":    debug,
+	"dis":      dis,
+	"headless": runHeadless,
+	"gdb":      runGDB,
+	"dap":      runDAP,
+	"trace":    runTrace,
+	"asm":      runAsm,
+	"octo":     runOcto,
 }
 
-func dis(programFile string) {
+func dis(programFile string, opts options) {
 	file, err := os.Open(programFile)
 	if err != nil {
 		panic(err)
@@ -61,149 +215,40 @@ func dis(programFile string) {
 	if err != nil {
 		panic(err)
 	}
-	builder := chip8.Disassemble(program, 0x200)
-	fmt.Println(builder.String())
-}
-
-func debug(programFile string) {
-	var (
-		command string
-		s       struct{}
-	)
-	fmt.Println("Starting Chip8 Emulator")
-
-	screen := screen.NewScreen()
-	defer screen.Close()
-
-	beeper := beeper.NewSDLBeeper()
-	defer beeper.Close()
-
-	c8 := chip8.NewChip8(beeper)
-	c8.Load(programFile)
-	c8.String()
-	quit := false
-	running := false
-	for !quit {
-		for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
-			switch event.(type) {
-			case *sdl.QuitEvent:
-				println("Quit")
-				quit = true
-			case *sdl.KeyboardEvent:
-				kevent := event.(*sdl.KeyboardEvent)
-				if kevent.Type == sdl.KEYUP && kevent.Keysym.Sym == sdl.K_PERIOD {
-					c8.Stop <- s
-					c8.String()
-					running = false
-				}
-			}
-		}
-		if !running {
-			fmt.Print("command: (h for help) ")
-			fmt.Scanln(&command)
-			switch command {
-			case "s":
-				c8.ExecInstr()
-				c8.String()
-			case "r":
-				c8.Run()
-				running = true
-			case "q":
-				quit = true
-			case "h":
-				fmt.Println("you can use the following commands (s)tep, (r)un, (q)uit.  you can also use '.' to stop the running program.")
-			}
-		}
-		kbState := sdl.GetKeyboardState()
-		newKBState := parseKbState(kbState)
-
-		c8.Keyboard.Update(newKBState)
-		screen.Update(c8.FrameBuffer)
-
+	listing := disasm.Analyze(program, chip8.PROGRAM_OFFSET)
+	if opts.symbols != nil {
+		listing.UseSymbols(opts.symbols)
+	}
+	if err := listing.Write(os.Stdout, opts.disFormat); err != nil {
+		panic(err)
 	}
-	fmt.Println("Closing Chip8 Emulator")
 }
 
-func run(programFile string) {
-	fmt.Println("Starting Chip8 Emulator")
-
-	screen := screen.NewScreen()
-	defer screen.Close()
-
-	beeper := beeper.NewSDLBeeper()
-	defer beeper.Close()
-
-	c8 := chip8.NewChip8(beeper)
-	c8.Load(programFile)
-	c8.Run()
-	running := true
-	for running {
-		for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
-			switch event.(type) {
-			case *sdl.QuitEvent:
-				println("Quit")
-				running = false
-			}
-		}
-		kbState := sdl.GetKeyboardState()
-		newKBState := parseKbState(kbState)
-
-		c8.Keyboard.Update(newKBState)
-		screen.Update(c8.FrameBuffer)
-	}
-	fmt.Println("Closing Chip8 Emulator")
+// newChip8 creates a Chip8 set up as the command line options ask. The seed is
+// logged to stderr, since dap may be using stdout for its protocol.
+func newChip8(b chip8.Beeper, opts options) *chip8.Chip8 {
+	fmt.Fprintf(os.Stderr, "Random seed: %d\n", opts.seed)
+	c8 := chip8.NewChip8(b, opts.quirks, chip8.NewSeededSource(opts.seed))
+	c8.CyclesPerFrame = opts.cycles
+	c8.Input = opts.input
+	c8.Symbols = opts.symbols
+	return c8
 }
 
-func parseKbState(kbState []uint8) uint16 {
-	var keys uint16
-	keys = 0
-	if kbState[sdl.SCANCODE_1] == 1 {
-		keys = keys | (0x1 << 0)
-	}
-	if kbState[sdl.SCANCODE_2] == 1 {
-		keys = keys | (0x1 << 1)
-	}
-	if kbState[sdl.SCANCODE_3] == 1 {
-		keys = keys | (0x1 << 2)
-	}
-	if kbState[sdl.SCANCODE_4] == 1 {
-		keys = keys | (0x1 << 3)
-	}
-	if kbState[sdl.SCANCODE_Q] == 1 {
-		keys = keys | (0x1 << 4)
-	}
-	if kbState[sdl.SCANCODE_W] == 1 {
-		keys = keys | (0x1 << 5)
+// writeRecording saves the input recording, if one was asked for.
+func writeRecording(opts options) {
+	if opts.recordFile == "" {
+		return
 	}
-	if kbState[sdl.SCANCODE_E] == 1 {
-		keys = keys | (0x1 << 6)
-	}
-	if kbState[sdl.SCANCODE_R] == 1 {
-		keys = keys | (0x1 << 7)
-	}
-	if kbState[sdl.SCANCODE_A] == 1 {
-		keys = keys | (0x1 << 8)
-	}
-	if kbState[sdl.SCANCODE_S] == 1 {
-		keys = keys | (0x1 << 9)
-	}
-	if kbState[sdl.SCANCODE_D] == 1 {
-		keys = keys | (0x1 << 10)
-	}
-	if kbState[sdl.SCANCODE_F] == 1 {
-		keys = keys | (0x1 << 11)
-	}
-	if kbState[sdl.SCANCODE_Z] == 1 {
-		keys = keys | (0x1 << 12)
-	}
-	if kbState[sdl.SCANCODE_X] == 1 {
-		keys = keys | (0x1 << 13)
-	}
-	if kbState[sdl.SCANCODE_C] == 1 {
-		keys = keys | (0x1 << 14)
+	file, err := os.Create(opts.recordFile)
+	if err != nil {
+		fmt.Printf("could not save recording: %v\n", err)
+		return
 	}
-	if kbState[sdl.SCANCODE_V] == 1 {
-		keys = keys | (0x1 << 15)
+	defer file.Close()
+	if err := opts.input.Write(file); err != nil {
+		fmt.Printf("could not save recording: %v\n", err)
+		return
 	}
-	return keys
+	fmt.Printf("Saved recording to %s\n", opts.recordFile)
 }
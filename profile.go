@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/profile"
+)
+
+// startProfile starts profiling, if a profile was asked for, and returns the
+// hook to set as Chip8.Trace and a function that writes the report to stderr
+// and the pprof profile to the file once the Chip8 has stopped. Without a
+// profile the hook is nil.
+func startProfile(programFile string, opts options) (func(e *chip8.TraceEntry), func()) {
+	if opts.profileFile == "" {
+		return nil, func() {}
+	}
+	p := profile.New()
+	return p.Trace, func() {
+		if err := p.Report(os.Stderr); err != nil {
+			fmt.Fprintf(os.Stderr, "could not write profile report: %v\n", err)
+		}
+		file, err := os.Create(opts.profileFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not write profile: %v\n", err)
+			return
+		}
+		defer file.Close()
+		if err := p.WritePprof(file, filepath.Base(programFile)); err != nil {
+			fmt.Fprintf(os.Stderr, "could not write profile: %v\n", err)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Saved profile to %s\n", opts.profileFile)
+	}
+}
+
+// startHooks starts the trace and the profile the options ask for, and
+// returns the hook to set as Chip8.Trace, which is nil if neither was asked
+// for, and a function that finishes them once the Chip8 has stopped.
+func startHooks(programFile string, opts options) (func(e *chip8.TraceEntry), func()) {
+	trace, stopTrace := startTrace(opts)
+	prof, stopProfile := startProfile(programFile, opts)
+	stop := func() {
+		stopTrace()
+		stopProfile()
+	}
+	switch {
+	case trace == nil:
+		return prof, stop
+	case prof == nil:
+		return trace, stop
+	}
+	return func(e *chip8.TraceEntry) {
+		trace(e)
+		prof(e)
+	}, stop
+}
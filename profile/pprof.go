@@ -0,0 +1,207 @@
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"sort"
+)
+
+// pprof profiles are gzipped protocol buffers, in the format described by
+// profile.proto in github.com/google/pprof. The few messages needed are
+// encoded by hand, to save depending on a protobuf library. These are the
+// field numbers used.
+const (
+	// Profile
+	profileSampleType  = 1
+	profileSample      = 2
+	profileMapping     = 3
+	profileLocation    = 4
+	profileFunction    = 5
+	profileStringTable = 6
+	profilePeriodType  = 11
+	profilePeriod      = 12
+
+	// ValueType
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	// Sample
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	// Mapping
+	mappingID           = 1
+	mappingMemoryLimit  = 3
+	mappingFilename     = 5
+	mappingHasFunctions = 7
+
+	// Location
+	locationID        = 1
+	locationMappingID = 2
+	locationAddress   = 3
+	locationLine      = 4
+
+	// Line
+	lineFunctionID = 1
+	lineLine       = 2
+
+	// Function
+	functionID       = 1
+	functionName     = 2
+	functionFilename = 4
+)
+
+// message builds an encoded protocol buffer message.
+type message struct {
+	bytes.Buffer
+}
+
+func (m *message) varint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	m.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+// uint adds a varint field. Zero values are left out, as protobuf does.
+func (m *message) uint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	m.varint(uint64(field)<<3 | 0)
+	m.varint(v)
+}
+
+// bytes adds a length delimited field, which is a string or a message.
+func (m *message) bytes(field int, b []byte) {
+	m.varint(uint64(field)<<3 | 2)
+	m.varint(uint64(len(b)))
+	m.Write(b)
+}
+
+// packed adds a packed repeated varint field.
+func (m *message) packed(field int, vs []uint64) {
+	var inner message
+	for _, v := range vs {
+		inner.varint(v)
+	}
+	m.bytes(field, inner.Bytes())
+}
+
+// stringTable is a profile's string table, which every string is an index into.
+type stringTable struct {
+	table []string
+	index map[string]uint64
+}
+
+func (s *stringTable) add(str string) uint64 {
+	if s.index == nil {
+		s.index = map[string]uint64{"": 0}
+		s.table = []string{""}
+	}
+	if i, ok := s.index[str]; ok {
+		return i
+	}
+	s.index[str] = uint64(len(s.table))
+	s.table = append(s.table, str)
+	return s.index[str]
+}
+
+// WritePprof writes the profile to w in pprof's format. Each instruction
+// executed is a sample, with its call stack made of the CALL instructions
+// that led to it, and each subroutine is a function. name is used as the
+// file name of the code, e.g. the ROM's file name.
+func (p *Profiler) WritePprof(w io.Writer, name string) error {
+	var strs stringTable
+	var prof message
+
+	valueType := func(typ, unit string) []byte {
+		var vt message
+		vt.uint(valueTypeType, strs.add(typ))
+		vt.uint(valueTypeUnit, strs.add(unit))
+		return vt.Bytes()
+	}
+	prof.bytes(profileSampleType, valueType("instructions", "count"))
+
+	var mapping message
+	mapping.uint(mappingID, 1)
+	mapping.uint(mappingMemoryLimit, 0x10000)
+	mapping.uint(mappingFilename, strs.add(name))
+	mapping.uint(mappingHasFunctions, 1)
+	prof.bytes(profileMapping, mapping.Bytes())
+
+	// Sort the samples so that the same run always writes the same profile.
+	keys := make([]string, 0, len(p.samples))
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	type locationKey struct {
+		addr uint16
+		fn   int
+	}
+	locations := map[locationKey]uint64{}
+	functions := map[int]uint64{}
+	var locs, funcs []message
+	function := func(target int) uint64 {
+		if id, ok := functions[target]; ok {
+			return id
+		}
+		id := uint64(len(funcs) + 1)
+		functions[target] = id
+		var f message
+		f.uint(functionID, id)
+		f.uint(functionName, strs.add(funcName(target)))
+		f.uint(functionFilename, strs.add(name))
+		funcs = append(funcs, f)
+		return id
+	}
+	location := func(addr uint16, target int) uint64 {
+		key := locationKey{addr, target}
+		if id, ok := locations[key]; ok {
+			return id
+		}
+		id := uint64(len(locs) + 1)
+		locations[key] = id
+		var line message
+		line.uint(lineFunctionID, function(target))
+		line.uint(lineLine, uint64(addr))
+		var loc message
+		loc.uint(locationID, id)
+		loc.uint(locationMappingID, 1)
+		loc.uint(locationAddress, uint64(addr))
+		loc.bytes(locationLine, line.Bytes())
+		locs = append(locs, loc)
+		return id
+	}
+
+	for _, key := range keys {
+		s := p.samples[key]
+		ids := make([]uint64, len(s.addrs))
+		for i := range s.addrs {
+			ids[i] = location(s.addrs[i], s.funcs[i])
+		}
+		var sm message
+		sm.packed(sampleLocationID, ids)
+		sm.packed(sampleValue, []uint64{s.count})
+		prof.bytes(profileSample, sm.Bytes())
+	}
+	for i := range locs {
+		prof.bytes(profileLocation, locs[i].Bytes())
+	}
+	for i := range funcs {
+		prof.bytes(profileFunction, funcs[i].Bytes())
+	}
+	prof.bytes(profilePeriodType, valueType("instructions", "count"))
+	prof.uint(profilePeriod, 1)
+	for _, str := range strs.table {
+		prof.bytes(profileStringTable, []byte(str))
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(prof.Bytes()); err != nil {
+		return err
+	}
+	return gz.Close()
+}
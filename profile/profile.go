@@ -0,0 +1,158 @@
+// Package profile counts where a Chip8 spends its instructions, for
+// optimising ROMs that have to run within the speed of the original hardware.
+// A Profiler is fed every instruction executed through Chip8.Trace, and
+// counts executions per address and per instruction, and the instructions
+// executed inside each subroutine. It writes a text report of the hot spots,
+// and a profile in pprof's format, so that go tool pprof can show the
+// subroutines as a call graph or flame graph.
+package profile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zabrahams/gochip8/chip8"
+)
+
+const (
+	// mainFunc stands for the code that isn't in any subroutine.
+	mainFunc = -1
+	// unknownFunc stands for a subroutine that was called before profiling
+	// started.
+	unknownFunc = -2
+)
+
+// Profiler builds a profile from the instructions passed to Trace.
+type Profiler struct {
+	total  uint64
+	frames uint64
+	pcs    map[uint16]*pcStats
+	ops    map[string]uint64
+	subs   map[int]*subStats
+	// targets is the address of the subroutine called by each frame of the
+	// call stack, outermost first.
+	targets []int
+	samples map[string]*sample
+}
+
+// pcStats is how often the instruction at an address was executed.
+type pcStats struct {
+	count uint64
+	instr []byte
+}
+
+// subStats is the time spent in a subroutine. Inclusive counts the
+// instructions executed while it was anywhere on the call stack, self only
+// those executed in it directly.
+type subStats struct {
+	calls     uint64
+	inclusive uint64
+	self      uint64
+}
+
+// sample is a count of the instructions executed with the same call stack.
+// addrs is the address of the instruction and then the CALL of each frame,
+// innermost first, and funcs is the subroutine each of them is in.
+type sample struct {
+	addrs []uint16
+	funcs []int
+	count uint64
+}
+
+func New() *Profiler {
+	return &Profiler{
+		pcs:     map[uint16]*pcStats{},
+		ops:     map[string]uint64{},
+		subs:    map[int]*subStats{mainFunc: {calls: 1}},
+		samples: map[string]*sample{},
+	}
+}
+
+// Trace counts the instruction in e. It's meant to be used as Chip8.Trace.
+func (p *Profiler) Trace(e *chip8.TraceEntry) {
+	p.total++
+	if e.Frame+1 > p.frames {
+		p.frames = e.Frame + 1
+	}
+	pc := p.pcs[e.PC]
+	if pc == nil {
+		pc = &pcStats{instr: append([]byte{}, e.Instr...)}
+		p.pcs[e.PC] = pc
+	}
+	pc.count++
+	p.ops[strings.Fields(e.Mnemonic())[0]]++
+
+	// The stack is followed by watching for CALLs, but the depth comes from
+	// the Chip8, so that returns and anything unusual, e.g. starting in the
+	// middle of a subroutine, are followed too.
+	for len(p.targets) > len(e.Stack) {
+		p.targets = p.targets[:len(p.targets)-1]
+	}
+	for len(p.targets) < len(e.Stack) {
+		p.targets = append(p.targets, unknownFunc)
+	}
+	current := mainFunc
+	if len(p.targets) > 0 {
+		current = p.targets[len(p.targets)-1]
+	}
+	p.sub(current).self++
+	counted := map[int]bool{mainFunc: true}
+	p.subs[mainFunc].inclusive++
+	for _, target := range p.targets {
+		if !counted[target] {
+			counted[target] = true
+			p.sub(target).inclusive++
+		}
+	}
+	p.sample(e, current)
+
+	if op := e.Opcode(); op>>12 == 0x2 {
+		target := int(op & 0xFFF)
+		p.targets = append(p.targets, target)
+		p.sub(target).calls++
+	}
+}
+
+func (p *Profiler) sub(target int) *subStats {
+	s := p.subs[target]
+	if s == nil {
+		s = &subStats{}
+		p.subs[target] = s
+	}
+	return s
+}
+
+// sample counts e in the sample for its call stack.
+func (p *Profiler) sample(e *chip8.TraceEntry, current int) {
+	addrs := []uint16{e.PC}
+	funcs := []int{current}
+	for i := len(e.Stack) - 1; i >= 0; i-- {
+		caller := mainFunc
+		if i > 0 {
+			caller = p.targets[i-1]
+		}
+		addrs = append(addrs, e.Stack[i]-2)
+		funcs = append(funcs, caller)
+	}
+	var key strings.Builder
+	for i := range addrs {
+		fmt.Fprintf(&key, "%x:%x/", addrs[i], funcs[i])
+	}
+	s := p.samples[key.String()]
+	if s == nil {
+		s = &sample{addrs: addrs, funcs: funcs}
+		p.samples[key.String()] = s
+	}
+	s.count++
+}
+
+// funcName returns the name of a subroutine, as in the report and profile.
+func funcName(target int) string {
+	switch target {
+	case mainFunc:
+		return "main"
+	case unknownFunc:
+		return "unknown"
+	}
+	return fmt.Sprintf("sub_%03X", target)
+}
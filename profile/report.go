@@ -0,0 +1,89 @@
+package profile
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/zabrahams/gochip8/chip8"
+)
+
+// TOP_ADDRESSES is the number of addresses listed as hot spots in the report.
+const TOP_ADDRESSES = 20
+
+// Report writes a text report of the profile to w: the most executed
+// addresses, the instructions executed most, the time spent in each
+// subroutine, and the disassembly of every instruction executed, annotated
+// with how often it was.
+func (p *Profiler) Report(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d instructions executed in %d frames\n", p.total, p.frames)
+
+	addrs := make([]uint16, 0, len(p.pcs))
+	for addr := range p.pcs {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		ci, cj := p.pcs[addrs[i]].count, p.pcs[addrs[j]].count
+		return ci > cj || ci == cj && addrs[i] < addrs[j]
+	})
+	fmt.Fprintf(&b, "\nTop addresses:\n%10s %7s  %-7s %s\n", "count", "%", "address", "instruction")
+	for i, addr := range addrs {
+		if i == TOP_ADDRESSES {
+			break
+		}
+		pc := p.pcs[addr]
+		fmt.Fprintf(&b, "%10d %6.2f%%  0x%03X   %s\n", pc.count, p.percent(pc.count), addr, chip8.Mnemonic(pc.instr))
+	}
+
+	ops := make([]string, 0, len(p.ops))
+	for op := range p.ops {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		ci, cj := p.ops[ops[i]], p.ops[ops[j]]
+		return ci > cj || ci == cj && ops[i] < ops[j]
+	})
+	fmt.Fprintf(&b, "\nInstructions:\n%10s %7s  %s\n", "count", "%", "instruction")
+	for _, op := range ops {
+		fmt.Fprintf(&b, "%10d %6.2f%%  %s\n", p.ops[op], p.percent(p.ops[op]), op)
+	}
+
+	subs := make([]int, 0, len(p.subs))
+	for target := range p.subs {
+		subs = append(subs, target)
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		ci, cj := p.subs[subs[i]].inclusive, p.subs[subs[j]].inclusive
+		return ci > cj || ci == cj && subs[i] < subs[j]
+	})
+	fmt.Fprintf(&b, "\nSubroutines:\n%10s %10s %7s %10s %7s  %s\n", "calls", "inclusive", "%", "self", "%", "subroutine")
+	for _, target := range subs {
+		s := p.subs[target]
+		fmt.Fprintf(&b, "%10d %10d %6.2f%% %10d %6.2f%%  %s\n",
+			s.calls, s.inclusive, p.percent(s.inclusive), s.self, p.percent(s.self), funcName(target))
+	}
+
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	fmt.Fprintf(&b, "\nAnnotated disassembly:\n")
+	for i, addr := range addrs {
+		pc := p.pcs[addr]
+		if _, ok := p.subs[int(addr)]; ok {
+			fmt.Fprintf(&b, "%s:\n", funcName(int(addr)))
+		} else if i > 0 && int(addrs[i-1])+len(p.pcs[addrs[i-1]].instr) < int(addr) {
+			// Leave a gap where there's code that was never executed.
+			fmt.Fprintln(&b, "          ...")
+		}
+		fmt.Fprintf(&b, "%10d  0x%03X  %-8X  %s\n", pc.count, addr, pc.instr, chip8.Mnemonic(pc.instr))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (p *Profiler) percent(n uint64) float64 {
+	if p.total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(p.total)
+}
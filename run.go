@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/console"
+	"github.com/zabrahams/gochip8/debugger"
+	"github.com/zabrahams/gochip8/frontend"
+	"github.com/zabrahams/gochip8/tui"
+)
+
+func debug(programFile string, opts options) {
+	fmt.Println("Starting Chip8 Emulator")
+
+	// The debugger takes over the terminal, so it draws the screen itself
+	// rather than letting the term frontend draw over it.
+	showScreen := opts.frontend == "term"
+	if showScreen {
+		opts.frontend = "null"
+	}
+	fe, err := frontend.New(opts.frontend)
+	if err != nil {
+		panic(err)
+	}
+	defer fe.Close()
+
+	c8 := newChip8(fe.Audio, opts)
+	c8.Provenance = chip8.NewProvenance(c8.MemorySize())
+	var stopCoverage func()
+	c8.Coverage, stopCoverage = startCoverage(opts)
+	defer stopCoverage()
+	c8.Load(programFile)
+	c8.History = chip8.NewRewind(chip8.REWIND_FRAMES)
+	c8.History.Record(c8)
+	c8.Journal = chip8.NewJournal(chip8.JOURNAL_SIZE)
+	var stopHooks func()
+	c8.Trace, stopHooks = startHooks(programFile, opts)
+	defer stopHooks()
+	d := debugger.New(c8)
+	commands := console.New(c8, d)
+
+	ui := tui.New(c8, d, os.Stdout)
+	ui.ShowScreen = showScreen
+	ui.Command = func(line string) (string, error) {
+		var out bytes.Buffer
+		err := commands.Exec(&out, line)
+		return out.String(), err
+	}
+	ui.History = commands.History
+	if err := ui.Start(); err != nil {
+		panic(err)
+	}
+	for ui.Update() {
+		input := fe.Input.Poll()
+		if input.Quit {
+			break
+		}
+		for _, hotkey := range input.Hotkeys {
+			if hotkey == chip8.HotkeyPause {
+				ui.Pause()
+			}
+		}
+		if opts.input == nil || !opts.input.Replaying() {
+			c8.Keyboard.Update(input.Keys)
+		}
+		fe.Display.Update(c8.FrameBuffer)
+		time.Sleep(time.Millisecond)
+	}
+	ui.Close()
+	writeRecording(opts)
+	fmt.Println("Closing Chip8 Emulator")
+}
+
+func run(programFile string, opts options) {
+	fmt.Println("Starting Chip8 Emulator")
+
+	fe, err := frontend.New(opts.frontend)
+	if err != nil {
+		panic(err)
+	}
+	defer fe.Close()
+
+	c8 := newChip8(fe.Audio, opts)
+	var stopCoverage func()
+	c8.Coverage, stopCoverage = startCoverage(opts)
+	defer stopCoverage()
+	c8.Load(programFile)
+	c8.History = chip8.NewRewind(chip8.REWIND_FRAMES)
+	var stopHooks func()
+	c8.Trace, stopHooks = startHooks(programFile, opts)
+	defer stopHooks()
+	faults := c8.Run()
+	running := true
+	rewinding := false
+	// rewoundAll is set once rewinding has run out of history, so that it's
+	// only reported once.
+	rewoundAll := false
+	stateFile := programFile + ".state"
+	// There's no point polling and redrawing faster than the timers tick, so
+	// each time round waits for the next tick.
+	tick := time.NewTicker(chip8.TIMER_TICK * time.Millisecond)
+	defer tick.Stop()
+	for running {
+		input := fe.Input.Poll()
+		if input.Quit {
+			println("Quit")
+			running = false
+		}
+		for _, hotkey := range input.Hotkeys {
+			switch hotkey {
+			case chip8.HotkeySaveState:
+				wasRunning := faults != nil
+				if err := pause(c8, faults); err != nil {
+					fmt.Printf("Chip8 halted: %v\n", err)
+					wasRunning = false
+				}
+				if err := saveState(c8, stateFile); err != nil {
+					fmt.Printf("could not save state: %v\n", err)
+				} else {
+					fmt.Printf("Saved state to %s\n", stateFile)
+				}
+				faults = nil
+				if wasRunning {
+					faults = c8.Run()
+				}
+			case chip8.HotkeyLoadState:
+				if err := pause(c8, faults); err != nil {
+					fmt.Printf("Chip8 halted: %v\n", err)
+				}
+				if err := loadState(c8, stateFile); err != nil {
+					fmt.Printf("could not load state: %v\n", err)
+				} else {
+					fmt.Printf("Loaded state from %s\n", stateFile)
+				}
+				faults = c8.Run()
+			}
+		}
+		select {
+		case err, ok := <-faults:
+			if ok {
+				fmt.Printf("Chip8 halted: %v\n", err)
+			}
+			faults = nil
+		default:
+		}
+		if input.Rewind {
+			if !rewinding {
+				if err := pause(c8, faults); err != nil {
+					fmt.Printf("Chip8 halted: %v\n", err)
+				}
+				faults = nil
+				rewinding = true
+			}
+			if err := c8.History.StepBack(c8); err != nil && !rewoundAll {
+				fmt.Printf("could not rewind: %v\n", err)
+				rewoundAll = true
+			}
+			fe.Display.Update(c8.FrameBuffer)
+			<-tick.C
+			continue
+		} else if rewinding {
+			rewinding, rewoundAll = false, false
+			faults = c8.Run()
+		}
+		if opts.input == nil || !opts.input.Replaying() {
+			c8.Keyboard.Update(input.Keys)
+		}
+		fe.Display.Update(c8.FrameBuffer)
+		<-tick.C
+	}
+	pause(c8, faults)
+	writeRecording(opts)
+	fmt.Println("Closing Chip8 Emulator")
+}
+
+// pause stops c8 if it's running. If it faulted before it could be stopped the
+// fault is returned.
+func pause(c8 *chip8.Chip8, faults <-chan error) error {
+	if faults == nil {
+		return nil
+	}
+	select {
+	case c8.Stop <- struct{}{}:
+	case err, ok := <-faults:
+		if ok {
+			return err
+		}
+	}
+	return nil
+}
+
+func saveState(c8 *chip8.Chip8, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := c8.SaveState(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func loadState(c8 *chip8.Chip8, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return c8.LoadState(file)
+}
@@ -7,6 +7,10 @@ import (
 
 const SCALING_FACTOR = 10
 
+// palette holds the colour used for each of the values a pixel can take.
+// Programs that don't use the XO-CHIP planes only ever use the first two.
+var palette = [1 << chip8.PLANES]uint32{0xff000000, 0xffffffff, 0xffaaaaaa, 0xff555555}
+
 type Screen struct {
 	window *sdl.Window
 }
@@ -16,7 +20,7 @@ func NewScreen() *Screen {
 		panic(err)
 	}
 
-	window, err := sdl.CreateWindow("gochip8", sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED, 64*SCALING_FACTOR, 32*SCALING_FACTOR, sdl.WINDOW_INPUT_FOCUS|sdl.WINDOW_SHOWN)
+	window, err := sdl.CreateWindow("gochip8", sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED, chip8.LORES_WIDTH*SCALING_FACTOR, chip8.LORES_HEIGHT*SCALING_FACTOR, sdl.WINDOW_INPUT_FOCUS|sdl.WINDOW_SHOWN)
 	if err != nil {
 		panic(err)
 	}
@@ -33,19 +37,24 @@ func NewScreen() *Screen {
 	return &Screen{window: window}
 }
 
+// Update redraws the window from the frame buffer. The pixels are scaled so
+// that both the 64x32 and the 128x64 modes fill the window, and coloured from
+// the palette.
 func (s *Screen) Update(fb *chip8.FrameBuffer) {
-	rects := []sdl.Rect{}
-	for i, line := range fb.Buffer {
-		for j := 0; j < 64; j++ {
-			var bit uint64 = 1 << uint(63-j)
-			if (line & bit) > 0 {
+	width, height := fb.Width, fb.Height
+	scale := int32(chip8.LORES_WIDTH * SCALING_FACTOR / width)
+	rects := make([][]sdl.Rect, len(palette))
+	for i := 0; i < height; i++ {
+		for j := 0; j < width; j++ {
+			colour := fb.Pixel(j, i)
+			if colour > 0 {
 				rect := &sdl.Rect{
-					X: int32(j * SCALING_FACTOR),
-					Y: int32(i * SCALING_FACTOR),
-					W: SCALING_FACTOR,
-					H: SCALING_FACTOR,
+					X: int32(j) * scale,
+					Y: int32(i) * scale,
+					W: scale,
+					H: scale,
 				}
-				rects = append(rects, *rect)
+				rects[colour] = append(rects[colour], *rect)
 			}
 		}
 
@@ -54,11 +63,13 @@ func (s *Screen) Update(fb *chip8.FrameBuffer) {
 	if err != nil {
 		panic(err)
 	}
-	if len(rects) > 0 {
-		surface.FillRect(nil, 0)
-		surface.FillRects(rects, 0xffffffff)
-		s.window.UpdateSurface()
+	surface.FillRect(nil, palette[0])
+	for colour, cRects := range rects {
+		if len(cRects) > 0 {
+			surface.FillRects(cRects, palette[colour])
+		}
 	}
+	s.window.UpdateSurface()
 
 }
 
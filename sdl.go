@@ -0,0 +1,8 @@
+//go:build !nosdl
+// +build !nosdl
+
+package main
+
+// The SDL frontend is linked in unless building with the nosdl tag, which
+// gives a binary that runs without SDL installed.
+import _ "github.com/zabrahams/gochip8/frontend/sdlfrontend"
@@ -0,0 +1,172 @@
+// Package symbols reads and writes symbol files, which map the addresses in a
+// Chip8 program back to the source it was assembled from.
+//
+// A symbol file is text with one entry per line: an address, the kind of
+// entry, and its value, separated by spaces. Blank lines and lines starting
+// with # are ignored.
+//
+//	0x200 label main
+//	0x200 line game.8o:12
+//
+// A label entry names the address, and a line entry gives the file and line
+// that the instruction at the address was assembled from. Only instructions
+// have line entries, not data.
+package symbols
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrBadSymbols is returned by Parse and Load when they're given something
+// that isn't a symbol file.
+var ErrBadSymbols = errors.New("not a valid symbol file")
+
+// Source is a line of a source file.
+type Source struct {
+	File string
+	Line int
+}
+
+func (s Source) String() string {
+	return fmt.Sprintf("%s:%d", s.File, s.Line)
+}
+
+// Table holds the symbols of a program.
+type Table struct {
+	labels map[uint16]string
+	lines  map[uint16]Source
+}
+
+// New returns an empty Table.
+func New() *Table {
+	return &Table{labels: map[uint16]string{}, lines: map[uint16]Source{}}
+}
+
+// Load reads the symbol file filename.
+func Load(filename string) (*Table, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	t, err := Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return t, nil
+}
+
+// Parse reads a symbol file from r.
+func Parse(r io.Reader) (*Table, error) {
+	t := New()
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.SplitN(text, " ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: %w", n, ErrBadSymbols)
+		}
+		addr, err := strconv.ParseUint(fields[0], 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad address %q: %w", n, fields[0], ErrBadSymbols)
+		}
+		value := strings.TrimSpace(fields[2])
+		switch fields[1] {
+		case "label":
+			if value == "" || strings.ContainsAny(value, " \t") {
+				return nil, fmt.Errorf("line %d: bad label %q: %w", n, value, ErrBadSymbols)
+			}
+			t.SetLabel(uint16(addr), value)
+		case "line":
+			colon := strings.LastIndex(value, ":")
+			line, err := strconv.Atoi(value[colon+1:])
+			if colon < 1 || err != nil {
+				return nil, fmt.Errorf("line %d: bad source line %q: %w", n, value, ErrBadSymbols)
+			}
+			t.SetLine(uint16(addr), Source{File: value[:colon], Line: line})
+		default:
+			return nil, fmt.Errorf("line %d: unknown entry %q: %w", n, fields[1], ErrBadSymbols)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Write writes the table to w as a symbol file.
+func (t *Table) Write(w io.Writer) error {
+	addrs := t.Labels()
+	for _, addr := range t.Lines() {
+		if _, ok := t.labels[addr]; !ok {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+
+	b := bufio.NewWriter(w)
+	for _, addr := range addrs {
+		if name, ok := t.labels[addr]; ok {
+			fmt.Fprintf(b, "0x%03X label %s\n", addr, name)
+		}
+		if src, ok := t.lines[addr]; ok {
+			fmt.Fprintf(b, "0x%03X line %s\n", addr, src)
+		}
+	}
+	return b.Flush()
+}
+
+// SetLabel names addr, unless it's already been named. A program can give an
+// address more than one name, but the first is the one that's shown.
+func (t *Table) SetLabel(addr uint16, name string) {
+	if _, ok := t.labels[addr]; !ok {
+		t.labels[addr] = name
+	}
+}
+
+// Label returns the name of addr.
+func (t *Table) Label(addr uint16) (string, bool) {
+	name, ok := t.labels[addr]
+	return name, ok
+}
+
+// Labels returns every named address, in order.
+func (t *Table) Labels() []uint16 {
+	addrs := make([]uint16, 0, len(t.labels))
+	for addr := range t.labels {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// SetLine records that the instruction at addr was assembled from src.
+func (t *Table) SetLine(addr uint16, src Source) {
+	t.lines[addr] = src
+}
+
+// Line returns the source line the instruction at addr was assembled from.
+func (t *Table) Line(addr uint16) (Source, bool) {
+	src, ok := t.lines[addr]
+	return src, ok
+}
+
+// Lines returns the address of every instruction with a source line, in order.
+func (t *Table) Lines() []uint16 {
+	addrs := make([]uint16, 0, len(t.lines))
+	for addr := range t.lines {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
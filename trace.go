@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/trace"
+)
+
+// traceOptions holds the options for tracing execution, and for the trace
+// command that reads the traces back.
+type traceOptions struct {
+	file   string
+	format string
+	pcs    string
+	ops    string
+	frames string
+	grep   string
+}
+
+// register adds the filter options, and either the options to write a trace
+// or, for the trace command, the option to grep one.
+func (t *traceOptions) register(flags *flag.FlagSet, reading bool) {
+	flags.StringVar(&t.pcs, "trace-pc", "", "only trace instructions in this address range, e.g. 0x200-0x2FF")
+	flags.StringVar(&t.ops, "trace-ops", "", "only trace these instructions, e.g. DRW,CALL")
+	flags.StringVar(&t.frames, "trace-frames", "", "only trace these frames, e.g. 10-20")
+	if reading {
+		flags.StringVar(&t.grep, "grep", "", "only print the entries matching this regular expression")
+		return
+	}
+	flags.StringVar(&t.file, "trace", "", "file to trace every instruction executed to")
+	flags.StringVar(&t.format, "trace-format", trace.FORMAT_TEXT, "trace format: text or binary")
+}
+
+// filter builds the trace filter the options ask for.
+func (t *traceOptions) filter() trace.Filter {
+	var f trace.Filter
+	if t.pcs != "" {
+		from, to, err := trace.ParseRange(t.pcs, 0xFFFF)
+		if err != nil {
+			panic(fmt.Sprintf("bad -trace-pc: %v", err))
+		}
+		f.HasPC, f.FromPC, f.ToPC = true, uint16(from), uint16(to)
+	}
+	if t.frames != "" {
+		from, to, err := trace.ParseRange(t.frames, 1<<63)
+		if err != nil {
+			panic(fmt.Sprintf("bad -trace-frames: %v", err))
+		}
+		f.HasFrames, f.FromFrame, f.ToFrame = true, from, to
+	}
+	if t.ops != "" {
+		f.Ops = strings.Split(t.ops, ",")
+	}
+	return f
+}
+
+// startTrace opens the trace file, if one was asked for, and returns the hook
+// to set as Chip8.Trace and a function that finishes the trace once the Chip8
+// has stopped. Without a trace file the hook is nil.
+func startTrace(opts options) (func(e *chip8.TraceEntry), func()) {
+	if opts.trace.file == "" {
+		return nil, func() {}
+	}
+	file, err := os.Create(opts.trace.file)
+	if err != nil {
+		panic(err)
+	}
+	tw, err := trace.NewWriter(file, opts.trace.format)
+	if err != nil {
+		panic(err)
+	}
+	tw.Filter = opts.trace.filter()
+	tw.Symbols = opts.symbols
+	return tw.Trace, func() {
+		if err := tw.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "could not write trace: %v\n", err)
+		}
+		file.Close()
+	}
+}
+
+// runTrace decodes a binary trace and prints the entries that get through
+// the filters as text.
+func runTrace(traceFile string, opts options) {
+	file, err := os.Open(traceFile)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+	tr, err := trace.NewReader(file)
+	if err != nil {
+		panic(err)
+	}
+	filter := opts.trace.filter()
+	var grep *regexp.Regexp
+	if opts.trace.grep != "" {
+		grep = regexp.MustCompile(opts.trace.grep)
+	}
+	for {
+		e, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			panic(err)
+		}
+		if !filter.Match(e) {
+			continue
+		}
+		line := trace.Format(e, opts.symbols)
+		if grep == nil || grep.MatchString(line) {
+			fmt.Println(line)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/zabrahams/gochip8/chip8"
+)
+
+const TRACE_VERSION = 1
+
+var traceMagic = [4]byte{'G', 'C', '8', 'T'}
+
+// ErrBadTrace is returned by NewReader and Reader.Next when they're given
+// something that isn't a binary trace they understand.
+var ErrBadTrace = errors.New("not a valid binary trace")
+
+// A binary trace is a header followed by one record per entry. To keep it
+// small, the cycle and frame are stored as the difference from the entry
+// before, and the numbers are varints:
+//
+//	cycle delta, frame delta   uvarint
+//	PC, I                      2 bytes each, big endian
+//	instruction                length byte, then the bytes
+//	changes                    count byte, then a register and value byte each
+//	writes                     uvarint count, then for each the address as 2
+//	                           bytes, a uvarint length and the bytes
+type traceHeader struct {
+	Magic   [4]byte
+	Version uint16
+}
+
+func writeHeader(w io.Writer) error {
+	return binary.Write(w, binary.BigEndian, traceHeader{Magic: traceMagic, Version: TRACE_VERSION})
+}
+
+// writeEntry writes e, following last, which is nil for the first entry.
+func writeEntry(w *bufio.Writer, last, e *chip8.TraceEntry) error {
+	var prevCycle, prevFrame uint64
+	if last != nil {
+		prevCycle, prevFrame = last.Cycle, last.Frame
+	}
+	var buf [binary.MaxVarintLen64]byte
+	uvarint := func(v uint64) {
+		w.Write(buf[:binary.PutUvarint(buf[:], v)])
+	}
+	word := func(v uint16) {
+		w.WriteByte(byte(v >> 8))
+		w.WriteByte(byte(v))
+	}
+
+	uvarint(e.Cycle - prevCycle)
+	uvarint(e.Frame - prevFrame)
+	word(e.PC)
+	word(e.I)
+	w.WriteByte(byte(len(e.Instr)))
+	w.Write(e.Instr)
+	w.WriteByte(byte(len(e.Changes)))
+	for _, c := range e.Changes {
+		w.WriteByte(c.V)
+		w.WriteByte(c.Value)
+	}
+	uvarint(uint64(len(e.Writes)))
+	for _, mw := range e.Writes {
+		word(mw.Addr)
+		uvarint(uint64(len(mw.Data)))
+		w.Write(mw.Data)
+	}
+	// A bufio.Writer keeps the first error it hits and returns it from then on.
+	_, err := w.Write(nil)
+	return err
+}
+
+// Reader decodes a binary trace.
+type Reader struct {
+	r    *bufio.Reader
+	last chip8.TraceEntry
+}
+
+// NewReader reads the header of the binary trace in r.
+func NewReader(r io.Reader) (*Reader, error) {
+	var header traceHeader
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadTrace, err)
+	}
+	if header.Magic != traceMagic {
+		return nil, ErrBadTrace
+	}
+	if header.Version != TRACE_VERSION {
+		return nil, fmt.Errorf("%w: unknown version %d", ErrBadTrace, header.Version)
+	}
+	return &Reader{r: bufio.NewReader(r)}, nil
+}
+
+// Next returns the next entry in the trace, or io.EOF at the end of it.
+func (tr *Reader) Next() (*chip8.TraceEntry, error) {
+	if _, err := tr.r.Peek(1); err == io.EOF {
+		return nil, io.EOF
+	}
+	e := &chip8.TraceEntry{}
+	if err := tr.read(e); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("%w: %v", ErrBadTrace, err)
+	}
+	tr.last = *e
+	return e, nil
+}
+
+// read decodes an entry.
+func (tr *Reader) read(e *chip8.TraceEntry) error {
+	cycles, err := binary.ReadUvarint(tr.r)
+	if err != nil {
+		return err
+	}
+	frames, err := binary.ReadUvarint(tr.r)
+	if err != nil {
+		return err
+	}
+	e.Cycle, e.Frame = tr.last.Cycle+cycles, tr.last.Frame+frames
+	var fixed [5]byte
+	if _, err := io.ReadFull(tr.r, fixed[:]); err != nil {
+		return err
+	}
+	e.PC = binary.BigEndian.Uint16(fixed[0:])
+	e.I = binary.BigEndian.Uint16(fixed[2:])
+	e.Instr = make([]byte, fixed[4])
+	if _, err := io.ReadFull(tr.r, e.Instr); err != nil {
+		return err
+	}
+	n, err := tr.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < int(n); i++ {
+		var change [2]byte
+		if _, err := io.ReadFull(tr.r, change[:]); err != nil {
+			return err
+		}
+		e.Changes = append(e.Changes, chip8.RegisterChange{V: change[0], Value: change[1]})
+	}
+	writes, err := binary.ReadUvarint(tr.r)
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < writes; i++ {
+		var addr [2]byte
+		if _, err := io.ReadFull(tr.r, addr[:]); err != nil {
+			return err
+		}
+		size, err := binary.ReadUvarint(tr.r)
+		if err != nil {
+			return err
+		}
+		if size > 0x10000 {
+			return fmt.Errorf("write of %d bytes", size)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(tr.r, data); err != nil {
+			return err
+		}
+		e.Writes = append(e.Writes, chip8.MemoryWrite{Addr: binary.BigEndian.Uint16(addr[:]), Data: data})
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+// Package trace logs what every instruction a Chip8 executes does, for
+// chasing timing bugs. A Writer is hooked into Chip8.Trace and writes the
+// entries that get through its Filter, either as text or in a compact binary
+// format that a Reader decodes again.
+package trace
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/symbols"
+)
+
+// The formats a trace can be written in.
+const (
+	FORMAT_TEXT   = "text"
+	FORMAT_BINARY = "binary"
+)
+
+// ErrBadFormat is returned for a format that isn't FORMAT_TEXT or
+// FORMAT_BINARY.
+var ErrBadFormat = errors.New("unknown trace format")
+
+// Filter picks the entries that are traced. The zero Filter lets everything
+// through.
+//
+// HasPC: whether FromPC and ToPC are set
+//
+// FromPC, ToPC: if HasPC, only instructions at addresses from FromPC to ToPC
+// inclusive are traced
+//
+// Ops: if set, only instructions with these mnemonics, e.g. DRW or CALL, are
+// traced
+//
+// HasFrames: whether FromFrame and ToFrame are set
+//
+// FromFrame, ToFrame: if HasFrames, only instructions executed in frames
+// FromFrame to ToFrame inclusive are traced
+type Filter struct {
+	HasPC     bool
+	FromPC    uint16
+	ToPC      uint16
+	Ops       []string
+	HasFrames bool
+	FromFrame uint64
+	ToFrame   uint64
+}
+
+// Match reports whether e gets through the filter.
+func (f Filter) Match(e *chip8.TraceEntry) bool {
+	if f.HasPC && (e.PC < f.FromPC || e.PC > f.ToPC) {
+		return false
+	}
+	if f.HasFrames && (e.Frame < f.FromFrame || e.Frame > f.ToFrame) {
+		return false
+	}
+	if len(f.Ops) == 0 {
+		return true
+	}
+	op := strings.Fields(e.Mnemonic())[0]
+	for _, want := range f.Ops {
+		if strings.EqualFold(op, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseRange parses a range such as 0x200-0x2FF or 10-20. A single number is a
+// range of one, and a missing end runs to max.
+func ParseRange(text string, max uint64) (uint64, uint64, error) {
+	from, to := text, text
+	if dash := strings.Index(text, "-"); dash >= 0 {
+		from, to = text[:dash], text[dash+1:]
+	}
+	lo, err := strconv.ParseUint(strings.TrimSpace(from), 0, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad range %q: %w", text, err)
+	}
+	hi := max
+	if strings.TrimSpace(to) != "" {
+		if hi, err = strconv.ParseUint(strings.TrimSpace(to), 0, 64); err != nil {
+			return 0, 0, fmt.Errorf("bad range %q: %w", text, err)
+		}
+	}
+	if hi < lo || hi > max {
+		return 0, 0, fmt.Errorf("bad range %q", text)
+	}
+	return lo, hi, nil
+}
+
+// Writer writes trace entries to a file. Its Trace method is meant to be used
+// as Chip8.Trace. The first error writing stops the trace and is returned by
+// Close. If Symbols is set, a text trace shows its labels and source lines.
+type Writer struct {
+	Filter  Filter
+	Symbols *symbols.Table
+
+	w      *bufio.Writer
+	format string
+	last   *chip8.TraceEntry
+	err    error
+}
+
+// NewWriter returns a Writer that writes to w in format. A binary trace
+// starts with its header straight away.
+func NewWriter(w io.Writer, format string) (*Writer, error) {
+	tw := &Writer{w: bufio.NewWriter(w), format: format}
+	switch format {
+	case FORMAT_TEXT:
+	case FORMAT_BINARY:
+		tw.err = writeHeader(tw.w)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrBadFormat, format)
+	}
+	return tw, nil
+}
+
+// Trace writes e if it gets through the filter.
+func (tw *Writer) Trace(e *chip8.TraceEntry) {
+	if tw.err != nil || !tw.Filter.Match(e) {
+		return
+	}
+	if tw.format == FORMAT_TEXT {
+		_, tw.err = fmt.Fprintln(tw.w, Format(e, tw.Symbols))
+		return
+	}
+	tw.err = writeEntry(tw.w, tw.last, e)
+	tw.last = e
+}
+
+// Close flushes what's been written and returns the first error writing, if
+// there was one. It doesn't close the underlying writer.
+func (tw *Writer) Close() error {
+	if err := tw.w.Flush(); tw.err == nil {
+		tw.err = err
+	}
+	return tw.err
+}
+
+// Format formats e as a line of text, e.g.
+//
+//	1234     20  0x20A  F355  LD [I], V5       I=0300  [0300]=01 02 03
+//
+// giving the cycle, the frame, the address, the opcode, the mnemonic, the I
+// register and then the registers and memory the instruction changed. If
+// table is set, the mnemonic uses its labels and the line ends with the source
+// line the instruction was built from.
+func Format(e *chip8.TraceEntry, table *symbols.Table) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%7d %6d  0x%03X  %-8X  %-18s I=%04X", e.Cycle, e.Frame, e.PC, e.Instr, chip8.SymbolicMnemonic(e.Instr, table), e.I)
+	for _, c := range e.Changes {
+		fmt.Fprintf(&b, "  V%X=%02X", c.V, c.Value)
+	}
+	for _, w := range e.Writes {
+		fmt.Fprintf(&b, "  [%04X]=% X", w.Addr, w.Data)
+	}
+	if table != nil {
+		if src, ok := table.Line(e.PC); ok {
+			fmt.Fprintf(&b, "  (%s)", src)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,260 @@
+package tui
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/debugger"
+	"github.com/zabrahams/gochip8/internal/terminal"
+	"github.com/zabrahams/gochip8/symbols"
+)
+
+const (
+	// DISASSEMBLY_LINES is the number of instructions shown around the PC.
+	DISASSEMBLY_LINES = 20
+	// DISASSEMBLY_BEFORE is how many of them come before the PC.
+	DISASSEMBLY_BEFORE = 8
+	// MEMORY_ROWS is the number of 16 byte rows in the memory view.
+	MEMORY_ROWS = 8
+	// LEFT_WIDTH is the width of the disassembly pane.
+	LEFT_WIDTH = 40
+)
+
+// draw redraws the whole terminal.
+func (t *TUI) draw() {
+	if !t.Running() {
+		t.rows, t.cols = terminal.Size()
+		t.panes = t.drawPanes()
+	}
+	lines := append([]string{}, t.panes...)
+	if t.ShowScreen {
+		lines = append(lines, header("Screen"))
+		lines = append(lines, drawScreen(t.c8.FrameBuffer)...)
+	}
+
+	// The output pane takes whatever's left, less the header and the bottom
+	// line.
+	lines = append(lines, header("Output"))
+	room := t.rows - len(lines) - 1
+	if room < 1 {
+		room = 1
+	}
+	start := len(t.log) - room
+	if start < 0 {
+		start = 0
+	}
+	lines = append(lines, t.log[start:]...)
+	for len(lines) < t.rows-1 {
+		lines = append(lines, "")
+	}
+
+	bottom := keyHelp
+	if t.Running() {
+		bottom = "running - r or space to pause"
+	}
+	if t.prompting {
+		bottom = ": " + t.prompt + "█"
+	}
+
+	t.out.WriteString("\x1b[H")
+	for _, line := range lines[:t.rows-1] {
+		t.out.WriteString(fit(line, t.cols) + "\x1b[K\n")
+	}
+	t.out.WriteString(fit(bottom, t.cols) + "\x1b[K\x1b[J")
+	t.out.Flush()
+	t.dirty = false
+	t.lastDraw = time.Now()
+}
+
+// drawPanes draws the panes that show the state of the stopped Chip8.
+func (t *TUI) drawPanes() []string {
+	regs := t.c8.Registers()
+	bps := t.debugger.Breakpoints()
+
+	left := []string{header("Disassembly")}
+	left = append(left, t.drawDisassembly(regs.PC, bps)...)
+
+	right := []string{header("Registers")}
+	for i := 0; i < 16; i += 4 {
+		right = append(right, fmt.Sprintf("V%X %02X  V%X %02X  V%X %02X  V%X %02X",
+			i, regs.V[i], i+1, regs.V[i+1], i+2, regs.V[i+2], i+3, regs.V[i+3]))
+	}
+	right = append(right,
+		fmt.Sprintf("I  %03X  PC %03X  SP %d", regs.I, regs.PC, len(regs.Stack)),
+		header("Timers"),
+		fmt.Sprintf("DT %02X  ST %02X  cycle %d", regs.DelayTimer, regs.SoundTimer, t.c8.Cycles()),
+		header("Stack"),
+	)
+	if len(regs.Stack) == 0 {
+		right = append(right, "(empty)")
+	}
+	for i := len(regs.Stack) - 1; i >= 0; i-- {
+		right = append(right, fmt.Sprintf("%2d  0x%03X", i, regs.Stack[i]))
+	}
+	right = append(right, header("Breakpoints"))
+	if len(bps) == 0 {
+		right = append(right, "(none)")
+	}
+	for _, bp := range bps {
+		right = append(right, fmt.Sprintf("%v (%d hits)", bp, bp.Hits))
+	}
+
+	var lines []string
+	for i := 0; i < len(left) || i < len(right); i++ {
+		var l, r string
+		if i < len(left) {
+			l = left[i]
+		}
+		if i < len(right) {
+			r = right[i]
+		}
+		lines = append(lines, fit(l, LEFT_WIDTH)+" │ "+r)
+	}
+
+	if src, ok := t.c8.SourceLine(); ok {
+		lines = append(lines, header("Source "+src.String()), t.sourceText(src))
+	}
+	lines = append(lines, header(fmt.Sprintf("Memory (I = 0x%03X)", regs.I)))
+	return append(lines, t.drawMemory()...)
+}
+
+// sourceText returns the text of the source line src, reading its file the
+// first time it's needed.
+func (t *TUI) sourceText(src symbols.Source) string {
+	lines, read := t.sources[src.File]
+	if !read {
+		if data, err := ioutil.ReadFile(src.File); err == nil {
+			lines = strings.Split(string(data), "\n")
+		}
+		t.sources[src.File] = lines
+	}
+	if src.Line < 1 || src.Line > len(lines) {
+		return "(source not found)"
+	}
+	return strings.ReplaceAll(strings.TrimRight(lines[src.Line-1], " \t\r"), "\t", "    ")
+}
+
+// drawDisassembly disassembles the instructions around pc, marking pc with ▶
+// and breakpoints with ●.
+func (t *TUI) drawDisassembly(pc uint16, bps []*debugger.Breakpoint) []string {
+	start := int(pc) - 2*DISASSEMBLY_BEFORE
+	if start < 0 {
+		start = 0
+	}
+	// Leave room for F000 nnnn instructions, which take up four bytes.
+	end := start + 4*DISASSEMBLY_LINES
+	if end > t.c8.MemorySize() {
+		end = t.c8.MemorySize()
+	}
+	mem, err := t.c8.ReadMemory(uint16(start), end-start)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	builder := chip8.DisassembleSymbols(mem, uint16(start), t.c8.Symbols, nil)
+	breaks := map[uint16]bool{}
+	for _, bp := range bps {
+		if bp.Kind == debugger.BreakPC {
+			breaks[bp.Addr] = true
+		}
+	}
+
+	var lines []string
+	for _, line := range strings.Split(builder.String(), "\n") {
+		if len(lines) == DISASSEMBLY_LINES || line == "" {
+			break
+		}
+		var addr uint16
+		fmt.Sscanf(line, "0x%X", &addr)
+		bp, here := " ", " "
+		if breaks[addr] {
+			bp = "●"
+		}
+		if addr == pc {
+			here = "▶"
+		}
+		lines = append(lines, bp+here+" "+line)
+	}
+	return lines
+}
+
+// drawMemory draws a hex dump of MEMORY_ROWS rows of memory from t.memAddr.
+func (t *TUI) drawMemory() []string {
+	var lines []string
+	for row := 0; row < MEMORY_ROWS; row++ {
+		addr := int(t.memAddr) + row*16
+		if addr+16 > t.c8.MemorySize() {
+			break
+		}
+		data, _ := t.c8.ReadMemory(uint16(addr), 16)
+		var hex, text strings.Builder
+		for i, b := range data {
+			if i == 8 {
+				hex.WriteString(" ")
+			}
+			fmt.Fprintf(&hex, "%02X ", b)
+			if b >= 0x20 && b < 0x7F {
+				text.WriteByte(b)
+			} else {
+				text.WriteByte('.')
+			}
+		}
+		lines = append(lines, fmt.Sprintf("0x%04X  %s |%s|", addr, hex.String(), text.String()))
+	}
+	return lines
+}
+
+// drawScreen draws the frame buffer two rows of pixels to a line. The hi-res
+// screen is halved in each direction so that it takes up the same room as the
+// lo-res one, with a character lit if any of the pixels it covers are.
+func drawScreen(fb *chip8.FrameBuffer) []string {
+	scale := 1
+	if fb.Hires() {
+		scale = 2
+	}
+	lit := func(x, y int) bool {
+		for dy := 0; dy < scale; dy++ {
+			for dx := 0; dx < scale; dx++ {
+				if x*scale+dx < fb.Width && y*scale+dy < fb.Height && fb.Pixel(x*scale+dx, y*scale+dy) > 0 {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	width, height := fb.Width/scale, fb.Height/scale
+	var lines []string
+	for y := 0; y < height; y += 2 {
+		var line strings.Builder
+		for x := 0; x < width; x++ {
+			top, bottom := lit(x, y), lit(x, y+1)
+			switch {
+			case top && bottom:
+				line.WriteString("█")
+			case top:
+				line.WriteString("▀")
+			case bottom:
+				line.WriteString("▄")
+			default:
+				line.WriteString(" ")
+			}
+		}
+		lines = append(lines, line.String())
+	}
+	return lines
+}
+
+func header(title string) string {
+	return "── " + title + " " + strings.Repeat("─", 20)
+}
+
+// fit pads or cuts s to width characters.
+func fit(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) > width {
+		return string(runes[:width])
+	}
+	return s + strings.Repeat(" ", width-len(runes))
+}
@@ -0,0 +1,382 @@
+// Package tui is a full screen terminal debugger for a Chip8. It shows panes
+// for the disassembly around the program counter, the registers, timers and
+// call stack, a hex view of memory, the breakpoints, the source line being run
+// if the Chip8 has Symbols and, optionally, the screen, and takes single key
+// commands to step and run the program.
+//
+// The terminal is only used for the debugger, so the game's own keyboard input
+// has to come from a frontend with a window of its own, such as sdl.
+package tui
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zabrahams/gochip8/chip8"
+	"github.com/zabrahams/gochip8/debugger"
+	"github.com/zabrahams/gochip8/internal/terminal"
+)
+
+// REDRAW_PERIOD is how often the screen pane is redrawn while the Chip8 runs.
+const REDRAW_PERIOD = 50 * time.Millisecond
+
+// LOG_LINES is the number of lines of output kept for the output pane.
+const LOG_LINES = 100
+
+const keyHelp = "s step  n over  o out  r run/pause  b back  R run back  B break at PC  [ ] memory  i mem at I  : command  q quit"
+
+// TUI is the terminal debugger. Create one with New, call Start, and then call
+// Update regularly, e.g. from the loop that polls the frontend, until it
+// returns false. Close puts the terminal back.
+//
+// Command: if set, runs a command typed at the : prompt and returns its
+// output
+//
+// History: if set, returns the commands run so far, which the up and down
+// keys step through at the prompt
+//
+// ShowScreen: draw the frame buffer in a pane, for when nothing else is
+// showing it
+type TUI struct {
+	Command    func(line string) (string, error)
+	History    func() []string
+	ShowScreen bool
+
+	c8       *chip8.Chip8
+	debugger *debugger.Debugger
+	out      *bufio.Writer
+	keys     chan string
+	restore  func()
+	// faults is the channel returned by Run while the Chip8 is running, and
+	// nil otherwise.
+	faults    <-chan error
+	memAddr   uint16
+	prompting bool
+	prompt    string
+	// recalled is how far back through the history the prompt is.
+	recalled int
+	log      []string
+	quit     bool
+	dirty    bool
+	lastDraw time.Time
+	rows     int
+	cols     int
+	// panes are the panes drawn when the Chip8 last stopped. They're kept for
+	// while it runs, since the registers can't be read then.
+	panes []string
+	// sources are the lines of the source files shown so far, or nil for
+	// those that couldn't be read.
+	sources map[string][]string
+}
+
+func New(c8 *chip8.Chip8, d *debugger.Debugger, out io.Writer) *TUI {
+	return &TUI{
+		c8:       c8,
+		debugger: d,
+		out:      bufio.NewWriter(out),
+		keys:     make(chan string, 16),
+		memAddr:  chip8.PROGRAM_OFFSET,
+		dirty:    true,
+		sources:  map[string][]string{},
+	}
+}
+
+// Start puts the terminal into raw mode, switches to the alternate screen and
+// starts reading keys from stdin.
+func (t *TUI) Start() error {
+	restore, err := terminal.RawMode()
+	if err != nil {
+		return err
+	}
+	t.restore = restore
+	t.rows, t.cols = terminal.Size()
+	t.out.WriteString("\x1b[?1049h\x1b[?25l")
+	t.out.Flush()
+	go t.readKeys(os.Stdin)
+	t.Logf("%s", keyHelp)
+	return nil
+}
+
+// Close stops the Chip8 if it's running and puts the terminal back.
+func (t *TUI) Close() {
+	t.Pause()
+	t.out.WriteString("\x1b[?25h\x1b[?1049l")
+	t.out.Flush()
+	if t.restore != nil {
+		t.restore()
+	}
+}
+
+// Running reports whether the Chip8 is running.
+func (t *TUI) Running() bool {
+	return t.faults != nil
+}
+
+// Logf adds a line to the output pane.
+func (t *TUI) Logf(format string, args ...interface{}) {
+	for _, line := range strings.Split(fmt.Sprintf(format, args...), "\n") {
+		t.log = append(t.log, strings.ReplaceAll(line, "\t", "    "))
+	}
+	if len(t.log) > LOG_LINES {
+		t.log = t.log[len(t.log)-LOG_LINES:]
+	}
+	t.dirty = true
+}
+
+// Update handles any keys that have been pressed and notices if the Chip8 has
+// stopped, then redraws the terminal if anything has changed. It returns false
+// once the user has quit.
+func (t *TUI) Update() bool {
+	for more := true; more; {
+		select {
+		case key := <-t.keys:
+			t.key(key)
+			t.dirty = true
+		case err, ok := <-t.faults:
+			t.faults = nil
+			t.debugger.Until("", nil)
+			if ok {
+				t.stopped(err)
+			}
+			t.dirty = true
+		default:
+			more = false
+		}
+	}
+	if t.dirty || t.Running() && t.ShowScreen && time.Since(t.lastDraw) > REDRAW_PERIOD {
+		t.draw()
+	}
+	return !t.quit
+}
+
+// Pause stops the Chip8 if it's running.
+func (t *TUI) Pause() {
+	faults := t.faults
+	if faults == nil {
+		return
+	}
+	t.faults = nil
+	t.debugger.Until("", nil)
+	t.dirty = true
+	select {
+	case t.c8.Stop <- struct{}{}:
+		t.Logf("paused")
+	case err, ok := <-faults:
+		if ok {
+			t.stopped(err)
+		}
+	}
+}
+
+// escapes are the escape sequences readKeys understands.
+var escapes = map[string]string{
+	"\x1b[A":  "up",
+	"\x1b[B":  "down",
+	"\x1b[5~": "pgup",
+	"\x1b[6~": "pgdn",
+}
+
+// readKeys sends each key read from r on t.keys. Escape sequences for the
+// arrow and page keys are sent as "up", "down", "pgup" and "pgdn".
+func (t *TUI) readKeys(r io.Reader) {
+	buf := make([]byte, 64)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+		chunk := string(buf[:n])
+		for chunk != "" {
+			key := ""
+			for seq, name := range escapes {
+				if strings.HasPrefix(chunk, seq) {
+					key, chunk = name, chunk[len(seq):]
+					break
+				}
+			}
+			if key == "" {
+				r, size := utf8.DecodeRuneInString(chunk)
+				key, chunk = string(r), chunk[size:]
+			}
+			t.keys <- key
+		}
+	}
+}
+
+// key runs the command bound to key, or edits the prompt if it's open.
+func (t *TUI) key(key string) {
+	if t.prompting {
+		t.promptKey(key)
+		return
+	}
+	switch key {
+	case "s":
+		if t.stoppedOnly() {
+			t.step()
+		}
+	case "n":
+		if t.stoppedOnly() {
+			if cond := debugger.OverCall(t.c8); cond != nil {
+				t.debugger.Until("stepped over", cond)
+				t.run()
+			} else {
+				t.step()
+			}
+		}
+	case "o":
+		if t.stoppedOnly() {
+			cond := debugger.OutOfCall(t.c8)
+			if cond == nil {
+				t.Logf("not in a subroutine")
+				return
+			}
+			t.debugger.Until("stepped out", cond)
+			t.run()
+		}
+	case "r", " ":
+		if t.Running() {
+			t.Pause()
+		} else {
+			t.run()
+		}
+	case "b":
+		if t.stoppedOnly() {
+			if err := t.debugger.ReverseStep(); err != nil {
+				t.Logf("%v", err)
+			}
+		}
+	case "R":
+		if t.stoppedOnly() {
+			t.Logf("%v", t.debugger.ReverseContinue())
+		}
+	case "B":
+		if t.stoppedOnly() {
+			t.toggleBreakpoint(t.c8.Registers().PC)
+		}
+	case "[", "up":
+		t.memAddr -= 0x10
+	case "]", "down":
+		t.memAddr += 0x10
+	case "pgup":
+		t.memAddr -= 0x80
+	case "pgdn":
+		t.memAddr += 0x80
+	case "i":
+		if t.stoppedOnly() {
+			t.memAddr = t.c8.Registers().I &^ 0xF
+		}
+	case ":":
+		if t.Command == nil {
+			t.Logf("no commands available")
+			return
+		}
+		t.prompting, t.prompt, t.recalled = true, "", 0
+	case "q":
+		t.quit = true
+	case "h", "?":
+		t.Logf("%s", keyHelp)
+	}
+}
+
+// promptKey edits the command prompt, running the command on enter.
+func (t *TUI) promptKey(key string) {
+	switch key {
+	case "\n", "\r":
+		t.prompting = false
+		if strings.TrimSpace(t.prompt) == "" {
+			return
+		}
+		t.Logf(": %s", t.prompt)
+		if t.Running() {
+			// Commands read and change the machine, so it has to be stopped.
+			t.Pause()
+		}
+		out, err := t.Command(t.prompt)
+		if out != "" {
+			t.Logf("%s", strings.TrimRight(out, "\n"))
+		}
+		if err != nil {
+			t.Logf("%v", err)
+		}
+	case "\x1b":
+		t.prompting = false
+	case "up", "down":
+		if t.History == nil {
+			return
+		}
+		history := t.History()
+		if key == "up" && t.recalled < len(history) {
+			t.recalled++
+		} else if key == "down" && t.recalled > 0 {
+			t.recalled--
+		}
+		t.prompt = ""
+		if t.recalled > 0 {
+			t.prompt = history[len(history)-t.recalled]
+		}
+	case "\x7f", "\b":
+		if t.prompt != "" {
+			runes := []rune(t.prompt)
+			t.prompt = string(runes[:len(runes)-1])
+		}
+	default:
+		if len(key) == 1 && key[0] >= ' ' || len(key) > 1 && key[0] >= 0x80 {
+			t.prompt += key
+		}
+	}
+}
+
+// stoppedOnly reports whether the Chip8 is stopped, and logs that it has to be
+// if it isn't.
+func (t *TUI) stoppedOnly() bool {
+	if t.Running() {
+		t.Logf("pause the program first")
+		return false
+	}
+	return true
+}
+
+func (t *TUI) step() {
+	if err := t.debugger.Step(); err != nil {
+		t.stopped(err)
+	}
+}
+
+func (t *TUI) run() {
+	t.faults = t.c8.Run()
+}
+
+// stopped logs why the Chip8 stopped.
+func (t *TUI) stopped(err error) {
+	var execErr *chip8.ExecError
+	switch {
+	case errors.Is(err, chip8.ErrBreak) && errors.As(err, &execErr):
+		t.Logf("%v", execErr.Err)
+	case errors.Is(err, chip8.ErrExit):
+		t.Logf("program exited")
+	default:
+		t.Logf("fault: %v", err)
+	}
+}
+
+// toggleBreakpoint deletes the breakpoints at addr, or adds one if there
+// aren't any.
+func (t *TUI) toggleBreakpoint(addr uint16) {
+	deleted := false
+	for _, bp := range t.debugger.Breakpoints() {
+		if bp.Kind == debugger.BreakPC && bp.Addr == addr {
+			t.debugger.Delete(bp.ID)
+			t.Logf("deleted %v", bp)
+			deleted = true
+		}
+	}
+	if !deleted {
+		t.Logf("added %v", t.debugger.Break(addr, nil))
+	}
+}